@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/tikibu/rostore/handler"
+	"github.com/tikibu/rostore/store"
+)
+
+// Config declares db 0 at the top level, like a single store, and
+// optionally more databases. With a manifest URL, stores are declared by
+// the manifest instead, see Manifest.
+type Config struct {
+	StoreConfig
+	Databases []DatabaseConfig `json:"databases,omitempty"`
+	// ManifestURL is polled for new versions, which are downloaded and
+	// served once they are verified
+	ManifestURL string `json:"manifest_url,omitempty"`
+	// ManifestVersion serves a version of the manifest kept in the cache
+	// instead of the latest one, to roll back without a download
+	ManifestVersion string `json:"manifest_version,omitempty"`
+}
+
+// DatabaseConfig is a store served as a numbered database. A named one
+// can be selected by its name too.
+type DatabaseConfig struct {
+	DB   int    `json:"db"`
+	Name string `json:"name,omitempty"`
+	StoreConfig
+}
+
+// databases returns all declared databases
+func (c Config) databases() ([]DatabaseConfig, error) {
+	databases := []DatabaseConfig{}
+	if len(c.layers()) > 0 {
+		databases = append(databases, DatabaseConfig{DB: 0, StoreConfig: c.StoreConfig})
+	}
+	databases = append(databases, c.Databases...)
+
+	numbers := map[int]bool{}
+	names := map[string]bool{}
+	for _, db := range databases {
+		if db.DB < 0 {
+			return nil, fmt.Errorf("db %d: db numbers can't be negative", db.DB)
+		}
+		if numbers[db.DB] {
+			return nil, fmt.Errorf("db %d is declared twice", db.DB)
+		}
+		numbers[db.DB] = true
+		if db.Name == "" {
+			continue
+		}
+		if db.DB == 0 {
+			return nil, fmt.Errorf("db 0 can't be named")
+		}
+		if names[db.Name] {
+			return nil, fmt.Errorf("db name %s is declared twice", db.Name)
+		}
+		names[db.Name] = true
+	}
+	if len(databases) == 0 {
+		return nil, fmt.Errorf("records file name is empty")
+	}
+	return databases, nil
+}
+
+// loadedDatabase is what a db was loaded from
+type loadedDatabase struct {
+	config   DatabaseConfig
+	modTimes map[string]time.Time
+}
+
+// fileModTimes returns modification times of the files of a store, the
+// bloom filter files read with indexes included. Missing files and files
+// at URLs have the zero time, the latter are only reloaded when the config
+// changes.
+func fileModTimes(storeConfig StoreConfig) map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, layer := range storeConfig.layers() {
+		fileNames := []string{layer.RecordsFileName, layer.IndexFileName}
+		if layer.IndexFileName != "" {
+			fileNames = append(fileNames, bloomFilterFileName(layer.IndexFileName))
+		}
+		for _, fileName := range fileNames {
+			if fileName == "" {
+				continue
+			}
+			if store.IsURL(fileName) {
+				modTimes[fileName] = time.Time{}
+				continue
+			}
+			if stats, err := os.Stat(fileName); err == nil {
+				modTimes[fileName] = stats.ModTime()
+			} else {
+				modTimes[fileName] = time.Time{}
+			}
+		}
+	}
+	return modTimes
+}
+
+// databaseLoader loads databases into the handler, and on a config
+// change reloads only the databases whose config or files changed
+type databaseLoader struct {
+	handler     *handler.Handler
+	gracePeriod time.Duration
+	loaded      map[int]loadedDatabase
+	// storeConfig is the config stores are loaded with
+	storeConfig store.Config
+
+	// with writableOverlay, databases are served from writable overlays,
+	// which are discarded on reload unless keepOverlay is set
+	writableOverlay bool
+	keepOverlay     bool
+	overlays        map[int]*store.Overlay
+}
+
+func newDatabaseLoader(handler *handler.Handler, gracePeriod time.Duration) *databaseLoader {
+	return &databaseLoader{
+		handler:     handler,
+		gracePeriod: gracePeriod,
+		loaded:      map[int]loadedDatabase{},
+		storeConfig: store.DefaultConfig(),
+		overlays:    map[int]*store.Overlay{},
+	}
+}
+
+// serve returns what a newly loaded store of a db is served as, and its
+// overlay if it has one. The overlay is only kept once it's served.
+func (l *databaseLoader) serve(db int, store_ store.Backend) (store.Backend, *store.Overlay) {
+	if !l.writableOverlay {
+		return store_, nil
+	}
+	overlay, ok := l.overlays[db]
+	if ok && l.keepOverlay {
+		overlay = overlay.Rebase(store_)
+	} else {
+		overlay = store.NewOverlay(store_)
+	}
+	return overlay, overlay
+}
+
+// load loads new and changed databases. A database that fails to load
+// keeps being served from its previous store, and the first error is
+// returned. Databases removed from the config are served until restart,
+// and a database keeps the name it was first loaded with.
+func (l *databaseLoader) load(config Config) (err error) {
+	databases, err := config.databases()
+	if err != nil {
+		return err
+	}
+
+	for _, db := range databases {
+		modTimes := fileModTimes(db.StoreConfig)
+		if loaded, ok := l.loaded[db.DB]; ok && reflect.DeepEqual(loaded.config, db) && reflect.DeepEqual(loaded.modTimes, modTimes) {
+			continue
+		}
+
+		loadedStore, loadErr := loadStore(db.StoreConfig, l.storeConfig)
+		if loadErr != nil {
+			loadErr = fmt.Errorf("failed to load db %d: %w", db.DB, loadErr)
+			log.Println(loadErr)
+			if err == nil {
+				err = loadErr
+			}
+			continue
+		}
+		store_, overlay := l.serve(db.DB, loadedStore)
+
+		if l.handler.HasDatabase(db.DB) {
+			loadErr = l.handler.ReplaceStore(db.DB, store_, l.gracePeriod)
+		} else {
+			loadErr = l.handler.AddDatabase(db.DB, db.Name, store_)
+		}
+		if loadErr != nil {
+			store_.Close()
+			if err == nil {
+				err = loadErr
+			}
+			continue
+		}
+		// a failed swap keeps the overlay being served to rebase from
+		if overlay != nil {
+			l.overlays[db.DB] = overlay
+		}
+		l.loaded[db.DB] = loadedDatabase{config: db, modTimes: modTimes}
+		log.Printf("Loaded db %d from %d layer(s)", db.DB, len(db.layers()))
+	}
+	return err
+}
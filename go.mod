@@ -2,16 +2,19 @@ module github.com/tikibu/rostore
 
 go 1.17
 
-require github.com/tidwall/redcon v1.6.0
+require (
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/klauspost/compress v1.15.15
+	github.com/stretchr/testify v1.8.0
+	github.com/tidwall/match v1.1.1
+	github.com/tidwall/redcon v1.6.0
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/go-redis/redis/v8 v8.11.5 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.8.0 // indirect
 	github.com/tidwall/btree v1.1.0 // indirect
-	github.com/tidwall/match v1.1.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
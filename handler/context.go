@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// disconnectPollInterval is how often the connection of a command that's
+// still running is checked for a disconnected client
+const disconnectPollInterval = 50 * time.Millisecond
+
+// session is the state of a connection, kept as its context
+type session struct {
+	// db is the database selected by the connection, nil for the default
+	db *database
+	// ctx is the context of the command being served
+	ctx context.Context
+}
+
+func (h *Handler) session(conn redcon.Conn) *session {
+	if session, ok := conn.Context().(*session); ok {
+		return session
+	}
+	session := &session{}
+	conn.SetContext(session)
+	return session
+}
+
+// ctx returns the context reads of the command being served should use.
+// It's done once the command exceeded CommandTimeout, or its client
+// disconnected. Only reads with a deadline are abandoned in flight, scans
+// stop at their next check either way.
+func (h *Handler) ctx(conn redcon.Conn) context.Context {
+	if session, ok := conn.Context().(*session); ok && session.ctx != nil {
+		return session.ctx
+	}
+	return context.Background()
+}
+
+// withContext serves a command with a context, see ctx. A connection serves
+// its commands one at a time, so its session holds the context of the
+// current one.
+func (h *Handler) withContext(handle redcon.HandlerFunc) redcon.HandlerFunc {
+	return func(conn redcon.Conn, cmd redcon.Command) {
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if h.CommandTimeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), h.CommandTimeout)
+		} else {
+			ctx, cancel = context.WithCancel(context.Background())
+		}
+		defer cancel()
+		if netConn := conn.NetConn(); netConn != nil {
+			command := h.running.start(netConn, cancel)
+			defer h.running.end(command)
+		}
+
+		session := h.session(conn)
+		session.ctx = ctx
+		defer func() { session.ctx = nil }()
+		handle(conn, cmd)
+	}
+}
+
+// runningCommands are the commands in flight of a handler. Commands are
+// served in the goroutine that reads their connection, so nothing else
+// would notice a client disconnecting: a single watcher checks the
+// connections of commands running for longer than disconnectPollInterval,
+// and cancels the commands of disconnected clients. It runs as long as
+// there are commands in flight.
+type runningCommands struct {
+	mutex    sync.Mutex
+	commands map[*runningCommand]struct{}
+	watching bool
+}
+
+type runningCommand struct {
+	netConn net.Conn
+	cancel  context.CancelFunc
+	started time.Time
+}
+
+// start registers a command, end must be called before it returns
+func (r *runningCommands) start(netConn net.Conn, cancel context.CancelFunc) *runningCommand {
+	command := &runningCommand{netConn: netConn, cancel: cancel, started: time.Now()}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.commands == nil {
+		r.commands = map[*runningCommand]struct{}{}
+	}
+	r.commands[command] = struct{}{}
+	if !r.watching {
+		r.watching = true
+		go r.watch()
+	}
+	return command
+}
+
+func (r *runningCommands) end(command *runningCommand) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.commands, command)
+}
+
+func (r *runningCommands) watch() {
+	for {
+		time.Sleep(disconnectPollInterval)
+
+		r.mutex.Lock()
+		if len(r.commands) == 0 {
+			r.watching = false
+			r.mutex.Unlock()
+			return
+		}
+		now := time.Now()
+		for command := range r.commands {
+			if now.Sub(command.started) >= disconnectPollInterval && peerClosed(command.netConn) {
+				command.cancel()
+			}
+		}
+		r.mutex.Unlock()
+	}
+}
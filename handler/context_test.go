@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+// stallingBackend never finishes reading a record, and reports why the
+// read was abandoned
+type stallingBackend struct {
+	*store.MemoryStore
+	abandoned chan error
+}
+
+func (s *stallingBackend) GetRecordContext(ctx context.Context, key string) (*store.Record, error) {
+	<-ctx.Done()
+	s.abandoned <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func newStallingBackend(t *testing.T) *stallingBackend {
+	memoryStore, err := store.NewMemoryStore(store.MockRecords())
+	assert.NoError(t, err)
+	return &stallingBackend{MemoryStore: memoryStore, abandoned: make(chan error, 1)}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	backend := newStallingBackend(t)
+	handler := NewHandler(backend)
+	handler.CommandTimeout = 50 * time.Millisecond
+	rdb := serveHandler(t, handler)
+
+	_, err := rdb.Get(context.Background(), "key0:string").Result()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+	assert.Equal(t, context.DeadlineExceeded, <-backend.abandoned)
+
+	// the connection goes on serving commands
+	assert.NoError(t, rdb.Ping(context.Background()).Err())
+}
+
+func TestClientDisconnectAbandonsCommand(t *testing.T) {
+	backend := newStallingBackend(t)
+	rdb := serveHandler(t, NewHandler(backend))
+
+	conn, err := net.Dial("tcp", rdb.Options().Addr)
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("*2\r\n$3\r\nGET\r\n$11\r\nkey0:string\r\n"))
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, conn.Close())
+
+	select {
+	case err := <-backend.abandoned:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command went on after its client disconnected")
+	}
+}
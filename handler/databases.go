@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"github.com/tikibu/rostore/store"
+)
+
+// database is a numbered, optionally named store
+type database struct {
+	number       int
+	name         string
+	currentStore atomic.Value // storeHolder
+}
+
+// storeHolder gives the atomic.Value the same concrete type
+// whatever the backend is
+type storeHolder struct {
+	backend store.Backend
+}
+
+func newDatabase(number int, name string, store store.Backend) *database {
+	db := &database{number: number, name: name}
+	db.currentStore.Store(storeHolder{store})
+	return db
+}
+
+func (db *database) store() store.Backend {
+	return db.currentStore.Load().(storeHolder).backend
+}
+
+// database returns the database selected by the connection
+func (h *Handler) database(conn redcon.Conn) *database {
+	if session, ok := conn.Context().(*session); ok && session.db != nil {
+		return session.db
+	}
+	return h.defaultDB
+}
+
+// Store returns the store commands of the connection are served from.
+// A command that reads several times should call it once, so that all
+// reads see the same store.
+func (h *Handler) Store(conn redcon.Conn) store.Backend {
+	return h.database(conn).store()
+}
+
+// AddDatabase serves store as db number, which may also be selected
+// by name if it's not empty
+func (h *Handler) AddDatabase(number int, name string, store store.Backend) error {
+	h.databasesMutex.Lock()
+	defer h.databasesMutex.Unlock()
+
+	if _, ok := h.databases[number]; ok {
+		return fmt.Errorf("db %d already exists", number)
+	}
+	if name != "" && h.lookupName(name) != nil {
+		return fmt.Errorf("db name %s is already taken", name)
+	}
+	h.databases[number] = newDatabase(number, name, store)
+	return nil
+}
+
+// HasDatabase reports whether db number is served
+func (h *Handler) HasDatabase(number int) bool {
+	h.databasesMutex.RLock()
+	defer h.databasesMutex.RUnlock()
+	_, ok := h.databases[number]
+	return ok
+}
+
+func (h *Handler) lookupName(name string) *database {
+	for _, db := range h.databases {
+		if db.name == name {
+			return db
+		}
+	}
+	return nil
+}
+
+// SetNewStore atomically swaps the store db number is served from, and
+// returns the previous one. Commands already running keep the store
+// they started with.
+func (h *Handler) SetNewStore(number int, newStore store.Backend) (previous store.Backend, err error) {
+	h.databasesMutex.RLock()
+	db, ok := h.databases[number]
+	h.databasesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no db %d", number)
+	}
+	return db.currentStore.Swap(storeHolder{newStore}).(storeHolder).backend, nil
+}
+
+// ReplaceStore swaps the store of db number, and closes the previous one
+// after the grace period, which commands in flight on it have to finish in
+func (h *Handler) ReplaceStore(number int, newStore store.Backend, gracePeriod time.Duration) error {
+	previous, err := h.SetNewStore(number, newStore)
+	if err != nil {
+		return err
+	}
+	go func() {
+		time.Sleep(gracePeriod)
+		if err := previous.Close(); err != nil {
+			log.Printf("error closing a replaced store of db %d: %v", number, err)
+		}
+	}()
+	return nil
+}
+
+// sortedDatabases returns all databases ordered by number
+func (h *Handler) sortedDatabases() []*database {
+	h.databasesMutex.RLock()
+	defer h.databasesMutex.RUnlock()
+
+	databases := make([]*database, 0, len(h.databases))
+	for _, db := range h.databases {
+		databases = append(databases, db)
+	}
+	sort.Slice(databases, func(i, j int) bool {
+		return databases[i].number < databases[j].number
+	})
+	return databases
+}
+
+// Select implements SELECT index. A database with a name can be selected
+// by its name too.
+func (h *Handler) Select(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	h.databasesMutex.RLock()
+	var db *database
+	if number, err := strconv.Atoi(string(cmd.Args[1])); err == nil {
+		db = h.databases[number]
+	} else {
+		db = h.lookupName(string(cmd.Args[1]))
+	}
+	h.databasesMutex.RUnlock()
+
+	if db == nil {
+		conn.WriteError("ERR DB index is out of range")
+		return
+	}
+	h.session(conn).db = db
+	conn.WriteString("OK")
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+func databasesAndClient(t *testing.T) (*Handler, *redis.Client) {
+	handler, rdb := handlerAndClient(t, mockStore(t))
+	assert.NoError(t, handler.AddDatabase(1, "features", storeFromRecords(t, []store.Record{
+		{Key: "feature", Type: store.StringType, StringRecord: &store.StringRecord{Value: "on"}},
+	})))
+	return handler, rdb
+}
+
+// selectDB sends SELECT with any argument, redis.Conn only selects by number
+func selectDB(ctx context.Context, conn *redis.Conn, db string) error {
+	cmd := redis.NewStatusCmd(ctx, "select", db)
+	_ = conn.Process(ctx, cmd)
+	return cmd.Err()
+}
+
+func TestSelect(t *testing.T) {
+	_, rdb := databasesAndClient(t)
+
+	ctx := context.Background()
+	// pin a single connection, as SELECT is per connection
+	conn := rdb.Conn(ctx)
+	defer conn.Close()
+
+	_, err := conn.Get(ctx, "feature").Result()
+	assert.Error(t, err)
+
+	assert.NoError(t, conn.Select(ctx, 1).Err())
+	value, err := conn.Get(ctx, "feature").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "on", value)
+	size, err := conn.DBSize(ctx).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+
+	assert.NoError(t, selectDB(ctx, conn, "0"))
+	size, err = conn.DBSize(ctx).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), size)
+
+	// a named db is selected by its name too
+	assert.NoError(t, selectDB(ctx, conn, "features"))
+	exists, err := conn.Exists(ctx, "feature").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), exists)
+
+	assert.EqualError(t, conn.Select(ctx, 2).Err(), "ERR DB index is out of range")
+	assert.EqualError(t, selectDB(ctx, conn, "nosuchdb"), "ERR DB index is out of range")
+}
+
+func TestClientURLDB(t *testing.T) {
+	_, rdb := databasesAndClient(t)
+
+	ctx := context.Background()
+	options, err := redis.ParseURL("redis://" + rdb.Options().Addr + "/1")
+	assert.NoError(t, err)
+	client := redis.NewClient(options)
+	defer client.Close()
+
+	value, err := client.Get(ctx, "feature").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "on", value)
+}
+
+func TestInfoKeyspaceDatabases(t *testing.T) {
+	_, rdb := databasesAndClient(t)
+
+	info, err := rdb.Info(context.Background(), "keyspace").Result()
+	assert.NoError(t, err)
+	assert.Contains(t, info, "db0:keys=50,expires=0,avg_ttl=0\r\ndb1:keys=1,expires=0,avg_ttl=0\r\n")
+}
+
+func TestReplaceDatabaseStore(t *testing.T) {
+	handler, rdb := databasesAndClient(t)
+
+	ctx := context.Background()
+	conn := rdb.Conn(ctx)
+	defer conn.Close()
+	assert.NoError(t, conn.Select(ctx, 1).Err())
+
+	assert.NoError(t, handler.ReplaceStore(1, storeFromRecords(t, []store.Record{
+		{Key: "feature", Type: store.StringType, StringRecord: &store.StringRecord{Value: "off"}},
+	}), time.Millisecond))
+
+	// the selected db serves the new store, other databases are untouched
+	value, err := conn.Get(ctx, "feature").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "off", value)
+	size, err := rdb.DBSize(ctx).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(50), size)
+
+	assert.Error(t, handler.ReplaceStore(2, store.NewEmptyStore(), time.Millisecond))
+	assert.Error(t, handler.AddDatabase(1, "", store.NewEmptyStore()))
+	assert.Error(t, handler.AddDatabase(3, "features", store.NewEmptyStore()))
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package handler
+
+import "net"
+
+// peerClosed can't tell whether the client closed the connection where
+// sockets can't be peeked at, commands only end with their deadline there
+func peerClosed(netConn net.Conn) bool {
+	return false
+}
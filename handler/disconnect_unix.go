@@ -0,0 +1,31 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package handler
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerClosed tells whether the client closed the connection. It peeks at
+// the socket, so pipelined commands are left for the connection to read.
+func peerClosed(netConn net.Conn) bool {
+	syscallConn, ok := netConn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	closed := false
+	buf := make([]byte, 1)
+	rawConn.Read(func(fd uintptr) bool {
+		n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		closed = (n == 0 && err == nil) || err == syscall.ECONNRESET
+		return true
+	})
+	return closed
+}
@@ -7,19 +7,38 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/tidwall/redcon"
 	"github.com/tikibu/rostore/store"
 )
 
 type Handler struct {
-	Store   *store.Store
-	Cursors map[string]map[int]string
+	// databases are looked up by SELECT and INFO, commands are served from
+	// the database the connection selected, db 0 by default
+	databasesMutex sync.RWMutex
+	databases      map[int]*database
+	defaultDB      *database
+	Cursors        map[string]map[int]string
+	// CommandTimeout is how long a command can read for, reads are
+	// abandoned after it. 0 is no timeout.
+	CommandTimeout time.Duration
+	// running commands are watched for disconnected clients
+	running runningCommands
 }
 
-func NewHandler(store *store.Store) *Handler {
-	return &Handler{Store: store, Cursors: make(map[string]map[int]string)}
+// NewHandler serves store as db 0, more databases can be added with
+// AddDatabase
+func NewHandler(store store.Backend) *Handler {
+	handler := &Handler{
+		databases: make(map[int]*database),
+		Cursors:   make(map[string]map[int]string),
+	}
+	handler.defaultDB = newDatabase(0, "", store)
+	handler.databases[0] = handler.defaultDB
+	return handler
 }
 
 // Creates new handler with an empty Store
@@ -28,10 +47,6 @@ func NewHandlerEmptyStore() *Handler {
 	return NewHandler(store.NewEmptyStore())
 }
 
-func (h *Handler) SetNewStore(store *store.Store) {
-	h.Store = store // race condition that does not matter
-}
-
 func (h *Handler) Detach(conn redcon.Conn, cmd redcon.Command) {
 	detachedConn := conn.Detach()
 	log.Printf("connection has been detached")
@@ -52,21 +67,35 @@ func (h *Handler) Quit(conn redcon.Conn, cmd redcon.Command) {
 	conn.Close()
 }
 
-func (h *Handler) Set(conn redcon.Conn, cmd redcon.Command) {
-	conn.WriteError("Unsupported command")
-	/*
-		if len(cmd.Args) != 3 {
-			conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command")
-			return
-		}
+const wrongTypeError = "WRONGTYPE Operation against a key holding the wrong kind of value"
 
-		h.itemsMux.Lock()
-		h.items[string(cmd.Args[1])] = cmd.Args[2]
-		h.itemsMux.Unlock()
+func wrongNumberOfArguments(conn redcon.Conn, cmd redcon.Command) {
+	conn.WriteError("ERR wrong number of arguments for '" + strings.ToLower(string(cmd.Args[0])) + "' command")
+}
 
-		conn.WriteString("OK")
-	*/
+// getTypedRecord fetches a record and checks that it holds recordType.
+// Errors are written to the connection, and ok is false then.
+// A missing key is not an error: ok is true and the record is nil,
+// as Redis treats missing keys as empty values.
+func (h *Handler) getTypedRecord(conn redcon.Conn, key string, recordType string) (record *store.Record, ok bool) {
+	record, err := h.Store(conn).GetRecordContext(h.ctx(conn), key)
+	if err == store.ErrKeyNotFound {
+		return nil, true
+	}
+
+	if err != nil {
+		conn.WriteError(fmt.Sprintf("ERR occurred while retrieving record for key %s", err.Error()))
+		return nil, false
+	}
+
+	if record.Type != recordType {
+		conn.WriteError(wrongTypeError)
+		return nil, false
+	}
+
+	return record, true
 }
+
 func printCmd(cmd redcon.Command) {
 	fmt.Println("Command: ", string(cmd.Args[0]))
 	for i, arg := range cmd.Args {
@@ -80,7 +109,7 @@ func (h *Handler) Get(conn redcon.Conn, cmd redcon.Command) {
 		return
 	}
 
-	record, err := h.Store.GetRecord(string(cmd.Args[1]))
+	record, err := h.Store(conn).GetRecordContext(h.ctx(conn), string(cmd.Args[1]))
 	if err == store.ErrKeyNotFound {
 		conn.WriteError("ERR no such key")
 		return
@@ -111,7 +140,7 @@ func (h *Handler) Type(conn redcon.Conn, cmd redcon.Command) {
 		return
 	}
 
-	record, err := h.Store.GetRecordIndex(string(cmd.Args[1]))
+	recordType, err := h.Store(conn).GetType(string(cmd.Args[1]))
 	if err == store.ErrKeyNotFound {
 		conn.WriteError("ERR no such key")
 		return
@@ -122,7 +151,7 @@ func (h *Handler) Type(conn redcon.Conn, cmd redcon.Command) {
 		return
 	}
 
-	conn.WriteBulkString(record.Type)
+	conn.WriteBulkString(recordType)
 }
 
 func (h *Handler) MemoryUsage(conn redcon.Conn, cmd redcon.Command) {
@@ -138,7 +167,7 @@ func (h *Handler) MemoryUsage(conn redcon.Conn, cmd redcon.Command) {
 		return
 	}
 
-	record, err := h.Store.GetRecordIndex(string(cmd.Args[2]))
+	record, err := h.Store(conn).GetRecordIndex(string(cmd.Args[2]))
 	if err == store.ErrKeyNotFound {
 		conn.WriteError("ERR no such key")
 		return
@@ -160,7 +189,7 @@ func (h *Handler) HLen(conn redcon.Conn, cmd redcon.Command) {
 		return
 	}
 
-	record, err := h.Store.GetRecord(string(cmd.Args[1]))
+	record, err := h.Store(conn).GetRecordContext(h.ctx(conn), string(cmd.Args[1]))
 	if err == store.ErrKeyNotFound {
 		conn.WriteError("ERR no such key")
 		return
@@ -193,7 +222,7 @@ func (h *Handler) ZCard(conn redcon.Conn, cmd redcon.Command) {
 		return
 	}
 
-	record, err := h.Store.GetRecord(string(cmd.Args[1]))
+	record, err := h.Store(conn).GetRecordContext(h.ctx(conn), string(cmd.Args[1]))
 	if err == store.ErrKeyNotFound {
 		conn.WriteError("ERR no such key")
 		return
@@ -226,7 +255,7 @@ func (h *Handler) LLen(conn redcon.Conn, cmd redcon.Command) {
 		return
 	}
 
-	record, err := h.Store.GetRecord(string(cmd.Args[1]))
+	record, err := h.Store(conn).GetRecordContext(h.ctx(conn), string(cmd.Args[1]))
 	if err == store.ErrKeyNotFound {
 		conn.WriteError("ERR no such key")
 		return
@@ -251,88 +280,34 @@ func (h *Handler) LLen(conn redcon.Conn, cmd redcon.Command) {
 
 }
 
+// LRange implements LRANGE key start stop, both ends are inclusive,
+// negative indexes count from the end of the list
 func (h *Handler) LRange(conn redcon.Conn, cmd redcon.Command) {
 	printCmd(cmd)
 
-	if len(cmd.Args) < 3 {
-		conn.WriteError("ERR wrong number of arguments for '" + string(cmd.Args[0]) + "' command: " + strconv.Itoa(len(cmd.Args)))
+	if len(cmd.Args) != 4 {
+		wrongNumberOfArguments(conn, cmd)
 		return
 	}
 
 	start, err := strconv.Atoi(string(cmd.Args[2]))
 	if err != nil {
-		conn.WriteError("ERR occurred while parsing start ")
+		conn.WriteError(errNotInteger.Error())
 		return
 	}
 	stop, err := strconv.Atoi(string(cmd.Args[3]))
 	if err != nil {
-		conn.WriteError("ERR occurred while parsing stop ")
-		return
-	}
-
-	record, err := h.Store.GetRecord(string(cmd.Args[1]))
-	if err == store.ErrKeyNotFound {
-		conn.WriteError("ERR no such key")
-		return
-	}
-
-	if err != nil {
-		conn.WriteError(fmt.Sprintf("ERR occurred while retrieving record for key %s", err.Error()))
-		return
-	}
-
-	if record.Type != store.ListType {
-		conn.WriteError("ERR wrong type to call LRANGE for")
-		return
-	}
-
-	if record.ListRecord == nil {
-		conn.WriteError("ERR record is empty")
+		conn.WriteError(errNotInteger.Error())
 		return
 	}
 
-	if start < 0 {
-		start = len(record.ListRecord.Elements) + start
-	}
-
-	if start < 0 {
-		start = 0
-	}
-
-	if stop < 0 {
-		stop = len(record.ListRecord.Elements) + stop
-	}
-
-	if stop < 0 {
-		stop = 0
-	}
-
-	if stop > len(record.ListRecord.Elements) {
-		stop = len(record.ListRecord.Elements)
-	}
-
-	if start > len(record.ListRecord.Elements) {
-		conn.WriteArray(0)
-	}
-
-	if start > stop {
-		conn.WriteArray(0)
+	elements, ok := h.getList(conn, string(cmd.Args[1]))
+	if !ok {
 		return
 	}
 
-	fmt.Println("start", start, "stop", stop)
-	conn.WriteArray(stop - start)
-	for i := start; i < stop; i++ {
-		conn.WriteBulkString(record.ListRecord.Elements[i])
-	}
-
-}
-
-func (h *Handler) Delete(conn redcon.Conn, cmd redcon.Command) {
-	if len(cmd.Args) != 2 {
-		conn.WriteError("Unsupported command")
-		return
-	}
+	from, to := indexRange(start, stop, len(elements))
+	writeBulkStrings(conn, elements[from:to])
 }
 
 var section_sets = map[string][]string{
@@ -346,11 +321,11 @@ var info_template = map[string]*template.Template{
 	"clients":     template.Must(template.New("clients").Parse("connected_clients:1\r\nclient_recent_max_input_buffer:2\r\nclient_recent_max_output_buffer:0\r\nblocked_clients:0\r\n")),
 	"memory":      template.Must(template.New("memory").Parse("used_memory:{{.memory}}\r\nused_memory_human:{{.memory_human}}\r\nused_memory_rss:{{.memory}}\r\nused_memory_rss_human:{{.memory_human}}\r\nused_memory_peak:61684016\r\nused_memory_peak_human:58.83M\r\nused_memory_peak_perc:99.32%\r\nused_memory_overhead:31158374\r\nused_memory_startup:963824\r\nused_memory_dataset:30104714\r\nused_memory_dataset_perc:49.93%\r\ntotal_system_memory:17179869184\r\ntotal_system_memory_human:16.00G\r\nused_memory_lua:37888\r\nused_memory_lua_human:37.00K\r\nmaxmemory:0\r\nmaxmemory_human:0B\r\nmaxmemory_policy:noeviction\r\nmem_fragmentation_ratio:0.66\r\nmem_allocator:libc\r\nactive_defrag_running:0\r\nlazyfree_pending_objects:0\r\n")),
 	"persistence": template.Must(template.New("persistence").Parse("loading:0\r\nrdb_changes_since_last_save:0\r\nrdb_bgsave_in_progress:0\r\nrdb_last_save_time:1597150009\r\nrdb_last_bgsave_status:ok\r\nrdb_last_bgsave_time_sec:-1\r\nrdb_current_bgsave_time_sec:-1\r\nrdb_last_cow_size:0\r\naof_enabled:0\r\naof_rewrite_in_progress:0\r\naof_rewrite_scheduled:0\r\naof_last_rewrite_time_sec:-1\r\naof_current_rewrite_time_sec:-1\r\naof_last_bgrewrite_status:ok\r\naof_last_write_status:ok\r\naof_last_cow_size:0\r\nmodule_fork_in_progress:0\r\nmodule_fork_last_cow_size:0\r\n")),
-	"stats":       template.Must(template.New("stats").Parse("total_connections_received:1\r\ntotal_commands_processed:1\r\ninstantaneous_ops_per_sec:0\r\ntotal_net_input_bytes:7\r\ntotal_net_output_bytes:3\r\ninstantaneous_input_kbps:0.00\r\ninstantaneous_output_kbps:0.00\r\nrejected_connections:0\r\nsync_full:0\r\nsync_partial_ok:0\r\nsync_partial_err:0\r\nexpired_keys:0\r\nexpired_stale_perc:0.00\r\nexpired_time_cap_reached_count:0\r\nevicted_keys:0\r\nkeyspace_hits:0\r\nkeyspace_misses:0\r\npubsub_channels:0\r\npubsub_patterns:0\r\nlatest_fork_usec:0\r\nmigrate_cached_sockets:0\r\nslave_expires_tracked_keys:0\r\nactive_defrag_hits:0\r\nactive_defrag_misses:0\r\nactive_defrag_key_hits:0\r\nactive_defrag_key_misses:0\r\ntracking_total_keys:0\r\ntracking_total_items:0\r\ntracking_total_prefixes:0\r\nunexpected_error_replies:0\r\n")),
+	"stats":       template.Must(template.New("stats").Parse("total_connections_received:1\r\ntotal_commands_processed:1\r\ninstantaneous_ops_per_sec:0\r\ntotal_net_input_bytes:7\r\ntotal_net_output_bytes:3\r\ninstantaneous_input_kbps:0.00\r\ninstantaneous_output_kbps:0.00\r\nrejected_connections:0\r\nsync_full:0\r\nsync_partial_ok:0\r\nsync_partial_err:0\r\nexpired_keys:0\r\nexpired_stale_perc:0.00\r\nexpired_time_cap_reached_count:0\r\nevicted_keys:0\r\nkeyspace_hits:0\r\nkeyspace_misses:0\r\npubsub_channels:0\r\npubsub_patterns:0\r\nlatest_fork_usec:0\r\nmigrate_cached_sockets:0\r\nslave_expires_tracked_keys:0\r\nactive_defrag_hits:0\r\nactive_defrag_misses:0\r\nactive_defrag_key_hits:0\r\nactive_defrag_key_misses:0\r\ntracking_total_keys:0\r\ntracking_total_items:0\r\ntracking_total_prefixes:0\r\nunexpected_error_replies:0\r\nrecord_cache_hits:{{.cache.Hits}}\r\nrecord_cache_misses:{{.cache.Misses}}\r\nrecord_cache_keys:{{.cache.Entries}}\r\nrecord_cache_bytes:{{.cache.Bytes}}\r\nreader_pool_open:{{.pool.Open}}\r\nreader_pool_in_use:{{.pool.InUse}}\r\nreader_pool_max:{{.pool.MaxSize}}\r\nreader_pool_utilization_perc:{{.pool_utilization}}\r\nreader_pool_gets:{{.pool.Gets}}\r\nreader_pool_timeouts:{{.pool.Timeouts}}\r\nreader_pool_wait_usec:{{.pool.WaitTime.Microseconds}}\r\n")),
 	"replication": template.Must(template.New("replication").Parse("role:master\r\nconnected_slaves:0\r\nmaster_replid:0000000000000000000000000000000000000000\r\nmaster_replid2:0000000000000000000000000000000000000000\r\nmaster_repl_offset:0\r\nsecond_repl_offset:-1\r\nrepl_backlog_active:0\r\nrepl_backlog_size:1048576\r\nrepl_backlog_first_byte_offset:0\r\nrepl_backlog_histlen:0\r\n")),
 	"cpu":         template.Must(template.New("cpu").Parse("used_cpu_sys:181.06\r\nused_cpu_user:91.95\r\nused_cpu_sys_children:0.00\r\nused_cpu_user_children:0.00\r\n")),
 	"cluster":     template.Must(template.New("cluster").Parse("cluster_enabled:0\r\n")),
-	"keyspace":    template.Must(template.New("keyspace").Parse("db0:keys={{.number_of_keys}},expires=0,avg_ttl=0\r\n")),
+	"keyspace":    template.Must(template.New("keyspace").Parse("{{range .databases}}db{{.db}}:keys={{.keys}},expires=0,avg_ttl=0\r\n{{end}}")),
 	"modules":     template.Must(template.New("modules").Parse("\r\n")),
 }
 
@@ -363,15 +338,68 @@ func (h *Handler) getTemplateWith() map[string]interface{} {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
+	poolStats := h.readerPoolStats()
+	poolUtilization := "0.00"
+	if poolStats.MaxSize > 0 {
+		poolUtilization = fmt.Sprintf("%.2f", 100*float64(poolStats.InUse)/float64(poolStats.MaxSize))
+	}
+
 	info := map[string]interface{}{
-		"number_of_keys": h.Store.GetLen(),
-		"memory":         m.TotalAlloc,
-		"memory_human":   fmt.Sprintf("%.2fM", bytesToMegabytes(m.TotalAlloc)),
+		"databases":        h.keyspace(),
+		"cache":            h.cacheStats(),
+		"pool":             poolStats,
+		"pool_utilization": poolUtilization,
+		"memory":           m.TotalAlloc,
+		"memory_human":     fmt.Sprintf("%.2fM", bytesToMegabytes(m.TotalAlloc)),
 	}
 
 	return info
 }
 
+// readerPoolStats sums the reader pool counters of all dbs, for INFO stats.
+// Counters start over when a store is replaced, along with its pool.
+func (h *Handler) readerPoolStats() (stats store.PoolStats) {
+	for _, db := range h.sortedDatabases() {
+		if pooling, ok := db.store().(store.PoolingBackend); ok {
+			poolStats := pooling.ReaderPoolStats()
+			stats.Open += poolStats.Open
+			stats.InUse += poolStats.InUse
+			stats.MaxSize += poolStats.MaxSize
+			stats.Gets += poolStats.Gets
+			stats.Timeouts += poolStats.Timeouts
+			stats.WaitTime += poolStats.WaitTime
+		}
+	}
+	return stats
+}
+
+// keyspace lists the number of keys of each db, for INFO keyspace
+func (h *Handler) keyspace() []map[string]interface{} {
+	keyspace := []map[string]interface{}{}
+	for _, db := range h.sortedDatabases() {
+		keyspace = append(keyspace, map[string]interface{}{
+			"db":   db.number,
+			"keys": db.store().GetLen(),
+		})
+	}
+	return keyspace
+}
+
+// cacheStats sums the record cache counters of all dbs, for INFO stats.
+// Counters start over when a store is replaced, along with its cache.
+func (h *Handler) cacheStats() (stats store.CacheStats) {
+	for _, db := range h.sortedDatabases() {
+		if caching, ok := db.store().(store.CachingBackend); ok {
+			cacheStats := caching.CacheStats()
+			stats.Hits += cacheStats.Hits
+			stats.Misses += cacheStats.Misses
+			stats.Entries += cacheStats.Entries
+			stats.Bytes += cacheStats.Bytes
+		}
+	}
+	return stats
+}
+
 func (h *Handler) Scan(conn redcon.Conn, cmd redcon.Command) {
 	if len(cmd.Args) < 1 {
 		conn.WriteError("ERR too few parameters")
@@ -379,8 +407,10 @@ func (h *Handler) Scan(conn redcon.Conn, cmd redcon.Command) {
 	}
 	printCmd(cmd)
 
+	// the cursor is a position in the index of one store
+	store_ := h.Store(conn)
 	cursor := 0
-	count := h.Store.GetLen()
+	count := store_.GetLen()
 	match := "*"
 
 	for i := 1; i < len(cmd.Args); i++ {
@@ -417,7 +447,7 @@ func (h *Handler) Scan(conn redcon.Conn, cmd redcon.Command) {
 			continue
 		}
 	}
-	keys, cursor, err := h.Store.ScanFields(cursor, count, match)
+	keys, cursor, err := store_.ScanFieldsContext(h.ctx(conn), cursor, count, match)
 	if err != nil {
 		conn.WriteError(err.Error())
 		return
@@ -433,83 +463,43 @@ func (h *Handler) Scan(conn redcon.Conn, cmd redcon.Command) {
 }
 
 func (h *Handler) HScan(conn redcon.Conn, cmd redcon.Command) {
-	if len(cmd.Args) < 2 {
+	if len(cmd.Args) < 3 {
 		conn.WriteError("ERR too few parameters")
 		return
 	}
 	printCmd(cmd)
 
-	record, err := h.Store.GetRecord(string(cmd.Args[1]))
-	if err == store.ErrKeyNotFound {
-		conn.WriteError("ERR no such key")
+	cursor, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(fmt.Sprintf("ERR parsing cursor %s", err.Error()))
 		return
 	}
 
+	match, count, err := parseScanOptions(cmd.Args[3:])
 	if err != nil {
-		conn.WriteError(fmt.Sprintf("ERR occurred while retrieving record for key %s", err.Error()))
+		conn.WriteError(err.Error())
 		return
 	}
 
-	if record.Type != store.HashType {
-		conn.WriteError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
 		return
 	}
 
-	if record.HashRecord == nil {
-		conn.WriteError("ERR empty hash record")
-	}
-
-	cursor := 0
-	count := len(record.HashRecord.Fields)
-	match := "*"
-
-	for i := 1; i < len(cmd.Args); i++ {
-		if i == 2 {
-			var err error
-			cursor, err = strconv.Atoi(string(cmd.Args[i]))
-			if err != nil {
-				conn.WriteError(fmt.Sprintf("ERR parsing cursor %s", err.Error()))
-				return
-			}
-			continue
-		}
-
-		if string(cmd.Args[i]) == "count" {
-			if i+1 >= len(cmd.Args) {
-				conn.WriteError("ERR parsing COUNT")
-				return
-			}
-			var err error
-			count, err = strconv.Atoi(string(cmd.Args[i+1]))
-			if err != nil {
-				conn.WriteError(fmt.Sprintf("ERR parsing COUNT, %s", err.Error()))
-				return
-			}
-			continue
-		}
-
-		if string(cmd.Args[i]) == "match" {
-			if i+1 >= len(cmd.Args) {
-				conn.WriteError("ERR parsing match")
-				return
-			}
-			match = string(cmd.Args[i+1])
-			continue
+	var fields []string
+	if hash != nil {
+		fields, cursor, err = hash.ScanFields(cursor, count, match)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
 		}
-	}
-	fields, cursor, err := record.HashRecord.ScanFields(cursor, count, match)
-	if err != nil {
-		conn.WriteError(err.Error())
-		return
+	} else {
+		cursor = 0
 	}
 
 	conn.WriteArray(2)
 	conn.WriteString(strconv.Itoa(cursor))
-	conn.WriteArray(len(fields))
-	for _, field := range fields {
-		conn.WriteBulkString(field)
-	}
-
+	writeBulkStrings(conn, fields)
 }
 
 func (h *Handler) HGetAll(conn redcon.Conn, cmd redcon.Command) {
@@ -519,39 +509,21 @@ func (h *Handler) HGetAll(conn redcon.Conn, cmd redcon.Command) {
 	}
 	printCmd(cmd)
 
-	record, err := h.Store.GetRecord(string(cmd.Args[1]))
-	if err == store.ErrKeyNotFound {
-		conn.WriteError("ERR no such key")
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
 		return
 	}
-
-	if err != nil {
-		conn.WriteError(fmt.Sprintf("ERR occurred while retrieving record for key %s", err.Error()))
-		return
-	}
-
-	if record.Type != store.HashType {
-		conn.WriteError("WRONGTYPE Operation against a key holding the wrong kind of value")
-		return
-	}
-
-	if record.HashRecord == nil {
-		conn.WriteError("ERR empty hash record")
-	}
-
-	count := len(record.HashRecord.Fields)
-
-	fields, _, err := record.HashRecord.ScanFields(0, count, "*")
-	if err != nil {
-		conn.WriteError(err.Error())
+	if hash == nil {
+		conn.WriteArray(0)
 		return
 	}
 
-	conn.WriteArray(len(fields))
+	fields := hash.SortedFields()
+	conn.WriteArray(2 * len(fields))
 	for _, field := range fields {
 		conn.WriteBulkString(field)
+		conn.WriteBulkString(hash.Fields[field])
 	}
-
 }
 
 func (h *Handler) Info(conn redcon.Conn, cmd redcon.Command) {
@@ -597,25 +569,86 @@ func (h *Handler) Info(conn redcon.Conn, cmd redcon.Command) {
 }
 
 func (handler *Handler) SetUpMux(mux *redcon.ServeMux) {
-	mux.HandleFunc("detach", handler.Detach)
-	mux.HandleFunc("ping", handler.Ping)
-	mux.HandleFunc("quit", handler.Quit)
-	mux.HandleFunc("info", handler.Info)
-
-	mux.HandleFunc("scan", handler.Scan)
-	mux.HandleFunc("type", handler.Type)
-	mux.HandleFunc("memory", handler.MemoryUsage)
-
-	mux.HandleFunc("get", handler.Get)
+	// every command gets a context, done at its deadline or when its
+	// client disconnects
+	handle := func(command string, serve redcon.HandlerFunc) {
+		mux.HandleFunc(command, handler.withContext(serve))
+	}
+
+	handle("detach", handler.Detach)
+	handle("ping", handler.Ping)
+	handle("quit", handler.Quit)
+	handle("info", handler.Info)
+	handle("select", handler.Select)
+
+	handle("scan", handler.Scan)
+	handle("type", handler.Type)
+	handle("memory", handler.MemoryUsage)
+
+	handle("exists", handler.Exists)
+	handle("dbsize", handler.DBSize)
+	handle("keys", handler.Keys)
+	handle("randomkey", handler.RandomKey)
+
+	handle("get", handler.Get)
+	handle("mget", handler.MGet)
+	handle("strlen", handler.StrLen)
+	handle("getrange", handler.GetRange)
+	handle("substr", handler.GetRange)
+	handle("getex", handler.GetEx)
+	handle("getdel", handler.GetDel)
 	// hash specific commands
-	mux.HandleFunc("hlen", handler.HLen)
-	mux.HandleFunc("hscan", handler.HScan)
-	mux.HandleFunc("hgetall", handler.HGetAll)
+	handle("hlen", handler.HLen)
+	handle("hscan", handler.HScan)
+	handle("hgetall", handler.HGetAll)
+	handle("hget", handler.HGet)
+	handle("hmget", handler.HMGet)
+	handle("hexists", handler.HExists)
+	handle("hkeys", handler.HKeys)
+	handle("hvals", handler.HVals)
+	handle("hstrlen", handler.HStrLen)
+	handle("hrandfield", handler.HRandField)
 
 	// list specific commands
-	mux.HandleFunc("llen", handler.LLen)
-	mux.HandleFunc("lrange", handler.LRange)
+	handle("llen", handler.LLen)
+	handle("lrange", handler.LRange)
+	handle("lindex", handler.LIndex)
+	handle("lpos", handler.LPos)
+
+	// set specific commands
+	handle("smembers", handler.SMembers)
+	handle("sismember", handler.SIsMember)
+	handle("smismember", handler.SMIsMember)
+	handle("scard", handler.SCard)
+	handle("srandmember", handler.SRandMember)
+	handle("sscan", handler.SScan)
+	handle("sinter", handler.SInter)
+	handle("sunion", handler.SUnion)
+	handle("sdiff", handler.SDiff)
+
+	// write commands, served by a writable overlay only
+	handle("set", handler.Set)
+	handle("del", handler.Del)
+	handle("expire", handler.Expire)
+	handle("hset", handler.HSet)
+	handle("hdel", handler.HDel)
+	handle("lpush", handler.LPush)
+	handle("sadd", handler.SAdd)
+	handle("zadd", handler.ZAdd)
+	handle("rostore", handler.Rostore)
 
 	// zset specific commands
-	mux.HandleFunc("zcard", handler.ZCard)
+	handle("zcard", handler.ZCard)
+	handle("zrange", handler.ZRange)
+	handle("zrevrange", handler.ZRevRange)
+	handle("zrangebyscore", handler.ZRangeByScore)
+	handle("zrevrangebyscore", handler.ZRevRangeByScore)
+	handle("zrangebylex", handler.ZRangeByLex)
+	handle("zrevrangebylex", handler.ZRevRangeByLex)
+	handle("zscore", handler.ZScore)
+	handle("zmscore", handler.ZMScore)
+	handle("zrank", handler.ZRank)
+	handle("zrevrank", handler.ZRevRank)
+	handle("zcount", handler.ZCount)
+	handle("zlexcount", handler.ZLexCount)
 }
@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,8 +17,17 @@ import (
 )
 
 func mockStore(t *testing.T) *store.Store {
-	records := store.MockRecords()
+	return storeFromRecords(t, store.MockRecords())
+}
+
+// clientWithRecords serves the mock records with records added to them,
+// and returns a client of them
+func clientWithRecords(t *testing.T, records ...store.Record) *redis.Client {
+	_, rdb := storeAndClient(t, storeFromRecords(t, append(store.MockRecords(), records...)))
+	return rdb
+}
 
+func storeFromRecords(t *testing.T, records []store.Record) *store.Store {
 	// let's build a mock data jsonl file
 	recordsBytes := store.MockJsonlBytes(records)
 
@@ -39,41 +50,75 @@ func mockStore(t *testing.T) *store.Store {
 	return store
 }
 
-var next_port = 50000 - 1
-
-func get_next_addr() string {
-	next_port++
-	return fmt.Sprintf(":%d", next_port)
+func mockStoreAndClient(t *testing.T) (store *store.Store, rdb *redis.Client) {
+	return storeAndClient(t, mockStore(t))
 }
 
-func mockStoreAndClient(t *testing.T) (store *store.Store, rdb *redis.Client) {
-	store = mockStore(t)
+func storeAndClient(t *testing.T, store *store.Store) (*store.Store, *redis.Client) {
+	_, rdb := handlerAndClient(t, store)
+	return store, rdb
+}
 
+func handlerAndClient(t *testing.T, store store.Backend) (*Handler, *redis.Client) {
 	handler := NewHandler(store)
+	return handler, serveHandler(t, handler)
+}
 
+// serveHandler serves handler on a free port, and returns a client of it
+func serveHandler(t *testing.T, handler *Handler) *redis.Client {
 	mux := redcon.NewServeMux()
 	handler.SetUpMux(mux)
 
-	addr := get_next_addr()
+	// a free port is picked by the system, fixed ports can be taken by
+	// other sockets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// connections are counted, so that the listener is only closed once
+	// they are, closing it closes connections still serving commands
+	var connections sync.WaitGroup
 	go func() {
-		_ = redcon.ListenAndServe(addr,
+		_ = redcon.Serve(listener,
 			mux.ServeRESP,
 			func(conn redcon.Conn) bool {
+				connections.Add(1)
 				return true
 			},
 			func(conn redcon.Conn, err error) {
+				connections.Done()
 			},
 		)
 	}()
 
-	time.Sleep(time.Millisecond * 10)
-
-	rdb = redis.NewClient(&redis.Options{
-		Addr:     addr,
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     listener.Addr().String(),
 		Password: "", // no password set
 		DB:       0,  // use default DB
 	})
-	return store, rdb
+	t.Cleanup(func() {
+		rdb.Close()
+		closed := make(chan struct{})
+		go func() {
+			connections.Wait()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Error("connections are still open")
+		}
+		listener.Close()
+	})
+
+	// wait for the server to listen
+	for i := 0; i < 100; i++ {
+		if rdb.Ping(context.Background()).Err() == nil {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return rdb
 }
 
 func TestInfoKeyspace(t *testing.T) {
@@ -89,6 +134,56 @@ func TestInfoKeyspace(t *testing.T) {
 	assert.Contains(t, info, fmt.Sprintf("%d", store.GetLen()))
 }
 
+func TestInfoStatsRecordCache(t *testing.T) {
+	records := store.MockRecords()
+	config := store.DefaultConfig()
+	config.RecordCacheSize = 1 << 20
+	cachingStore, err := store.NewStoreFromRecordsWithConfig(func() (io.ReadSeekCloser, error) {
+		return store.NewReadSeekCloser(bytes.NewReader(store.MockJsonlBytes(records))), nil
+	}, config)
+	assert.NoError(t, err)
+	_, rdb := storeAndClient(t, cachingStore)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := rdb.HGetAll(ctx, "key1:hash").Result()
+		assert.NoError(t, err)
+	}
+
+	info, err := rdb.Info(ctx, "stats").Result()
+	assert.NoError(t, err)
+	assert.Contains(t, info, "record_cache_hits:2\r\n")
+	assert.Contains(t, info, "record_cache_misses:1\r\n")
+	assert.Contains(t, info, "record_cache_keys:1\r\n")
+}
+
+func TestInfoStatsReaderPool(t *testing.T) {
+	records := store.MockRecords()
+	config := store.DefaultConfig()
+	config.MaxConnections = 4
+	poolStore, err := store.NewStoreFromRecordsWithConfig(func() (io.ReadSeekCloser, error) {
+		return store.NewReadSeekCloser(bytes.NewReader(store.MockJsonlBytes(records))), nil
+	}, config)
+	assert.NoError(t, err)
+	_, rdb := storeAndClient(t, poolStore)
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := rdb.HGetAll(ctx, "key1:hash").Result()
+		assert.NoError(t, err)
+	}
+
+	info, err := rdb.Info(ctx, "stats").Result()
+	assert.NoError(t, err)
+	assert.Contains(t, info, "reader_pool_open:1\r\n")
+	assert.Contains(t, info, "reader_pool_in_use:0\r\n")
+	assert.Contains(t, info, "reader_pool_max:4\r\n")
+	assert.Contains(t, info, "reader_pool_utilization_perc:0.00\r\n")
+	assert.Contains(t, info, "reader_pool_gets:3\r\n")
+	assert.Contains(t, info, "reader_pool_timeouts:0\r\n")
+	assert.Contains(t, info, "reader_pool_wait_usec:")
+}
+
 func TestInfoMem(t *testing.T) {
 	_, rdb := mockStoreAndClient(t)
 
@@ -185,3 +280,50 @@ func TestLrange(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, len(l), 2)
 }
+
+func TestReplaceStore(t *testing.T) {
+	previous := mockStore(t)
+	handler, rdb := handlerAndClient(t, previous)
+
+	ctx := context.Background()
+
+	_, err := rdb.Get(ctx, "replaced").Result()
+	assert.Error(t, err)
+
+	assert.NoError(t, handler.ReplaceStore(0, storeFromRecords(t, []store.Record{
+		{Key: "replaced", Type: store.StringType, StringRecord: &store.StringRecord{Value: "yes"}},
+	}), 10*time.Millisecond))
+
+	value, err := rdb.Get(ctx, "replaced").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "yes", value)
+
+	// the previous store still serves during the grace period
+	_, err = previous.GetRecord("key0:string")
+	assert.NoError(t, err)
+
+	// and is closed afterwards
+	time.Sleep(50 * time.Millisecond)
+	_, err = previous.GetRecord("key0:string")
+	assert.Equal(t, store.ErrSecuringReaderPoolDrained, err)
+}
+
+func TestMemoryStoreBackend(t *testing.T) {
+	memoryStore, err := store.NewMemoryStore(store.MockRecords())
+	assert.NoError(t, err)
+	_, rdb := handlerAndClient(t, memoryStore)
+
+	ctx := context.Background()
+
+	value, err := rdb.Get(ctx, "key0:string").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", value)
+
+	tp, err := rdb.Type(ctx, "key0:zset").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, store.ZSetType, tp)
+
+	keys, _, err := rdb.Scan(ctx, 0, "key1:*", 100).Result()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 5)
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+	"github.com/tikibu/rostore/store"
+)
+
+// getHash returns the hash under key, nil for a missing key
+func (h *Handler) getHash(conn redcon.Conn, key string) (hash *store.HashRecord, ok bool) {
+	record, ok := h.getTypedRecord(conn, key, store.HashType)
+	if !ok || record == nil || record.HashRecord == nil {
+		return nil, ok
+	}
+	return record.HashRecord, true
+}
+
+// HGet implements HGET key field
+func (h *Handler) HGet(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	if hash == nil {
+		conn.WriteNull()
+		return
+	}
+
+	value, found := hash.Fields[string(cmd.Args[2])]
+	if !found {
+		conn.WriteNull()
+		return
+	}
+	conn.WriteBulkString(value)
+}
+
+// HMGet implements HMGET key field [field ...]
+func (h *Handler) HMGet(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+
+	fields := cmd.Args[2:]
+	conn.WriteArray(len(fields))
+	for _, field := range fields {
+		if hash == nil {
+			conn.WriteNull()
+			continue
+		}
+		value, found := hash.Fields[string(field)]
+		if !found {
+			conn.WriteNull()
+			continue
+		}
+		conn.WriteBulkString(value)
+	}
+}
+
+// HExists implements HEXISTS key field
+func (h *Handler) HExists(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	if hash == nil {
+		conn.WriteInt(0)
+		return
+	}
+	if _, found := hash.Fields[string(cmd.Args[2])]; found {
+		conn.WriteInt(1)
+		return
+	}
+	conn.WriteInt(0)
+}
+
+// HKeys implements HKEYS key
+func (h *Handler) HKeys(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	if hash == nil {
+		conn.WriteArray(0)
+		return
+	}
+	writeBulkStrings(conn, hash.SortedFields())
+}
+
+// HVals implements HVALS key, values come in the HKEYS order
+func (h *Handler) HVals(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	if hash == nil {
+		conn.WriteArray(0)
+		return
+	}
+
+	fields := hash.SortedFields()
+	conn.WriteArray(len(fields))
+	for _, field := range fields {
+		conn.WriteBulkString(hash.Fields[field])
+	}
+}
+
+// HStrLen implements HSTRLEN key field
+func (h *Handler) HStrLen(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	if hash == nil {
+		conn.WriteInt(0)
+		return
+	}
+	conn.WriteInt(len(hash.Fields[string(cmd.Args[2])]))
+}
+
+// HRandField implements HRANDFIELD key [count [WITHVALUES]]. A positive
+// count returns distinct fields, a negative one allows repetitions.
+func (h *Handler) HRandField(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 || len(cmd.Args) > 4 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	withCount := len(cmd.Args) >= 3
+	count := 1
+	if withCount {
+		var err error
+		count, err = strconv.Atoi(string(cmd.Args[2]))
+		if err != nil {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+	}
+	withValues := false
+	if len(cmd.Args) == 4 {
+		if !strings.EqualFold(string(cmd.Args[3]), "withvalues") {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		withValues = true
+	}
+
+	hash, ok := h.getHash(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+
+	var fields []string
+	if hash != nil {
+		fields = hash.SortedFields()
+	}
+
+	if !withCount {
+		if len(fields) == 0 {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteBulkString(fields[rand.Intn(len(fields))])
+		return
+	}
+
+	picked := []string{}
+	switch {
+	case len(fields) == 0 || count == 0:
+	case count < 0:
+		for i := 0; i < -count; i++ {
+			picked = append(picked, fields[rand.Intn(len(fields))])
+		}
+	default:
+		if count > len(fields) {
+			count = len(fields)
+		}
+		for _, i := range rand.Perm(len(fields))[:count] {
+			picked = append(picked, fields[i])
+		}
+	}
+
+	if !withValues {
+		writeBulkStrings(conn, picked)
+		return
+	}
+	conn.WriteArray(2 * len(picked))
+	for _, field := range picked {
+		conn.WriteBulkString(field)
+		conn.WriteBulkString(hash.Fields[field])
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+// hashRecords are served by hash tests, next to the mock records
+var hashRecords = []store.Record{
+	{
+		Key:  "features",
+		Type: store.HashType,
+		HashRecord: &store.HashRecord{
+			Fields: map[string]string{"b": "beta", "a": "alpha", "c": ""},
+		},
+	},
+}
+
+func TestHGet(t *testing.T) {
+	rdb := clientWithRecords(t, hashRecords...)
+	ctx := context.Background()
+
+	value, err := rdb.HGet(ctx, "features", "a").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "alpha", value)
+
+	_, err = rdb.HGet(ctx, "features", "z").Result()
+	assert.Equal(t, redis.Nil, err)
+
+	_, err = rdb.HGet(ctx, "nosuchkey", "a").Result()
+	assert.Equal(t, redis.Nil, err)
+
+	_, err = rdb.HGet(ctx, "key0:string", "a").Result()
+	assert.EqualError(t, err, wrongTypeError)
+
+	values, err := rdb.HMGet(ctx, "features", "b", "z", "c").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"beta", nil, ""}, values)
+}
+
+func TestHExists(t *testing.T) {
+	rdb := clientWithRecords(t, hashRecords...)
+	ctx := context.Background()
+
+	exists, err := rdb.HExists(ctx, "features", "c").Result()
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = rdb.HExists(ctx, "features", "z").Result()
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	length, err := rdb.Do(ctx, "hstrlen", "features", "alpha").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), length)
+
+	length, err = rdb.Do(ctx, "hstrlen", "features", "b").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), length)
+}
+
+func TestHKeysAndVals(t *testing.T) {
+	rdb := clientWithRecords(t, hashRecords...)
+	ctx := context.Background()
+
+	keys, err := rdb.HKeys(ctx, "features").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+	values, err := rdb.HVals(ctx, "features").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alpha", "beta", ""}, values)
+
+	keys, err = rdb.HKeys(ctx, "nosuchkey").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestHRandField(t *testing.T) {
+	rdb := clientWithRecords(t, hashRecords...)
+	ctx := context.Background()
+
+	fields, err := rdb.HRandField(ctx, "features", 5, false).Result()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, fields)
+
+	fields, err = rdb.HRandField(ctx, "features", -4, true).Result()
+	assert.NoError(t, err)
+	assert.Len(t, fields, 8)
+
+	field, err := rdb.Do(ctx, "hrandfield", "features").Text()
+	assert.NoError(t, err)
+	assert.Contains(t, []string{"a", "b", "c"}, field)
+}
+
+func TestHScanPages(t *testing.T) {
+	rdb := clientWithRecords(t, hashRecords...)
+	ctx := context.Background()
+
+	fields, cursor, err := rdb.HScan(ctx, "features", 0, "", 2).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "alpha", "b", "beta"}, fields)
+	assert.Equal(t, uint64(2), cursor)
+
+	fields, cursor, err = rdb.HScan(ctx, "features", cursor, "", 2).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", ""}, fields)
+	assert.Equal(t, uint64(0), cursor)
+
+	fields, cursor, err = rdb.HScan(ctx, "nosuchkey", 0, "", 2).Result()
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+	assert.Equal(t, uint64(0), cursor)
+}
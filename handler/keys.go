@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/tidwall/redcon"
+	"github.com/tikibu/rostore/store"
+)
+
+// MGet implements MGET key [key ...]. Missing keys and keys that don't hold
+// a string are replied with nil, as Redis does.
+func (h *Handler) MGet(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	keys := make([]string, len(cmd.Args)-1)
+	for i, key := range cmd.Args[1:] {
+		keys[i] = string(key)
+	}
+
+	records, err := h.Store(conn).GetRecordsContext(h.ctx(conn), keys)
+	if err != nil {
+		conn.WriteError(fmt.Sprintf("ERR occurred while retrieving records %s", err.Error()))
+		return
+	}
+
+	conn.WriteArray(len(records))
+	for _, record := range records {
+		if record == nil || record.Type != store.StringType || record.StringRecord == nil {
+			conn.WriteNull()
+			continue
+		}
+		conn.WriteBulkString(record.StringRecord.Value)
+	}
+}
+
+// Exists implements EXISTS key [key ...], a key mentioned several times
+// is counted several times
+func (h *Handler) Exists(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	exist := 0
+	for _, key := range cmd.Args[1:] {
+		_, err := h.Store(conn).GetRecordIndex(string(key))
+		if err == store.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			conn.WriteError(fmt.Sprintf("ERR occurred while retrieving record for key %s", err.Error()))
+			return
+		}
+		exist++
+	}
+	conn.WriteInt(exist)
+}
+
+// DBSize implements DBSIZE
+func (h *Handler) DBSize(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 1 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+	conn.WriteInt(h.Store(conn).GetLen())
+}
+
+// Keys implements KEYS pattern
+func (h *Handler) Keys(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+	keys, err := h.Store(conn).KeysContext(h.ctx(conn), string(cmd.Args[1]))
+	if err != nil {
+		conn.WriteError(fmt.Sprintf("ERR occurred while listing keys %s", err.Error()))
+		return
+	}
+	writeBulkStrings(conn, keys)
+}
+
+// RandomKey implements RANDOMKEY
+func (h *Handler) RandomKey(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 1 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	key, err := h.Store(conn).RandomKey()
+	if err == store.ErrKeyNotFound {
+		conn.WriteNull()
+		return
+	}
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	conn.WriteBulkString(key)
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMGet(t *testing.T) {
+	_, rdb := mockStoreAndClient(t)
+	ctx := context.Background()
+
+	values, err := rdb.MGet(ctx, "key0:string", "nosuchkey", "key0:hash", "key1:string").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"value1", nil, nil, "value1"}, values)
+}
+
+func TestExists(t *testing.T) {
+	_, rdb := mockStoreAndClient(t)
+	ctx := context.Background()
+
+	exist, err := rdb.Exists(ctx, "key0:string", "nosuchkey", "key0:string", "key3:zset").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), exist)
+}
+
+func TestDBSize(t *testing.T) {
+	store, rdb := mockStoreAndClient(t)
+	ctx := context.Background()
+
+	size, err := rdb.DBSize(ctx).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(store.GetLen()), size)
+}
+
+func TestKeys(t *testing.T) {
+	_, rdb := mockStoreAndClient(t)
+	ctx := context.Background()
+
+	keys, err := rdb.Keys(ctx, "key?:str*").Result()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 10)
+	assert.Equal(t, "key0:string", keys[0])
+
+	keys, err = rdb.Keys(ctx, "nosuch*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestRandomKey(t *testing.T) {
+	_, rdb := mockStoreAndClient(t)
+	ctx := context.Background()
+
+	key, err := rdb.RandomKey(ctx).Result()
+	assert.NoError(t, err)
+
+	exist, err := rdb.Exists(ctx, key).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), exist)
+}
+
+func TestRandomKeyEmptyStore(t *testing.T) {
+	_, rdb := storeAndClient(t, storeFromRecords(t, nil))
+	ctx := context.Background()
+
+	_, err := rdb.RandomKey(ctx).Result()
+	assert.Equal(t, redis.Nil, err)
+}
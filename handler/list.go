@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+	"github.com/tikibu/rostore/store"
+)
+
+// getList returns elements of the list under key, none for a missing key
+func (h *Handler) getList(conn redcon.Conn, key string) (elements []string, ok bool) {
+	record, ok := h.getTypedRecord(conn, key, store.ListType)
+	if !ok || record == nil || record.ListRecord == nil {
+		return nil, ok
+	}
+	return record.ListRecord.Elements, true
+}
+
+// LIndex implements LINDEX key index
+func (h *Handler) LIndex(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	index, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	elements, ok := h.getList(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+
+	if index < 0 {
+		index = len(elements) + index
+	}
+	if index < 0 || index >= len(elements) {
+		conn.WriteNull()
+		return
+	}
+	conn.WriteBulkString(elements[index])
+}
+
+// LPos implements LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func (h *Handler) LPos(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	rank, count, maxLen := 1, -1, 0
+	for i := 3; i < len(cmd.Args); i += 2 {
+		if i+1 >= len(cmd.Args) {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		value, err := strconv.Atoi(string(cmd.Args[i+1]))
+		if err != nil {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+
+		switch strings.ToLower(string(cmd.Args[i])) {
+		case "rank":
+			if value == 0 {
+				conn.WriteError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the last match")
+				return
+			}
+			rank = value
+		case "count":
+			if value < 0 {
+				conn.WriteError("ERR COUNT can't be negative")
+				return
+			}
+			count = value
+		case "maxlen":
+			if value < 0 {
+				conn.WriteError("ERR MAXLEN can't be negative")
+				return
+			}
+			maxLen = value
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	elements, ok := h.getList(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+
+	positions := listPositions(elements, string(cmd.Args[2]), rank, count, maxLen)
+	if count >= 0 {
+		conn.WriteArray(len(positions))
+		for _, position := range positions {
+			conn.WriteInt(position)
+		}
+		return
+	}
+	if len(positions) == 0 {
+		conn.WriteNull()
+		return
+	}
+	conn.WriteInt(positions[0])
+}
+
+// listPositions finds indexes of element, skipping the first |rank|-1
+// matches. A negative rank searches from the tail. count 0 means all
+// matches, a negative count just the first one. maxLen 0 means no limit
+// on compared elements.
+func listPositions(elements []string, element string, rank int, count int, maxLen int) (positions []int) {
+	step, i := 1, 0
+	if rank < 0 {
+		step, i = -1, len(elements)-1
+		rank = -rank
+	}
+	if count < 0 {
+		count = 1
+	}
+
+	positions = []int{}
+	for compared := 0; i >= 0 && i < len(elements); i, compared = i+step, compared+1 {
+		if maxLen > 0 && compared >= maxLen {
+			break
+		}
+		if elements[i] != element {
+			continue
+		}
+		if rank > 1 {
+			rank--
+			continue
+		}
+		positions = append(positions, i)
+		if count > 0 && len(positions) >= count {
+			break
+		}
+	}
+	return positions
+}
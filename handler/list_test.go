@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+// listRecords are served by list tests, next to the mock records
+var listRecords = []store.Record{
+	{
+		Key:        "rules",
+		Type:       store.ListType,
+		ListRecord: &store.ListRecord{Elements: []string{"a", "b", "c", "1", "2", "3", "c", "c"}},
+	},
+}
+
+func TestLRangeInclusive(t *testing.T) {
+	rdb := clientWithRecords(t, listRecords...)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		start, stop int64
+		expected    []string
+	}{
+		{0, 0, []string{"a"}},
+		{0, 2, []string{"a", "b", "c"}},
+		{-3, -1, []string{"3", "c", "c"}},
+		{-100, 1, []string{"a", "b"}},
+		{6, 100, []string{"c", "c"}},
+		{8, 10, []string{}},
+		{5, 2, []string{}},
+	} {
+		elements, err := rdb.LRange(ctx, "rules", tc.start, tc.stop).Result()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, elements, "%d %d", tc.start, tc.stop)
+	}
+
+	elements, err := rdb.LRange(ctx, "nosuchkey", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Empty(t, elements)
+
+	_, err = rdb.Do(ctx, "lrange", "rules", 0).Result()
+	assert.Error(t, err)
+
+	_, err = rdb.LRange(ctx, "key0:hash", 0, -1).Result()
+	assert.EqualError(t, err, wrongTypeError)
+}
+
+func TestLIndex(t *testing.T) {
+	rdb := clientWithRecords(t, listRecords...)
+	ctx := context.Background()
+
+	element, err := rdb.LIndex(ctx, "rules", 1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", element)
+
+	element, err = rdb.LIndex(ctx, "rules", -4).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "2", element)
+
+	_, err = rdb.LIndex(ctx, "rules", 8).Result()
+	assert.Equal(t, redis.Nil, err)
+
+	_, err = rdb.LIndex(ctx, "nosuchkey", 0).Result()
+	assert.Equal(t, redis.Nil, err)
+}
+
+func TestLPos(t *testing.T) {
+	rdb := clientWithRecords(t, listRecords...)
+	ctx := context.Background()
+
+	position, err := rdb.LPos(ctx, "rules", "c", redis.LPosArgs{}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), position)
+
+	position, err = rdb.LPos(ctx, "rules", "c", redis.LPosArgs{Rank: -1}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), position)
+
+	position, err = rdb.LPos(ctx, "rules", "c", redis.LPosArgs{Rank: 2}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), position)
+
+	_, err = rdb.LPos(ctx, "rules", "c", redis.LPosArgs{MaxLen: 2}).Result()
+	assert.Equal(t, redis.Nil, err)
+
+	positions, err := rdb.LPosCount(ctx, "rules", "c", 0, redis.LPosArgs{}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 6, 7}, positions)
+
+	positions, err = rdb.LPosCount(ctx, "rules", "c", 2, redis.LPosArgs{Rank: -1}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{7, 6}, positions)
+
+	positions, err = rdb.LPosCount(ctx, "rules", "z", 1, redis.LPosArgs{}).Result()
+	assert.NoError(t, err)
+	assert.Empty(t, positions)
+
+	_, err = rdb.Do(ctx, "lpos", "rules", "c", "rank", 0).Result()
+	assert.Error(t, err)
+}
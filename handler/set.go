@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+	"github.com/tikibu/rostore/store"
+)
+
+// getSet returns unique sorted members of the set under key,
+// no members for a missing key
+func (h *Handler) getSet(conn redcon.Conn, key string) (members []string, ok bool) {
+	setRecord, ok := h.getSetRecord(conn, key)
+	if !ok {
+		return nil, false
+	}
+	return setRecord.SortedMembers(), true
+}
+
+// getSetRecord returns the set under key, an empty one for a missing key.
+// Unlike getSet it doesn't sort the members.
+func (h *Handler) getSetRecord(conn redcon.Conn, key string) (setRecord *store.SetRecord, ok bool) {
+	record, ok := h.getTypedRecord(conn, key, store.SetType)
+	if !ok {
+		return nil, false
+	}
+	if record == nil || record.SetRecord == nil {
+		return &store.SetRecord{}, true
+	}
+	return record.SetRecord, true
+}
+
+func writeBulkStrings(conn redcon.Conn, values []string) {
+	conn.WriteArray(len(values))
+	for _, value := range values {
+		conn.WriteBulkString(value)
+	}
+}
+
+// containsSorted reports whether value is in the sorted slice
+func containsSorted(sorted []string, value string) bool {
+	i := sort.SearchStrings(sorted, value)
+	return i < len(sorted) && sorted[i] == value
+}
+
+// SMembers implements SMEMBERS key
+func (h *Handler) SMembers(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	members, ok := h.getSet(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	writeBulkStrings(conn, members)
+}
+
+// SIsMember implements SISMEMBER key member
+func (h *Handler) SIsMember(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	setRecord, ok := h.getSetRecord(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	if setRecord.IsMember(string(cmd.Args[2])) {
+		conn.WriteInt(1)
+		return
+	}
+	conn.WriteInt(0)
+}
+
+// SMIsMember implements SMISMEMBER key member [member ...]
+func (h *Handler) SMIsMember(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	setRecord, ok := h.getSetRecord(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	conn.WriteArray(len(cmd.Args) - 2)
+	for _, member := range cmd.Args[2:] {
+		if setRecord.IsMember(string(member)) {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	}
+}
+
+// SCard implements SCARD key
+func (h *Handler) SCard(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	setRecord, ok := h.getSetRecord(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	conn.WriteInt(setRecord.Card())
+}
+
+// SRandMember implements SRANDMEMBER key [count]. A positive count returns
+// distinct members, a negative one allows the same member several times.
+func (h *Handler) SRandMember(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 && len(cmd.Args) != 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	withCount := len(cmd.Args) == 3
+	count := 1
+	if withCount {
+		var err error
+		count, err = strconv.Atoi(string(cmd.Args[2]))
+		if err != nil {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+	}
+
+	members, ok := h.getSet(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+
+	if !withCount {
+		if len(members) == 0 {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteBulkString(members[rand.Intn(len(members))])
+		return
+	}
+
+	if len(members) == 0 || count == 0 {
+		conn.WriteArray(0)
+		return
+	}
+
+	if count < 0 {
+		conn.WriteArray(-count)
+		for i := 0; i < -count; i++ {
+			conn.WriteBulkString(members[rand.Intn(len(members))])
+		}
+		return
+	}
+
+	if count > len(members) {
+		count = len(members)
+	}
+	picked := rand.Perm(len(members))[:count]
+	conn.WriteArray(count)
+	for _, i := range picked {
+		conn.WriteBulkString(members[i])
+	}
+}
+
+// SScan implements SSCAN key cursor [MATCH pattern] [COUNT count]
+func (h *Handler) SScan(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	cursor, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError("ERR invalid cursor")
+		return
+	}
+
+	match, count, err := parseScanOptions(cmd.Args[3:])
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	record, ok := h.getTypedRecord(conn, string(cmd.Args[1]), store.SetType)
+	if !ok {
+		return
+	}
+
+	var members []string
+	if record != nil && record.SetRecord != nil {
+		members, cursor, err = record.SetRecord.ScanMembers(cursor, count, match)
+		if err != nil {
+			conn.WriteError("ERR invalid cursor")
+			return
+		}
+	} else {
+		cursor = 0
+	}
+
+	conn.WriteArray(2)
+	conn.WriteBulkString(strconv.Itoa(cursor))
+	writeBulkStrings(conn, members)
+}
+
+// parseScanOptions parses [MATCH pattern] [COUNT count] of the SCAN family
+func parseScanOptions(args [][]byte) (match string, count int, err error) {
+	match = "*"
+	count = 10
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			return "", 0, errSyntax
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			match = string(args[i+1])
+		case "count":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return "", 0, errNotInteger
+			}
+			if count < 1 {
+				return "", 0, errSyntax
+			}
+		default:
+			return "", 0, errSyntax
+		}
+		i++
+	}
+	return match, count, nil
+}
+
+// SInter implements SINTER key [key ...]
+func (h *Handler) SInter(conn redcon.Conn, cmd redcon.Command) {
+	h.setOperation(conn, cmd, func(result []string, members []string) []string {
+		intersection := []string{}
+		for _, member := range result {
+			if containsSorted(members, member) {
+				intersection = append(intersection, member)
+			}
+		}
+		return intersection
+	})
+}
+
+// SUnion implements SUNION key [key ...]
+func (h *Handler) SUnion(conn redcon.Conn, cmd redcon.Command) {
+	h.setOperation(conn, cmd, func(result []string, members []string) []string {
+		union := append([]string{}, result...)
+		for _, member := range members {
+			if !containsSorted(result, member) {
+				union = append(union, member)
+			}
+		}
+		sort.Strings(union)
+		return union
+	})
+}
+
+// SDiff implements SDIFF key [key ...]
+func (h *Handler) SDiff(conn redcon.Conn, cmd redcon.Command) {
+	h.setOperation(conn, cmd, func(result []string, members []string) []string {
+		difference := []string{}
+		for _, member := range result {
+			if !containsSorted(members, member) {
+				difference = append(difference, member)
+			}
+		}
+		return difference
+	})
+}
+
+// setOperation folds the sets under the command keys with combine,
+// starting from the first set. Sets are kept sorted throughout.
+func (h *Handler) setOperation(conn redcon.Conn, cmd redcon.Command, combine func(result []string, members []string) []string) {
+	if len(cmd.Args) < 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	result, ok := h.getSet(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	for _, key := range cmd.Args[2:] {
+		members, ok := h.getSet(conn, string(key))
+		if !ok {
+			return
+		}
+		result = combine(result, members)
+	}
+	writeBulkStrings(conn, result)
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+// setRecords are served by set tests, next to the mock records
+var setRecords = []store.Record{
+	{
+		Key:       "allow",
+		Type:      store.SetType,
+		SetRecord: &store.SetRecord{Members: []string{"c", "a", "b", "a"}},
+	},
+	{
+		Key:       "block",
+		Type:      store.SetType,
+		SetRecord: &store.SetRecord{Members: []string{"b", "d"}},
+	},
+}
+
+func TestSMembers(t *testing.T) {
+	rdb := clientWithRecords(t, setRecords...)
+	ctx := context.Background()
+
+	members, err := rdb.SMembers(ctx, "allow").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, members)
+
+	card, err := rdb.SCard(ctx, "allow").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), card)
+
+	card, err = rdb.SCard(ctx, "nosuchkey").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), card)
+
+	_, err = rdb.SMembers(ctx, "key0:hash").Result()
+	assert.EqualError(t, err, wrongTypeError)
+}
+
+func TestSIsMember(t *testing.T) {
+	rdb := clientWithRecords(t, setRecords...)
+	ctx := context.Background()
+
+	isMember, err := rdb.SIsMember(ctx, "allow", "b").Result()
+	assert.NoError(t, err)
+	assert.True(t, isMember)
+
+	isMember, err = rdb.SIsMember(ctx, "allow", "d").Result()
+	assert.NoError(t, err)
+	assert.False(t, isMember)
+
+	areMembers, err := rdb.SMIsMember(ctx, "key1:set", "member1:1", "member0:1").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, areMembers)
+}
+
+func TestSRandMember(t *testing.T) {
+	rdb := clientWithRecords(t, setRecords...)
+	ctx := context.Background()
+
+	member, err := rdb.SRandMember(ctx, "allow").Result()
+	assert.NoError(t, err)
+	assert.Contains(t, []string{"a", "b", "c"}, member)
+
+	_, err = rdb.SRandMember(ctx, "nosuchkey").Result()
+	assert.Equal(t, redis.Nil, err)
+
+	members, err := rdb.SRandMemberN(ctx, "allow", 10).Result()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, members)
+
+	members, err = rdb.SRandMemberN(ctx, "block", -5).Result()
+	assert.NoError(t, err)
+	assert.Len(t, members, 5)
+}
+
+func TestSScan(t *testing.T) {
+	rdb := clientWithRecords(t, setRecords...)
+	ctx := context.Background()
+
+	members, cursor, err := rdb.SScan(ctx, "allow", 0, "", 2).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, members)
+	assert.NotEqual(t, uint64(0), cursor)
+
+	members, cursor, err = rdb.SScan(ctx, "allow", cursor, "", 2).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, members)
+	assert.Equal(t, uint64(0), cursor)
+
+	members, _, err = rdb.SScan(ctx, "allow", 0, "c*", 10).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c"}, members)
+}
+
+func TestSetOperations(t *testing.T) {
+	rdb := clientWithRecords(t, setRecords...)
+	ctx := context.Background()
+
+	members, err := rdb.SInter(ctx, "allow", "block").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b"}, members)
+
+	members, err = rdb.SUnion(ctx, "allow", "block", "nosuchkey").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, members)
+
+	members, err = rdb.SDiff(ctx, "allow", "block").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, members)
+
+	members, err = rdb.SInter(ctx, "allow", "nosuchkey").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, members)
+
+	_, err = rdb.SUnion(ctx, "allow", "key0:list").Result()
+	assert.EqualError(t, err, wrongTypeError)
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"github.com/tikibu/rostore/store"
+)
+
+// Not the READONLY error of Redis replicas: clients retry on that one,
+// expecting a failover, and the store is never going to become writable.
+const readOnlyError = "ERR write commands are not supported, the store is read only"
+
+// writeStringError replies to a failed string read. A missing key is left
+// for the caller to reply to, missing is true then.
+func writeStringError(conn redcon.Conn, err error) (missing bool) {
+	switch err {
+	case store.ErrKeyNotFound:
+		return true
+	case store.ErrWrongType:
+		conn.WriteError(wrongTypeError)
+	default:
+		conn.WriteError(fmt.Sprintf("ERR occurred while retrieving record for key %s", err.Error()))
+	}
+	return false
+}
+
+// StrLen implements STRLEN key
+func (h *Handler) StrLen(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	length, err := h.Store(conn).GetStringLenContext(h.ctx(conn), string(cmd.Args[1]))
+	if err != nil {
+		if writeStringError(conn, err) {
+			conn.WriteInt(0)
+		}
+		return
+	}
+	conn.WriteInt(length)
+}
+
+// GetRange implements GETRANGE key start end, and its old name SUBSTR.
+// Both ends are inclusive, negative offsets count from the end.
+func (h *Handler) GetRange(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 4 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	start, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+	end, err := strconv.Atoi(string(cmd.Args[3]))
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	// the length and the slice must come from the same store
+	store_ := h.Store(conn)
+	key := string(cmd.Args[1])
+	length, err := store_.GetStringLenContext(h.ctx(conn), key)
+	if err != nil {
+		if writeStringError(conn, err) {
+			conn.WriteBulkString("")
+		}
+		return
+	}
+
+	if start < 0 && end < 0 && start > end {
+		conn.WriteBulkString("")
+		return
+	}
+	from, to := indexRange(start, end, length)
+	if from == to {
+		conn.WriteBulkString("")
+		return
+	}
+
+	value, err := store_.GetStringSliceContext(h.ctx(conn), key, from, to)
+	if err != nil {
+		if writeStringError(conn, err) {
+			conn.WriteBulkString("")
+		}
+		return
+	}
+	conn.WriteBulkString(value)
+}
+
+// GetEx implements GETEX key [EX seconds | PX milliseconds | EXAT
+// unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]. Keys of a read
+// only store never expire, so PERSIST is a no-op there and the other
+// options fail. On a writable overlay the value is SET again with its new
+// expiry.
+func (h *Handler) GetEx(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+	overlay, writable := h.Store(conn).(*store.Overlay)
+	persist := len(cmd.Args) == 3 && strings.EqualFold(string(cmd.Args[2]), "persist")
+	expire := len(cmd.Args) > 2 && !persist
+	if !writable && expire {
+		conn.WriteError(readOnlyError)
+		return
+	}
+	var ttl time.Duration
+	if expire {
+		var err error
+		if ttl, err = getExTTL(cmd.Args[2:]); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+	}
+
+	key := string(cmd.Args[1])
+	record, ok := h.getTypedRecord(conn, key, store.StringType)
+	if !ok {
+		return
+	}
+	if record == nil || record.StringRecord == nil {
+		conn.WriteNull()
+		return
+	}
+	value := record.StringRecord.Value
+	if writable && (expire || persist) {
+		var err error
+		// like Redis, an expiry time that passed deletes the key
+		if expire && ttl <= 0 {
+			overlay.Delete(key)
+		} else {
+			err = overlay.Set(key, value, ttl)
+		}
+		if err != nil {
+			writeOverlayError(conn, err)
+			return
+		}
+	}
+	conn.WriteBulkString(value)
+}
+
+// getExTTL parses the expiry option of GETEX, the ttl isn't positive for
+// an expiry time that passed
+func getExTTL(args [][]byte) (time.Duration, error) {
+	if len(args) != 2 {
+		return 0, errSyntax
+	}
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if n <= 0 {
+		return 0, errors.New("ERR invalid expire time in 'getex' command")
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "ex":
+		return time.Duration(n) * time.Second, nil
+	case "px":
+		return time.Duration(n) * time.Millisecond, nil
+	case "exat":
+		return time.Until(time.Unix(n, 0)), nil
+	case "pxat":
+		return time.Until(time.UnixMilli(n)), nil
+	}
+	return 0, errSyntax
+}
+
+// GetDel implements GETDEL key, which fails on a read only store
+func (h *Handler) GetDel(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+
+	key := string(cmd.Args[1])
+	record, ok := h.getTypedRecord(conn, key, store.StringType)
+	if !ok {
+		return
+	}
+	if record == nil || record.StringRecord == nil {
+		conn.WriteNull()
+		return
+	}
+	overlay.Delete(key)
+	conn.WriteBulkString(record.StringRecord.Value)
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+// stringRecords are served by string tests, next to the mock records
+var stringRecords = []store.Record{
+	{
+		Key:          "greeting",
+		Type:         store.StringType,
+		StringRecord: &store.StringRecord{Value: "This is a string"},
+	},
+}
+
+func TestStrLen(t *testing.T) {
+	rdb := clientWithRecords(t, stringRecords...)
+	ctx := context.Background()
+
+	length, err := rdb.StrLen(ctx, "greeting").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(16), length)
+
+	length, err = rdb.StrLen(ctx, "nosuchkey").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), length)
+
+	_, err = rdb.StrLen(ctx, "key0:hash").Result()
+	assert.EqualError(t, err, wrongTypeError)
+}
+
+func TestGetRange(t *testing.T) {
+	rdb := clientWithRecords(t, stringRecords...)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		start, end int64
+		expected   string
+	}{
+		{0, 3, "This"},
+		{-3, -1, "ing"},
+		{0, -1, "This is a string"},
+		{10, 100, "string"},
+		{5, 2, ""},
+		{-100, -200, ""},
+		{100, 200, ""},
+	} {
+		value, err := rdb.GetRange(ctx, "greeting", tc.start, tc.end).Result()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, value, "%d %d", tc.start, tc.end)
+	}
+
+	value, err := rdb.Do(ctx, "substr", "greeting", 5, 6).Text()
+	assert.NoError(t, err)
+	assert.Equal(t, "is", value)
+
+	value, err = rdb.GetRange(ctx, "nosuchkey", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "", value)
+}
+
+func TestGetExAndGetDel(t *testing.T) {
+	rdb := clientWithRecords(t, stringRecords...)
+	ctx := context.Background()
+
+	value, err := rdb.GetEx(ctx, "greeting", 0).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "This is a string", value)
+
+	_, err = rdb.GetEx(ctx, "nosuchkey", 0).Result()
+	assert.Equal(t, redis.Nil, err)
+
+	_, err = rdb.GetEx(ctx, "greeting", time.Minute).Result()
+	assert.EqualError(t, err, readOnlyError)
+
+	_, err = rdb.GetDel(ctx, "greeting").Result()
+	assert.EqualError(t, err, readOnlyError)
+}
@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+	"github.com/tikibu/rostore/store"
+)
+
+var (
+	errNotFloat = errors.New("ERR value is not a valid float")
+	// jsonl records have no way to write an infinite score
+	errInfiniteScore = errors.New("ERR infinite scores can't be written to the overlay")
+)
+
+// overlay returns the writable overlay the connection's db is served
+// from. A read only store replies with readOnlyError, ok is false then.
+func (h *Handler) overlay(conn redcon.Conn) (overlay *store.Overlay, ok bool) {
+	overlay, ok = h.Store(conn).(*store.Overlay)
+	if !ok {
+		conn.WriteError(readOnlyError)
+	}
+	return overlay, ok
+}
+
+// writeOverlayError replies to a failed overlay write
+func writeOverlayError(conn redcon.Conn, err error) {
+	switch err {
+	case store.ErrWrongType:
+		conn.WriteError(wrongTypeError)
+	default:
+		conn.WriteError(fmt.Sprintf("ERR occurred while writing to the overlay %s", err.Error()))
+	}
+}
+
+func bytesToStrings(args [][]byte) []string {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = string(arg)
+	}
+	return values
+}
+
+// Set implements SET key value [EX seconds | PX milliseconds]
+func (h *Handler) Set(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 && len(cmd.Args) != 5 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	var ttl time.Duration
+	if len(cmd.Args) == 5 {
+		n, err := strconv.Atoi(string(cmd.Args[4]))
+		if err != nil {
+			conn.WriteError(errNotInteger.Error())
+			return
+		}
+		if n <= 0 {
+			conn.WriteError("ERR invalid expire time in 'set' command")
+			return
+		}
+		switch strings.ToLower(string(cmd.Args[3])) {
+		case "ex":
+			ttl = time.Duration(n) * time.Second
+		case "px":
+			ttl = time.Duration(n) * time.Millisecond
+		default:
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	if err := overlay.Set(string(cmd.Args[1]), string(cmd.Args[2]), ttl); err != nil {
+		writeOverlayError(conn, err)
+		return
+	}
+	conn.WriteString("OK")
+}
+
+// Del implements DEL key [key ...]
+func (h *Handler) Del(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 2 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	conn.WriteInt(overlay.Delete(bytesToStrings(cmd.Args[1:])...))
+}
+
+// Expire implements EXPIRE key seconds
+func (h *Handler) Expire(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+	seconds, err := strconv.Atoi(string(cmd.Args[2]))
+	if err != nil {
+		conn.WriteError(errNotInteger.Error())
+		return
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	if overlay.Expire(string(cmd.Args[1]), time.Duration(seconds)*time.Second) {
+		conn.WriteInt(1)
+		return
+	}
+	conn.WriteInt(0)
+}
+
+// HSet implements HSET key field value [field value ...]
+func (h *Handler) HSet(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 4 || len(cmd.Args)%2 != 0 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	fields := map[string]string{}
+	for i := 2; i < len(cmd.Args); i += 2 {
+		fields[string(cmd.Args[i])] = string(cmd.Args[i+1])
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	added, err := overlay.HSet(string(cmd.Args[1]), fields)
+	if err != nil {
+		writeOverlayError(conn, err)
+		return
+	}
+	conn.WriteInt(added)
+}
+
+// HDel implements HDEL key field [field ...]
+func (h *Handler) HDel(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	deleted, err := overlay.HDel(string(cmd.Args[1]), bytesToStrings(cmd.Args[2:])...)
+	if err != nil {
+		writeOverlayError(conn, err)
+		return
+	}
+	conn.WriteInt(deleted)
+}
+
+// LPush implements LPUSH key element [element ...]
+func (h *Handler) LPush(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	length, err := overlay.LPush(string(cmd.Args[1]), bytesToStrings(cmd.Args[2:])...)
+	if err != nil {
+		writeOverlayError(conn, err)
+		return
+	}
+	conn.WriteInt(length)
+}
+
+// SAdd implements SADD key member [member ...]
+func (h *Handler) SAdd(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	added, err := overlay.SAdd(string(cmd.Args[1]), bytesToStrings(cmd.Args[2:])...)
+	if err != nil {
+		writeOverlayError(conn, err)
+		return
+	}
+	conn.WriteInt(added)
+}
+
+// ZAdd implements ZADD key score member [score member ...], without the
+// NX, XX, GT, LT, CH and INCR options
+func (h *Handler) ZAdd(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 4 || len(cmd.Args)%2 != 0 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	elements := []store.OrderedSetElement{}
+	for i := 2; i < len(cmd.Args); i += 2 {
+		score, err := strconv.ParseFloat(string(cmd.Args[i]), 64)
+		// a NaN score can't be ordered
+		if err != nil || math.IsNaN(score) {
+			conn.WriteError(errNotFloat.Error())
+			return
+		}
+		if math.IsInf(score, 0) {
+			conn.WriteError(errInfiniteScore.Error())
+			return
+		}
+		elements = append(elements, store.OrderedSetElement{Value: string(cmd.Args[i+1]), Score: score})
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	added, err := overlay.ZAdd(string(cmd.Args[1]), elements...)
+	if err != nil {
+		writeOverlayError(conn, err)
+		return
+	}
+	conn.WriteInt(added)
+}
+
+// Rostore implements rostore specific commands:
+//
+//	ROSTORE OVERLAY DUMP  replies with the overlay of the selected db as a
+//	                      jsonl delta, that can be served as a layer
+func (h *Handler) Rostore(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 || !strings.EqualFold(string(cmd.Args[1]), "overlay") || !strings.EqualFold(string(cmd.Args[2]), "dump") {
+		conn.WriteError("ERR unknown subcommand, try ROSTORE OVERLAY DUMP")
+		return
+	}
+
+	overlay, ok := h.overlay(conn)
+	if !ok {
+		return
+	}
+	var dump bytes.Buffer
+	if err := overlay.Dump(&dump); err != nil {
+		conn.WriteError(fmt.Sprintf("ERR dumping the overlay %s", err.Error()))
+		return
+	}
+	conn.WriteBulk(dump.Bytes())
+}
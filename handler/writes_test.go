@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+func overlayAndClient(t *testing.T) (*store.Overlay, *redis.Client) {
+	overlay := store.NewOverlay(mockStore(t))
+	_, rdb := handlerAndClient(t, overlay)
+	return overlay, rdb
+}
+
+func TestWritesToReadOnlyStore(t *testing.T) {
+	_, rdb := mockStoreAndClient(t)
+
+	ctx := context.Background()
+
+	assert.EqualError(t, rdb.Set(ctx, "key", "value", 0).Err(), readOnlyError)
+	assert.EqualError(t, rdb.Del(ctx, "key0:string").Err(), readOnlyError)
+	assert.EqualError(t, rdb.HSet(ctx, "key0:hash", "field", "value").Err(), readOnlyError)
+	assert.EqualError(t, rdb.Do(ctx, "rostore", "overlay", "dump").Err(), readOnlyError)
+}
+
+func TestSetDelExpire(t *testing.T) {
+	_, rdb := overlayAndClient(t)
+
+	ctx := context.Background()
+
+	assert.NoError(t, rdb.Set(ctx, "key0:string", "changed", 0).Err())
+	value, err := rdb.Get(ctx, "key0:string").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", value)
+
+	assert.NoError(t, rdb.Set(ctx, "new", "value", 50*time.Millisecond).Err())
+	size, err := rdb.DBSize(ctx).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(51), size)
+
+	deleted, err := rdb.Del(ctx, "key1:string", "key1:hash", "nosuchkey").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	exists, err := rdb.Exists(ctx, "key1:string").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+
+	expired, err := rdb.Expire(ctx, "key2:string", time.Second).Result()
+	assert.NoError(t, err)
+	assert.True(t, expired)
+	expired, err = rdb.Expire(ctx, "nosuchkey", time.Second).Result()
+	assert.NoError(t, err)
+	assert.False(t, expired)
+
+	time.Sleep(60 * time.Millisecond)
+	exists, err = rdb.Exists(ctx, "new").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), exists)
+
+	assert.EqualError(t, rdb.Do(ctx, "set", "key", "value", "ex", "0").Err(), "ERR invalid expire time in 'set' command")
+	assert.EqualError(t, rdb.Do(ctx, "set", "key", "value", "nx", "1").Err(), errSyntax.Error())
+}
+
+func TestAggregateWrites(t *testing.T) {
+	_, rdb := overlayAndClient(t)
+
+	ctx := context.Background()
+
+	added, err := rdb.HSet(ctx, "key0:hash", "field0:1", "changed", "new", "added").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), added)
+	value, err := rdb.HGet(ctx, "key0:hash", "new").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "added", value)
+
+	deleted, err := rdb.HDel(ctx, "key0:hash", "new", "nosuchfield").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	length, err := rdb.LPush(ctx, "key0:list", "a", "b").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), length)
+	elements, err := rdb.LRange(ctx, "key0:list", 0, 1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "a"}, elements)
+
+	added, err = rdb.SAdd(ctx, "new:set", "a", "b", "a").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), added)
+	members, err := rdb.SMembers(ctx, "new:set").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, members)
+
+	added, err = rdb.ZAdd(ctx, "key0:zset", &redis.Z{Score: 0, Member: "first"}, &redis.Z{Score: 3, Member: "key0:zset:1"}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), added)
+	zmembers, err := rdb.ZRange(ctx, "key0:zset", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"first", "key0:zset:2", "key0:zset:1"}, zmembers)
+
+	assert.EqualError(t, rdb.SAdd(ctx, "key0:string", "member").Err(), wrongTypeError)
+	assert.EqualError(t, rdb.Do(ctx, "zadd", "key0:zset", "high", "member").Err(), errNotFloat.Error())
+	assert.EqualError(t, rdb.Do(ctx, "zadd", "key0:zset", "nan", "member").Err(), errNotFloat.Error())
+	assert.EqualError(t, rdb.Do(ctx, "zadd", "key0:zset", "+inf", "member").Err(), errInfiniteScore.Error())
+	assert.EqualError(t, rdb.Do(ctx, "zadd", "key0:zset", "-inf", "member").Err(), errInfiniteScore.Error())
+}
+
+func TestOverlayDump(t *testing.T) {
+	_, rdb := overlayAndClient(t)
+
+	ctx := context.Background()
+
+	assert.NoError(t, rdb.Set(ctx, "key0:string", "changed", 0).Err())
+	assert.NoError(t, rdb.Del(ctx, "key0:hash").Err())
+
+	dump, err := rdb.Do(ctx, "rostore", "overlay", "dump").Text()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"key":"key0:hash","type":"tombstone"}
+{"key":"key0:string","type":"string","string_record":{"value":"changed"}}
+`, dump)
+
+	assert.Error(t, rdb.Do(ctx, "rostore", "overlay").Err())
+}
+
+func TestGetExAndGetDelOnOverlay(t *testing.T) {
+	_, rdb := overlayAndClient(t)
+	ctx := context.Background()
+	assert.NoError(t, rdb.Set(ctx, "greeting", "hello", 0).Err())
+
+	value, err := rdb.GetEx(ctx, "greeting", 50*time.Millisecond).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", value)
+	_, err = rdb.Do(ctx, "getex", "greeting", "persist").Result()
+	assert.NoError(t, err)
+	time.Sleep(60 * time.Millisecond)
+	value, err = rdb.Get(ctx, "greeting").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", value)
+
+	assert.NoError(t, rdb.Set(ctx, "expiring", "soon", 0).Err())
+	_, err = rdb.Do(ctx, "getex", "expiring", "px", "20").Result()
+	assert.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int64(0), rdb.Exists(ctx, "expiring").Val())
+
+	assert.EqualError(t, rdb.Do(ctx, "getex", "greeting", "ex", "0").Err(), "ERR invalid expire time in 'getex' command")
+	assert.EqualError(t, rdb.Do(ctx, "getex", "greeting", "soon").Err(), errSyntax.Error())
+	_, err = rdb.Do(ctx, "getex", "greeting", "exat", "1").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), rdb.Exists(ctx, "greeting").Val())
+
+	value, err = rdb.GetDel(ctx, "key0:string").Result()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, value)
+	assert.Equal(t, int64(0), rdb.Exists(ctx, "key0:string").Val())
+	assert.Equal(t, redis.Nil, rdb.GetDel(ctx, "key0:string").Err())
+	assert.EqualError(t, rdb.GetDel(ctx, "key0:hash").Err(), wrongTypeError)
+}
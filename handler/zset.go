@@ -0,0 +1,521 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/redcon"
+	"github.com/tikibu/rostore/store"
+)
+
+var (
+	errSyntax          = errors.New("ERR syntax error")
+	errNotInteger      = errors.New("ERR value is not an integer or out of range")
+	errScoreNotFloat   = errors.New("ERR min or max is not a float")
+	errLexNotValid     = errors.New("ERR min or max not valid string range item")
+	errLimitWithIndex  = errors.New("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	errScoresWithByLex = errors.New("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+)
+
+// scoreBound is one end of a score range: 1.5, (1.5, -inf or +inf
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(arg string) (bound scoreBound, err error) {
+	if strings.HasPrefix(arg, "(") {
+		bound.exclusive = true
+		arg = arg[1:]
+	}
+	bound.value, err = strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(bound.value) {
+		return bound, errScoreNotFloat
+	}
+	return bound, nil
+}
+
+func (b scoreBound) isBelow(score float64) bool {
+	if b.exclusive {
+		return b.value < score
+	}
+	return b.value <= score
+}
+
+func (b scoreBound) isAbove(score float64) bool {
+	if b.exclusive {
+		return b.value > score
+	}
+	return b.value >= score
+}
+
+// lexBound is one end of a lexicographical range: [a, (a, - or +
+type lexBound struct {
+	value     string
+	exclusive bool
+	infinity  int // -1 for "-", +1 for "+"
+}
+
+func parseLexBound(arg string) (bound lexBound, err error) {
+	switch {
+	case arg == "-":
+		bound.infinity = -1
+	case arg == "+":
+		bound.infinity = 1
+	case strings.HasPrefix(arg, "["):
+		bound.value = arg[1:]
+	case strings.HasPrefix(arg, "("):
+		bound.value = arg[1:]
+		bound.exclusive = true
+	default:
+		return bound, errLexNotValid
+	}
+	return bound, nil
+}
+
+func (b lexBound) isBelow(value string) bool {
+	if b.infinity != 0 {
+		return b.infinity < 0
+	}
+	if b.exclusive {
+		return b.value < value
+	}
+	return b.value <= value
+}
+
+func (b lexBound) isAbove(value string) bool {
+	if b.infinity != 0 {
+		return b.infinity > 0
+	}
+	if b.exclusive {
+		return b.value > value
+	}
+	return b.value >= value
+}
+
+type zrangeKind int
+
+const (
+	zrangeByIndex zrangeKind = iota
+	zrangeByScore
+	zrangeByLex
+)
+
+// zrangeQuery is a parsed ZRANGE (or one of its older variants) request
+type zrangeQuery struct {
+	kind zrangeKind
+
+	start, stop        int
+	minScore, maxScore scoreBound
+	minLex, maxLex     lexBound
+
+	rev        bool
+	withScores bool
+
+	hasLimit bool
+	offset   int
+	count    int
+}
+
+// parseOptions parses trailing ZRANGE options, rejecting anything not in allowed
+func (q *zrangeQuery) parseOptions(args [][]byte, allowed ...string) error {
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		isAllowed := false
+		for _, a := range allowed {
+			if a == option {
+				isAllowed = true
+				break
+			}
+		}
+		if !isAllowed {
+			return errSyntax
+		}
+
+		switch option {
+		case "withscores":
+			q.withScores = true
+		case "byscore":
+			q.kind = zrangeByScore
+		case "bylex":
+			q.kind = zrangeByLex
+		case "rev":
+			q.rev = true
+		case "limit":
+			if i+2 >= len(args) {
+				return errSyntax
+			}
+			var err error
+			q.offset, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return errNotInteger
+			}
+			q.count, err = strconv.Atoi(string(args[i+2]))
+			if err != nil {
+				return errNotInteger
+			}
+			q.hasLimit = true
+			i += 2
+		}
+	}
+	return nil
+}
+
+// parseRange interprets the two range arguments according to the query kind.
+// min and max are expected in their natural order, callers swap them for
+// reversed commands.
+func (q *zrangeQuery) parseRange(min string, max string) (err error) {
+	switch q.kind {
+	case zrangeByScore:
+		if q.minScore, err = parseScoreBound(min); err != nil {
+			return err
+		}
+		q.maxScore, err = parseScoreBound(max)
+		return err
+	case zrangeByLex:
+		if q.minLex, err = parseLexBound(min); err != nil {
+			return err
+		}
+		q.maxLex, err = parseLexBound(max)
+		return err
+	default:
+		if q.start, err = strconv.Atoi(min); err != nil {
+			return errNotInteger
+		}
+		if q.stop, err = strconv.Atoi(max); err != nil {
+			return errNotInteger
+		}
+		return nil
+	}
+}
+
+func reverseElements(elements []store.OrderedSetElement) []store.OrderedSetElement {
+	reversed := make([]store.OrderedSetElement, len(elements))
+	for i, element := range elements {
+		reversed[len(elements)-1-i] = element
+	}
+	return reversed
+}
+
+// indexRange clamps Redis style inclusive start and stop indexes (negative
+// ones count from the end) to a slice of length n. It returns an empty
+// range (from == to) when nothing is selected.
+func indexRange(start int, stop int, n int) (from int, to int) {
+	if start < 0 {
+		start = n + start
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > stop || start >= n {
+		return 0, 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return start, stop + 1
+}
+
+// apply selects elements according to the query, elements must be sorted
+func (q *zrangeQuery) apply(elements []store.OrderedSetElement) []store.OrderedSetElement {
+	if q.kind == zrangeByIndex {
+		if q.rev {
+			elements = reverseElements(elements)
+		}
+		from, to := indexRange(q.start, q.stop, len(elements))
+		return elements[from:to]
+	}
+
+	selected := []store.OrderedSetElement{}
+	for _, element := range elements {
+		if q.inRange(element) {
+			selected = append(selected, element)
+		}
+	}
+	if q.rev {
+		selected = reverseElements(selected)
+	}
+
+	if !q.hasLimit {
+		return selected
+	}
+	if q.offset < 0 || q.offset >= len(selected) {
+		return []store.OrderedSetElement{}
+	}
+	selected = selected[q.offset:]
+	if q.count >= 0 && q.count < len(selected) {
+		selected = selected[:q.count]
+	}
+	return selected
+}
+
+func (q *zrangeQuery) inRange(element store.OrderedSetElement) bool {
+	if q.kind == zrangeByLex {
+		return q.minLex.isBelow(element.Value) && q.maxLex.isAbove(element.Value)
+	}
+	return q.minScore.isBelow(element.Score) && q.maxScore.isAbove(element.Score)
+}
+
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func writeOrderedSetElements(conn redcon.Conn, elements []store.OrderedSetElement, withScores bool) {
+	if withScores {
+		conn.WriteArray(2 * len(elements))
+	} else {
+		conn.WriteArray(len(elements))
+	}
+	for _, element := range elements {
+		conn.WriteBulkString(element.Value)
+		if withScores {
+			conn.WriteBulkString(formatScore(element.Score))
+		}
+	}
+}
+
+// getOrderedSet returns sorted elements of the zset under key,
+// nil elements for a missing key
+func (h *Handler) getOrderedSet(conn redcon.Conn, key string) (elements []store.OrderedSetElement, ok bool) {
+	record, ok := h.getTypedRecord(conn, key, store.ZSetType)
+	if !ok || record == nil || record.OrdderSetRecord == nil {
+		return nil, ok
+	}
+	return record.OrdderSetRecord.SortedElements(), true
+}
+
+func (h *Handler) replyZRange(conn redcon.Conn, key string, query *zrangeQuery) {
+	elements, ok := h.getOrderedSet(conn, key)
+	if !ok {
+		return
+	}
+	writeOrderedSetElements(conn, query.apply(elements), query.withScores)
+}
+
+// ZRange implements ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func (h *Handler) ZRange(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 4 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	query := &zrangeQuery{}
+	err := query.parseOptions(cmd.Args[4:], "byscore", "bylex", "rev", "limit", "withscores")
+	if err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+	if query.hasLimit && query.kind == zrangeByIndex {
+		conn.WriteError(errLimitWithIndex.Error())
+		return
+	}
+	if query.withScores && query.kind == zrangeByLex {
+		conn.WriteError(errScoresWithByLex.Error())
+		return
+	}
+
+	min, max := string(cmd.Args[2]), string(cmd.Args[3])
+	if query.rev && query.kind != zrangeByIndex {
+		min, max = max, min
+	}
+	if err := query.parseRange(min, max); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	h.replyZRange(conn, string(cmd.Args[1]), query)
+}
+
+// ZRevRange implements ZREVRANGE key start stop [WITHSCORES]
+func (h *Handler) ZRevRange(conn redcon.Conn, cmd redcon.Command) {
+	h.zrangeVariant(conn, cmd, zrangeByIndex, true, "withscores")
+}
+
+// ZRangeByScore implements ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func (h *Handler) ZRangeByScore(conn redcon.Conn, cmd redcon.Command) {
+	h.zrangeVariant(conn, cmd, zrangeByScore, false, "withscores", "limit")
+}
+
+// ZRevRangeByScore implements ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func (h *Handler) ZRevRangeByScore(conn redcon.Conn, cmd redcon.Command) {
+	h.zrangeVariant(conn, cmd, zrangeByScore, true, "withscores", "limit")
+}
+
+// ZRangeByLex implements ZRANGEBYLEX key min max [LIMIT offset count]
+func (h *Handler) ZRangeByLex(conn redcon.Conn, cmd redcon.Command) {
+	h.zrangeVariant(conn, cmd, zrangeByLex, false, "limit")
+}
+
+// ZRevRangeByLex implements ZREVRANGEBYLEX key max min [LIMIT offset count]
+func (h *Handler) ZRevRangeByLex(conn redcon.Conn, cmd redcon.Command) {
+	h.zrangeVariant(conn, cmd, zrangeByLex, true, "limit")
+}
+
+// zrangeVariant serves the pre-6.2 range commands, which fix the kind and
+// direction of the range in the command name
+func (h *Handler) zrangeVariant(conn redcon.Conn, cmd redcon.Command, kind zrangeKind, rev bool, allowed ...string) {
+	if len(cmd.Args) < 4 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	query := &zrangeQuery{kind: kind, rev: rev}
+	if err := query.parseOptions(cmd.Args[4:], allowed...); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	min, max := string(cmd.Args[2]), string(cmd.Args[3])
+	if rev && kind != zrangeByIndex {
+		min, max = max, min
+	}
+	if err := query.parseRange(min, max); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	h.replyZRange(conn, string(cmd.Args[1]), query)
+}
+
+// ZScore implements ZSCORE key member
+func (h *Handler) ZScore(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) != 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	record, ok := h.getTypedRecord(conn, string(cmd.Args[1]), store.ZSetType)
+	if !ok {
+		return
+	}
+	if record == nil || record.OrdderSetRecord == nil {
+		conn.WriteNull()
+		return
+	}
+
+	score, found := record.OrdderSetRecord.Score(string(cmd.Args[2]))
+	if !found {
+		conn.WriteNull()
+		return
+	}
+	conn.WriteBulkString(formatScore(score))
+}
+
+// ZMScore implements ZMSCORE key member [member ...]
+func (h *Handler) ZMScore(conn redcon.Conn, cmd redcon.Command) {
+	if len(cmd.Args) < 3 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	record, ok := h.getTypedRecord(conn, string(cmd.Args[1]), store.ZSetType)
+	if !ok {
+		return
+	}
+
+	members := cmd.Args[2:]
+	conn.WriteArray(len(members))
+	for _, member := range members {
+		if record == nil || record.OrdderSetRecord == nil {
+			conn.WriteNull()
+			continue
+		}
+		score, found := record.OrdderSetRecord.Score(string(member))
+		if !found {
+			conn.WriteNull()
+			continue
+		}
+		conn.WriteBulkString(formatScore(score))
+	}
+}
+
+// ZRank implements ZRANK key member [WITHSCORE]
+func (h *Handler) ZRank(conn redcon.Conn, cmd redcon.Command) {
+	h.zrank(conn, cmd, false)
+}
+
+// ZRevRank implements ZREVRANK key member [WITHSCORE]
+func (h *Handler) ZRevRank(conn redcon.Conn, cmd redcon.Command) {
+	h.zrank(conn, cmd, true)
+}
+
+func (h *Handler) zrank(conn redcon.Conn, cmd redcon.Command, rev bool) {
+	if len(cmd.Args) != 3 && len(cmd.Args) != 4 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	withScore := false
+	if len(cmd.Args) == 4 {
+		if !strings.EqualFold(string(cmd.Args[3]), "withscore") {
+			conn.WriteError(errSyntax.Error())
+			return
+		}
+		withScore = true
+	}
+
+	elements, ok := h.getOrderedSet(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+
+	member := string(cmd.Args[2])
+	for i, element := range elements {
+		if element.Value != member {
+			continue
+		}
+		rank := i
+		if rev {
+			rank = len(elements) - 1 - i
+		}
+		if withScore {
+			conn.WriteArray(2)
+			conn.WriteInt(rank)
+			conn.WriteBulkString(formatScore(element.Score))
+			return
+		}
+		conn.WriteInt(rank)
+		return
+	}
+	conn.WriteNull()
+}
+
+// ZCount implements ZCOUNT key min max
+func (h *Handler) ZCount(conn redcon.Conn, cmd redcon.Command) {
+	h.zcount(conn, cmd, zrangeByScore)
+}
+
+// ZLexCount implements ZLEXCOUNT key min max
+func (h *Handler) ZLexCount(conn redcon.Conn, cmd redcon.Command) {
+	h.zcount(conn, cmd, zrangeByLex)
+}
+
+func (h *Handler) zcount(conn redcon.Conn, cmd redcon.Command, kind zrangeKind) {
+	if len(cmd.Args) != 4 {
+		wrongNumberOfArguments(conn, cmd)
+		return
+	}
+
+	query := &zrangeQuery{kind: kind}
+	if err := query.parseRange(string(cmd.Args[2]), string(cmd.Args[3])); err != nil {
+		conn.WriteError(err.Error())
+		return
+	}
+
+	elements, ok := h.getOrderedSet(conn, string(cmd.Args[1]))
+	if !ok {
+		return
+	}
+	conn.WriteInt(len(query.apply(elements)))
+}
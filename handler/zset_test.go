@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+// zsetRecords are served by zset tests, next to the mock records
+var zsetRecords = []store.Record{
+	{
+		Key:  "leaderboard",
+		Type: store.ZSetType,
+		// deliberately unsorted, with ties on score 2
+		OrdderSetRecord: &store.OrderedSetRecord{
+			Elements: []store.OrderedSetElement{
+				{Value: "d", Score: 3},
+				{Value: "c", Score: 2},
+				{Value: "a", Score: 1},
+				{Value: "b", Score: 2},
+				{Value: "e", Score: 4.5},
+			},
+		},
+	},
+}
+
+func TestZRange(t *testing.T) {
+	rdb := clientWithRecords(t, zsetRecords...)
+	ctx := context.Background()
+
+	values, err := rdb.ZRange(ctx, "leaderboard", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, values)
+
+	values, err = rdb.ZRange(ctx, "leaderboard", -2, 100).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d", "e"}, values)
+
+	values, err = rdb.ZRange(ctx, "leaderboard", 3, 1).Result()
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	values, err = rdb.ZRevRange(ctx, "leaderboard", 0, 1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e", "d"}, values)
+
+	withScores, err := rdb.ZRangeWithScores(ctx, "leaderboard", 0, 0).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.Z{{Member: "a", Score: 1}}, withScores)
+
+	values, err = rdb.ZRange(ctx, "nosuchkey", 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Empty(t, values)
+
+	_, err = rdb.ZRange(ctx, "key0:hash", 0, -1).Result()
+	assert.EqualError(t, err, wrongTypeError)
+}
+
+func TestZRangeArgs(t *testing.T) {
+	rdb := clientWithRecords(t, zsetRecords...)
+	ctx := context.Background()
+
+	values, err := rdb.ZRangeArgs(ctx, redis.ZRangeArgs{
+		Key: "leaderboard", Start: "(1", Stop: "+inf", ByScore: true, Offset: 1, Count: 2,
+	}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, values)
+
+	values, err = rdb.ZRangeArgs(ctx, redis.ZRangeArgs{
+		// go-redis swaps start and stop for reversed score ranges
+		Key: "leaderboard", Start: "2", Stop: "+inf", ByScore: true, Rev: true,
+	}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"e", "d", "c", "b"}, values)
+
+	values, err = rdb.ZRangeArgs(ctx, redis.ZRangeArgs{
+		Key: "leaderboard", Start: "[b", Stop: "(d", ByLex: true,
+	}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, values)
+
+	_, err = rdb.Do(ctx, "zrange", "leaderboard", 0, -1, "limit", 0, 1).Result()
+	assert.Error(t, err)
+
+	_, err = rdb.Do(ctx, "zrange", "leaderboard", "-", "+", "bylex", "withscores").Result()
+	assert.Error(t, err)
+}
+
+func TestZRangeByScore(t *testing.T) {
+	rdb := clientWithRecords(t, zsetRecords...)
+	ctx := context.Background()
+
+	values, err := rdb.ZRangeByScore(ctx, "leaderboard", &redis.ZRangeBy{Min: "2", Max: "3"}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "d"}, values)
+
+	values, err = rdb.ZRangeByScore(ctx, "leaderboard", &redis.ZRangeBy{Min: "-inf", Max: "(2"}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a"}, values)
+
+	values, err = rdb.ZRevRangeByScore(ctx, "leaderboard", &redis.ZRangeBy{Min: "-inf", Max: "+inf", Offset: 1, Count: 2}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d", "c"}, values)
+
+	withScores, err := rdb.ZRangeByScoreWithScores(ctx, "leaderboard", &redis.ZRangeBy{Min: "4", Max: "inf"}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []redis.Z{{Member: "e", Score: 4.5}}, withScores)
+
+	_, err = rdb.ZRangeByScore(ctx, "leaderboard", &redis.ZRangeBy{Min: "abc", Max: "1"}).Result()
+	assert.EqualError(t, err, errScoreNotFloat.Error())
+
+	values, err = rdb.ZRangeByLex(ctx, "leaderboard", &redis.ZRangeBy{Min: "(a", Max: "[c"}).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, values)
+}
+
+func TestZScoreAndRank(t *testing.T) {
+	rdb := clientWithRecords(t, zsetRecords...)
+	ctx := context.Background()
+
+	score, err := rdb.ZScore(ctx, "leaderboard", "e").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, 4.5, score)
+
+	_, err = rdb.ZScore(ctx, "leaderboard", "z").Result()
+	assert.Equal(t, redis.Nil, err)
+
+	scores, err := rdb.ZMScore(ctx, "leaderboard", "a", "z", "d").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{1, 0, 3}, scores)
+
+	rank, err := rdb.ZRank(ctx, "leaderboard", "c").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), rank)
+
+	rank, err = rdb.ZRevRank(ctx, "leaderboard", "c").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), rank)
+
+	rank, err = rdb.ZRevRank(ctx, "leaderboard", "a").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), rank)
+
+	_, err = rdb.ZRank(ctx, "leaderboard", "z").Result()
+	assert.Equal(t, redis.Nil, err)
+}
+
+func TestZCount(t *testing.T) {
+	rdb := clientWithRecords(t, zsetRecords...)
+	ctx := context.Background()
+
+	count, err := rdb.ZCount(ctx, "leaderboard", "(1", "3").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	count, err = rdb.ZLexCount(ctx, "leaderboard", "-", "+").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+
+	count, err = rdb.ZCount(ctx, "nosuchkey", "-inf", "+inf").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
@@ -47,59 +49,170 @@ import (
 	return store, nil
 }*/
 
-type StoreConfig struct {
+// LayerConfig is a records file and its optional index
+type LayerConfig struct {
 	RecordsFileName string `json:"records_file_name"`
 	IndexFileName   string `json:"index_file_name,omitempty"`
+	// Validate refuses to serve the layer if it fails validation
+	Validate bool `json:"validate,omitempty"`
+	// VerifyRecordsHash hashes the records file on every load to check
+	// it's the one the index was built from, not only when its mtime changed
+	VerifyRecordsHash bool `json:"verify_records_hash,omitempty"`
+	// RebuildMismatchedIndex builds the index from records if the index
+	// file was built from another records file, instead of failing to load
+	RebuildMismatchedIndex bool `json:"rebuild_mismatched_index,omitempty"`
 }
 
-func loadStore(storeConfig StoreConfig) (*store.Store, error) {
-	if storeConfig.RecordsFileName == "" {
-		return nil, fmt.Errorf("records file name is empty")
+// StoreConfig is either a single records file, or layers: a base followed
+// by deltas, from the oldest to the newest. Delta records replace base
+// records with the same key, and tombstone records delete them.
+type StoreConfig struct {
+	LayerConfig
+	Layers []LayerConfig `json:"layers,omitempty"`
+}
+
+// layers returns all layers, records_file_name being the base if it's set
+func (c StoreConfig) layers() []LayerConfig {
+	if c.RecordsFileName == "" {
+		return c.Layers
 	}
+	return append([]LayerConfig{c.LayerConfig}, c.Layers...)
+}
 
-	buildIndex := true
-	if storeConfig.IndexFileName == "" {
-		buildIndex = false
+// loadStore loads the layers of a store, config is what's common to all
+// of them
+func loadStore(storeConfig StoreConfig, config store.Config) (store.Backend, error) {
+	layerConfigs := storeConfig.layers()
+	if len(layerConfigs) == 0 {
+		return nil, fmt.Errorf("records file name is empty")
+	}
+	if len(layerConfigs) == 1 {
+		return loadLayer(layerConfigs[0], config)
 	}
-	recordsFileName := storeConfig.RecordsFileName
-	var store_ *store.Store
 
-	var indexFile io.ReadCloser
-	if !buildIndex {
-		var err error
-		indexFile, err = os.Open(storeConfig.IndexFileName)
+	layers := []store.Layer{}
+	for _, layerConfig := range layerConfigs {
+		layer, err := loadLayer(layerConfig, config)
 		if err != nil {
-			buildIndex = true
+			for _, layer := range layers {
+				layer.Close()
+			}
+			return nil, fmt.Errorf("loading layer %s: %w", layerConfig.RecordsFileName, err)
 		}
+		layers = append(layers, layer)
+	}
+	return store.NewLayeredStore(layers...)
+}
 
+func loadLayer(layerConfig LayerConfig, config store.Config) (*store.Store, error) {
+	if layerConfig.RecordsFileName == "" {
+		return nil, fmt.Errorf("records file name is empty")
+	}
+	openRecords, err := recordsOpener(layerConfig.RecordsFileName, config.HTTPSource)
+	if err != nil {
+		return nil, err
 	}
+	config.Validate = layerConfig.Validate
+	config.VerifyRecordsHash = layerConfig.VerifyRecordsHash
+	config.RebuildMismatchedIndex = layerConfig.RebuildMismatchedIndex
 
-	if buildIndex {
-		var err error
-		store_, err = store.NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
-			return os.Open(recordsFileName)
-		})
-		if err != nil {
-			return nil, err
-		}
-		return store_, nil
-	} else {
-		//let's build a store
-		var err error
-		recordsFileName := storeConfig.RecordsFileName
+	// without an index file, the index is built from records
+	if layerConfig.IndexFileName == "" {
+		return store.NewStoreFromRecordsWithConfig(openRecords, config)
+	}
+	indexFile, err := openFile(layerConfig.IndexFileName, config.HTTPSource)
+	if err != nil {
+		log.Printf("Failed to open index %s, building it from records: %v", layerConfig.IndexFileName, err)
+		return store.NewStoreFromRecordsWithConfig(openRecords, config)
+	}
+	// a binary index stays memory mapped after the file is closed
+	defer indexFile.Close()
+
+	config.KeysDontNeedSorting = true
+	// the bloom filter built with the index is next to it, if there is one
+	bloomFile, err := openFile(bloomFilterFileName(layerConfig.IndexFileName), config.HTTPSource)
+	if err != nil {
+		return store.NewStoreFromRecordsWithIndexAndConfig(openRecords, indexFile, config)
+	}
+	defer bloomFile.Close()
+	return store.NewStoreFromRecordsWithIndexAndBloomFilter(openRecords, indexFile, bloomFile, config)
+}
 
-		store_, err = store.NewStoreFromRecordsWithIndex(func() (io.ReadSeekCloser, error) {
+// recordsOpener opens a records file, or records at an http(s) URL, which
+// are fetched with range requests as they are read
+func recordsOpener(recordsFileName string, httpConfig store.HTTPSourceConfig) (store.OpenReaderSeekCloser, error) {
+	if !store.IsURL(recordsFileName) {
+		return func() (io.ReadSeekCloser, error) {
 			return os.Open(recordsFileName)
-		}, indexFile)
+		}, nil
+	}
+	source, err := store.NewHTTPSource(recordsFileName, httpConfig)
+	if err != nil {
+		return nil, err
+	}
+	return source.Open, nil
+}
 
-		if err != nil {
-			return nil, err
-		}
+// openFile opens a file, or fetches a whole file at an http(s) URL
+func openFile(fileName string, httpConfig store.HTTPSourceConfig) (io.ReadCloser, error) {
+	if !store.IsURL(fileName) {
+		return os.Open(fileName)
 	}
-	return store_, nil
+	data, err := store.FetchURL(httpConfig.Client, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// bloomFilterFileName is the name of the bloom filter file of an index
+func bloomFilterFileName(indexFileName string) string {
+	return indexFileName + ".bloom"
+}
+
+// validate checks a records file, and its index if indexFileName is set,
+// printing the problems found. ok is false if there are any.
+func validate(recordsFileName string, indexFileName string) (ok bool, err error) {
+	layer, err := loadValidatedLayer(recordsFileName, indexFileName)
+	if err != nil {
+		return false, err
+	}
+	defer layer.Close()
+
+	report, err := layer.Validate()
+	if err != nil {
+		return false, err
+	}
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("%d records, %d problems\n", report.Records, len(report.Problems))
+	return report.OK(), nil
+}
+
+// loadValidatedLayer loads the layer validate checks. Unlike loadLayer,
+// an index that can't be opened is an error, building one from records
+// instead would check another index than the one given.
+func loadValidatedLayer(recordsFileName string, indexFileName string) (*store.Store, error) {
+	config := store.DefaultConfig()
+	if indexFileName == "" {
+		return loadLayer(LayerConfig{RecordsFileName: recordsFileName}, config)
+	}
+	openRecords, err := recordsOpener(recordsFileName, config.HTTPSource)
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := openFile(indexFileName, config.HTTPSource)
+	if err != nil {
+		return nil, fmt.Errorf("opening index %s: %w", indexFileName, err)
+	}
+	defer indexFile.Close()
+
+	config.KeysDontNeedSorting = true
+	return store.NewStoreFromRecordsWithIndexAndConfig(openRecords, indexFile, config)
 }
 
-func readConfigFromFile(configFileName string) (storeConfig *StoreConfig, lastModifed *time.Time, err error) {
+func readConfigFromFile(configFileName string) (config *Config, lastModifed *time.Time, err error) {
 	stats, err := os.Stat(configFileName)
 	if err != nil {
 		return nil, nil, err
@@ -112,64 +225,179 @@ func readConfigFromFile(configFileName string) (storeConfig *StoreConfig, lastMo
 		return nil, nil, err
 	}
 
-	storeConfig = &StoreConfig{}
-	err = json.Unmarshal(b, &storeConfig)
+	config = &Config{}
+	err = json.Unmarshal(b, config)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return storeConfig, lastModifed, nil
+	return config, lastModifed, nil
 }
 
-func generateIndex(recordsFileName string, indexFileName string) error {
+// writeIndex writes the index of a records file, with a header linking it
+// to the records file, and with record CRCs if withCRC is set
+func writeIndex(index *store.StoreIndex, recordsFileName string, indexFileName string, indexFormat string, withCRC bool, bloomFalsePositiveRate float64) error {
 	recordsFile, err := os.Open(recordsFileName)
 	if err != nil {
 		return err
 	}
-
-	index, err := store.BuildIndex(recordsFile)
+	defer recordsFile.Close()
+	fingerprint, err := store.FingerprintRecords(recordsFile)
 	if err != nil {
 		return err
 	}
-	// open file for writing
-	indexFile, err := os.OpenFile(indexFileName, os.O_WRONLY|os.O_CREATE, 0644)
+	index.Header = &store.IndexHeader{Records: fingerprint}
+	if !withCRC {
+		index.ClearCRC()
+	}
+
+	if bloomFalsePositiveRate > 0 {
+		if err := writeBloomFilter(index, bloomFilterFileName(indexFileName), bloomFalsePositiveRate); err != nil {
+			return err
+		}
+	}
+
+	indexFile, err := os.Create(indexFileName)
 	if err != nil {
 		return err
 	}
 	defer indexFile.Close()
-	err = index.WriteJsonl(indexFile)
+
+	switch indexFormat {
+	case "jsonl":
+		return index.WriteJsonl(indexFile)
+	case "binary":
+		return index.WriteBinary(indexFile)
+	}
+	return fmt.Errorf("unknown index format %s", indexFormat)
+}
+
+func writeBloomFilter(index *store.StoreIndex, bloomFileName string, falsePositiveRate float64) error {
+	bloomFile, err := os.Create(bloomFileName)
+	if err != nil {
+		return err
+	}
+	defer bloomFile.Close()
+
+	filter, err := store.BuildBloomFilter(index, falsePositiveRate)
 	if err != nil {
 		return err
 	}
-	return nil
+	if err := filter.WriteBinary(bloomFile); err != nil {
+		return err
+	}
+	return bloomFile.Close()
+}
+
+func generateIndex(recordsFileName string, indexFileName string, indexFormat string, workers int, withCRC bool, bloomFalsePositiveRate float64) error {
+	recordsFile, err := os.Open(recordsFileName)
+	if err != nil {
+		return err
+	}
+	defer recordsFile.Close()
+
+	stats, err := recordsFile.Stat()
+	if err != nil {
+		return err
+	}
+	index, err := store.BuildIndexParallel(recordsFile, stats.Size(), workers)
+	if err != nil {
+		return err
+	}
+	return writeIndex(index, recordsFileName, indexFileName, indexFormat, withCRC, bloomFalsePositiveRate)
+}
+
+func compressRecords(recordsFileName string, compressedFileName string, indexFileName string, indexFormat string, withCRC bool, bloomFalsePositiveRate float64, compression store.Compression, blockSize int) error {
+	recordsFile, err := os.Open(recordsFileName)
+	if err != nil {
+		return err
+	}
+	defer recordsFile.Close()
+
+	compressedFile, err := os.Create(compressedFileName)
+	if err != nil {
+		return err
+	}
+	defer compressedFile.Close()
+
+	index, err := store.WriteCompressedRecords(recordsFile, compressedFile, compression, blockSize)
+	if err != nil {
+		return err
+	}
+	// the compressed file is fingerprinted once it's complete
+	if err := compressedFile.Close(); err != nil {
+		return err
+	}
+	return writeIndex(index, compressedFileName, indexFileName, indexFormat, withCRC, bloomFalsePositiveRate)
 }
 
 func main() {
 	onlyGenerateIndex := flag.Bool("only_generate_index", false, "only generate index")
+	validateOnly := flag.Bool("validate", false, "validate records file, and its index if index_file_name is set, print the problems found and exit")
 	recordsFileName := flag.String("records_file_name", "", "records file name for index generation")
 	indexFileName := flag.String("index_file_name", "", "records file name for index generation")
+	indexBuildWorkers := flag.Int("index_build_workers", 0, "goroutines building an index from records, 0 for one per core")
+	indexCRC := flag.Bool("index_crc", true, "write the CRC of every record to a generated index, to check records on read")
+	bloomFalsePositiveRate := flag.Float64("bloom-false-positive-rate", 0.01, "false positive rate of the bloom filter of missing keys, written next to a generated index as index_file_name.bloom, 0 disables bloom filters")
+	bloomFilterOnLoad := flag.Bool("bloom-filter-on-load", false, "build the bloom filter of an index on load when its bloom filter file is missing, of another index or less precise than bloom-false-positive-rate")
+	indexFormat := flag.String("index_format", "jsonl", "format of a generated index, jsonl or binary (memory mapped on load)")
+	compress := flag.String("compress", "", "compress records file into compressed_file_name (gzip or zstd), write its index to index_file_name and exit")
+	compressedFileName := flag.String("compressed_file_name", "", "compressed records file name for compression")
+	blockSize := flag.Int("block_size", store.DefaultBlockSize, "uncompressed size of a compressed block")
 	addr := flag.String("addr", "localhost:6380", "addr to listen on")
 
 	configFileName := flag.String("config_file_name", "config.json", "config file name, with records file name and index file name")
-	checkConfigInterval := flag.Duration("check-config-interval", 5*time.Second, "check config file interval")
+	checkConfigInterval := flag.Duration("check-config-interval", 5*time.Second, "check config file interval, and manifest url interval if the config has one")
+	manifestCacheDir := flag.String("manifest-cache-dir", "manifest_cache", "directory versions downloaded from the manifest url of the config are kept in")
+	manifestKeepVersions := flag.Int("manifest-keep-versions", 3, "versions kept in manifest-cache-dir for rollback, at least 2")
+	manifestDownloadTimeout := flag.Duration("manifest-download-timeout", 10*time.Minute, "how long downloading a version from the manifest url can take, config changes aren't checked meanwhile. 0 for no timeout")
+	writableOverlay := flag.Bool("writable-overlay", false, "serve write commands (SET, DEL, HSET, ...) from an in-memory overlay over the stores, for staging and debugging")
+	keepOverlayOnReload := flag.Bool("keep-overlay-on-reload", false, "keep the writable overlay of a database when it's reloaded, instead of discarding it")
+	recordCacheSize := flag.Int64("record-cache-size", 64<<20, "bytes of decoded records cached per records file, 0 disables the cache")
+	readMode := flag.String("read-mode", "pool", "how records files are read: pool (a pool of seeking readers, up to reader-pool-max reads at a time), pread (concurrent reads of one open file) or mmap (memory mapped files)")
+	readerPoolMin := flag.Int("reader-pool-min", 0, "readers of a records file opened on load and kept open, with -read-mode pool")
+	readerPoolMax := flag.Int("reader-pool-max", 100, "readers of a records file opened when needed, with -read-mode pool")
+	readerPoolIdleTimeout := flag.Duration("reader-pool-idle-timeout", time.Minute, "how long readers above reader-pool-min stay open unused, 0 keeps them open")
+	commandTimeout := flag.Duration("command-timeout", 5*time.Second, "how long a command can read records for before its reads are abandoned, 0 for no timeout")
+	httpBlockSize := flag.Int64("http-block-size", store.DefaultHTTPBlockSize, "size of the blocks records at http(s) URLs are fetched in with range requests")
+	httpTimeout := flag.Duration("http-timeout", store.DefaultHTTPTimeout, "how long a request for a file at an http(s) URL can take, its body included, 0 for no timeout")
+	httpCacheSize := flag.Int64("http-cache-size", store.DefaultHTTPCacheSize, "bytes of fetched blocks cached per records file at an http(s) URL, 0 disables the cache")
+	storeGracePeriod := flag.Duration("store-grace-period", 10*time.Second, "how long a replaced store keeps serving commands in flight before it's closed")
 	flag.Parse()
+	if !(*bloomFalsePositiveRate >= 0 && *bloomFalsePositiveRate < 1) {
+		log.Fatal("bloom-false-positive-rate must be below 1, or 0 to disable bloom filters")
+	}
 
 	//generate index and exit
 	if *onlyGenerateIndex {
-		err := generateIndex(*recordsFileName, *indexFileName)
+		err := generateIndex(*recordsFileName, *indexFileName, *indexFormat, *indexBuildWorkers, *indexCRC, *bloomFalsePositiveRate)
 		if err != nil {
 			log.Fatal(err)
 		}
 		return
 	}
-	//lets read config from file
-	storeConfig, lastModifed, err := readConfigFromFile(*configFileName)
-	if err != nil {
-		log.Fatal(err)
+	//validate records and exit, with status 1 if there are problems
+	if *validateOnly {
+		ok, err := validate(*recordsFileName, *indexFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	//compress records and exit
+	if *compress != "" {
+		err := compressRecords(*recordsFileName, *compressedFileName, *indexFileName, *indexFormat, *indexCRC, *bloomFalsePositiveRate, store.Compression(*compress), *blockSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	//let's load store
-	store_, err := loadStore(*storeConfig)
+	//lets read config from file
+	config, lastModifed, err := readConfigFromFile(*configFileName)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -180,26 +408,62 @@ func main() {
 		log.Fatal(err)
 	}*/
 
-	handler := handler.NewHandler(store_)
-	//config reload loop
+	//let's load stores, db 0 is empty unless it's declared
+	handler := handler.NewHandler(store.NewEmptyStore())
+	handler.CommandTimeout = *commandTimeout
+	loader := newDatabaseLoader(handler, *storeGracePeriod)
+	loader.storeConfig.RecordCacheSize = *recordCacheSize
+	loader.storeConfig.BloomFalsePositiveRate = *bloomFalsePositiveRate
+	loader.storeConfig.BuildBloomFilter = *bloomFilterOnLoad
+	loader.storeConfig.MinConnections = *readerPoolMin
+	loader.storeConfig.MaxConnections = *readerPoolMax
+	loader.storeConfig.IdleTimeout = *readerPoolIdleTimeout
+	loader.storeConfig.HTTPSource.BlockSize = *httpBlockSize
+	loader.storeConfig.HTTPSource.CacheSize = *httpCacheSize
+	loader.storeConfig.HTTPSource.Client = &http.Client{Timeout: *httpTimeout}
+	loader.storeConfig.ReadMode, err = store.ParseReadMode(*readMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	loader.writableOverlay = *writableOverlay
+	loader.keepOverlay = *keepOverlayOnReload
+	manifests := newManifestFetcher(*manifestCacheDir, *manifestKeepVersions, *manifestDownloadTimeout)
+	resolved, _, err := manifests.resolve(*config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = loader.load(resolved)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//config reload loop, which polls the manifest too if there's one
 	go func() {
+		failed := false
 		for {
 			time.Sleep(*checkConfigInterval)
 
-			storeConfig, modified, err := readConfigFromFile(*configFileName)
+			config, modified, err := readConfigFromFile(*configFileName)
 			if err != nil {
 				log.Println(fmt.Errorf("Failed to load a config %w", err))
 				continue
 
 			}
-			if modified != nil && !modified.Equal(*lastModifed) {
-				store_, err := loadStore(*storeConfig)
-				if err != nil {
-					log.Println(fmt.Errorf("Failed to load a store %w", err))
+			resolved, changed, err := manifests.resolve(*config)
+			if err != nil {
+				log.Println(fmt.Errorf("Failed to fetch a manifest %w", err))
+				continue
+			}
+			if failed || changed || (modified != nil && !modified.Equal(*lastModifed)) {
+				// databases that failed to load are retried on the next
+				// check, their files may still be being written
+				if err := loader.load(resolved); err != nil {
+					log.Println(fmt.Errorf("Failed to reload databases %w", err))
+					failed = true
 				} else {
-					handler.Store = store_
+					lastModifed = modified
+					failed = false
 				}
-
 			}
 
 		}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/handler"
+	"github.com/tikibu/rostore/store"
+)
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	recordsFileName := filepath.Join(dir, "records.jsonl")
+	indexFileName := filepath.Join(dir, "index.jsonl")
+	assert.NoError(t, os.WriteFile(recordsFileName, store.MockJsonlBytes(store.MockRecords()), 0644))
+	assert.NoError(t, generateIndex(recordsFileName, indexFileName, "jsonl", 1, true, 0))
+
+	ok, err := validate(recordsFileName, indexFileName)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = validate(recordsFileName, "")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// an index that can't be read isn't replaced by one built from records
+	_, err = validate(recordsFileName, filepath.Join(dir, "missing.jsonl"))
+	assert.Error(t, err)
+}
+
+func TestFileModTimesOfBloomFilter(t *testing.T) {
+	dir := t.TempDir()
+	recordsFileName := filepath.Join(dir, "records.jsonl")
+	indexFileName := filepath.Join(dir, "index.jsonl")
+	assert.NoError(t, os.WriteFile(recordsFileName, store.MockJsonlBytes(store.MockRecords()), 0644))
+	assert.NoError(t, generateIndex(recordsFileName, indexFileName, "jsonl", 1, true, 0.01))
+	storeConfig := StoreConfig{LayerConfig: LayerConfig{RecordsFileName: recordsFileName, IndexFileName: indexFileName}}
+
+	// replacing only the bloom filter of the index changes the times
+	modTimes := fileModTimes(storeConfig)
+	assert.Len(t, modTimes, 3)
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(bloomFilterFileName(indexFileName), later, later))
+	assert.NotEqual(t, modTimes, fileModTimes(storeConfig))
+}
+
+func TestOverlayOfFailedSwap(t *testing.T) {
+	recordsFileName := filepath.Join(t.TempDir(), "records.jsonl")
+	assert.NoError(t, os.WriteFile(recordsFileName, store.MockJsonlBytes(store.MockRecords()), 0644))
+	storeConfig := StoreConfig{LayerConfig: LayerConfig{RecordsFileName: recordsFileName}}
+
+	loader := newDatabaseLoader(handler.NewHandler(store.NewEmptyStore()), 0)
+	loader.writableOverlay = true
+	loader.keepOverlay = true
+	assert.NoError(t, loader.load(Config{Databases: []DatabaseConfig{{DB: 1, Name: "a", StoreConfig: storeConfig}}}))
+	served := loader.overlays[1]
+	assert.NoError(t, served.Set("written", "value", 0))
+
+	// db 1 keeps its name, so db 2 can't be added, its overlay isn't kept
+	err := loader.load(Config{Databases: []DatabaseConfig{
+		{DB: 1, Name: "b", StoreConfig: storeConfig},
+		{DB: 2, Name: "a", StoreConfig: storeConfig},
+	}})
+	assert.Error(t, err)
+	assert.NotContains(t, loader.overlays, 2)
+	assert.NotSame(t, served, loader.overlays[1])
+	record, err := loader.overlays[1].GetRecord("written")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", record.StringRecord.Value)
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tikibu/rostore/store"
+)
+
+// Manifest describes a version of the stores served from a manifest URL.
+// It's a config whose file names are relative to the manifest URL, with
+// the sha256 of every file to download. Files at absolute URLs aren't
+// downloaded, and are served remotely.
+type Manifest struct {
+	// Version names the version, it's the name of its cache directory
+	Version string `json:"version"`
+	Config
+	// Checksums are the sha256 hashes of the files of the version, in hex,
+	// by file name. The bloom filter of an index is downloaded if it's
+	// listed here.
+	Checksums map[string]string `json:"checksums"`
+}
+
+// manifestFileName is where the manifest of a version is kept in its cache
+// directory
+const manifestFileName = ".manifest.json"
+
+// manifestFetchTimeout is how long fetching a manifest can take, the
+// files of a version have their own timeout
+const manifestFetchTimeout = 30 * time.Second
+
+// manifestFetcher polls a manifest URL, and downloads new versions into a
+// cache directory, one directory per version. A version directory is
+// renamed into place once all its files are verified, so versions in the
+// cache are always complete.
+type manifestFetcher struct {
+	client   *http.Client
+	cacheDir string
+	// keep is how many versions are kept in the cache, the most recently
+	// used ones
+	keep int
+	// downloadTimeout is how long downloading the files of a version can
+	// take, 0 for no timeout. Config changes aren't checked meanwhile.
+	downloadTimeout time.Duration
+
+	// url is the manifest URL the validators and the latest version are
+	// for
+	url          string
+	etag         string
+	lastModified string
+	latest       string
+	// version is the version last resolved, pinned or latest
+	version string
+}
+
+func newManifestFetcher(cacheDir string, keep int, downloadTimeout time.Duration) *manifestFetcher {
+	// the version being replaced is still served during the grace period
+	if keep < 2 {
+		keep = 2
+	}
+	return &manifestFetcher{
+		client:          &http.Client{},
+		cacheDir:        cacheDir,
+		keep:            keep,
+		downloadTimeout: downloadTimeout,
+	}
+}
+
+// resolve returns the config to load for config. Without a manifest URL
+// it's config itself, otherwise it's the config of the manifest, either
+// of config.ManifestVersion from the cache, or of the latest version on the
+// server. changed is set when the version differs from the last resolved
+// one. Until a version is resolved, the most recently used version in the
+// cache is resolved when the manifest can't be fetched, so that a server
+// restarts while the manifest server is down.
+func (f *manifestFetcher) resolve(config Config) (resolved Config, changed bool, err error) {
+	if config.ManifestURL == "" {
+		return config, false, nil
+	}
+
+	version := config.ManifestVersion
+	if version == "" {
+		version, err = f.fetch(config.ManifestURL)
+		if err != nil && f.version == "" {
+			cached, cachedErr := f.lastUsedVersion()
+			if cachedErr != nil {
+				return Config{}, false, fmt.Errorf("%w, and no version is cached: %v", err, cachedErr)
+			}
+			log.Printf("Failed to fetch manifest %s, serving cached version %s: %v", config.ManifestURL, cached, err)
+			version, err = cached, nil
+		}
+		if err != nil {
+			return Config{}, false, err
+		}
+	}
+	resolved, err = f.load(version)
+	if err != nil {
+		return Config{}, false, err
+	}
+	changed = version != f.version
+	f.version = version
+	if changed {
+		f.prune()
+	}
+	return resolved, changed, nil
+}
+
+// fetch polls the manifest, downloading its version if it's not cached
+// yet, and returns the version. The latest version fetched is returned
+// when the manifest didn't change.
+func (f *manifestFetcher) fetch(manifestURL string) (string, error) {
+	if manifestURL != f.url {
+		f.url, f.etag, f.lastModified, f.latest = manifestURL, "", "", ""
+	}
+
+	request, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if f.etag != "" {
+		request.Header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		request.Header.Set("If-Modified-Since", f.lastModified)
+	}
+	client := *f.client
+	client.Timeout = manifestFetchTimeout
+	response, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified && f.latest != "" {
+		return f.latest, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching manifest %s: %s", manifestURL, response.Status)
+	}
+	manifestBytes, err := io.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := parseManifest(manifestBytes)
+	if err != nil {
+		return "", fmt.Errorf("manifest %s: %w", manifestURL, err)
+	}
+
+	if _, err := os.Stat(f.versionDir(manifest.Version)); os.IsNotExist(err) {
+		if err := f.download(manifestURL, manifest, manifestBytes); err != nil {
+			return "", fmt.Errorf("downloading version %s: %w", manifest.Version, err)
+		}
+		log.Printf("Downloaded version %s of %s", manifest.Version, manifestURL)
+	}
+	// validators are only kept once the version is in the cache, so a
+	// failed download is retried
+	f.etag = response.Header.Get("ETag")
+	f.lastModified = response.Header.Get("Last-Modified")
+	f.latest = manifest.Version
+	return manifest.Version, nil
+}
+
+func parseManifest(manifestBytes []byte) (*Manifest, error) {
+	manifest := &Manifest{}
+	if err := json.Unmarshal(manifestBytes, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.ManifestURL != "" || manifest.ManifestVersion != "" {
+		return nil, fmt.Errorf("a manifest can't name another manifest")
+	}
+	// versions starting with a dot would be taken for temporary directories
+	if manifest.Version == "" || manifest.Version != filepath.Base(manifest.Version) || strings.HasPrefix(manifest.Version, ".") {
+		return nil, fmt.Errorf("version %q can't be a directory name", manifest.Version)
+	}
+	for fileName := range manifest.Checksums {
+		if !isLocalName(fileName) || fileName == manifestFileName {
+			return nil, fmt.Errorf("file %s is outside the version directory", fileName)
+		}
+	}
+	// every relative file must be downloaded and verified
+	_, err := manifest.Config.mapFileNames(func(fileName string) (string, error) {
+		if _, ok := manifest.Checksums[fileName]; !ok && !store.IsURL(fileName) {
+			return "", fmt.Errorf("file %s has no checksum", fileName)
+		}
+		return fileName, nil
+	})
+	return manifest, err
+}
+
+// isLocalName tells whether a relative file name stays in its directory
+func isLocalName(fileName string) bool {
+	clean := path.Clean(fileName)
+	return fileName != "" && !path.IsAbs(clean) && clean != "." && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+// download downloads the files of a manifest into a temporary directory,
+// and renames it into the cache once they are verified
+func (f *manifestFetcher) download(manifestURL string, manifest *Manifest, manifestBytes []byte) error {
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(f.cacheDir, 0755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(f.cacheDir, ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if f.downloadTimeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), f.downloadTimeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	for fileName, checksum := range manifest.Checksums {
+		fileURL, err := base.Parse(fileName)
+		if err != nil {
+			return err
+		}
+		if err := f.downloadFile(ctx, fileURL.String(), filepath.Join(tmpDir, filepath.FromSlash(fileName)), checksum); err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+	}
+	if err := restoreModTimes(tmpDir, manifest); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, manifestFileName), manifestBytes, 0644); err != nil {
+		return err
+	}
+	// temporary directories are only readable by their owner
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, f.versionDir(manifest.Version))
+}
+
+// downloadFile downloads a file, and checks its sha256
+func (f *manifestFetcher) downloadFile(ctx context.Context, fileURL string, fileName string, checksum string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := f.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", fileURL, response.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hasher), response.Body); err != nil {
+		return err
+	}
+	if hash := hex.EncodeToString(hasher.Sum(nil)); !strings.EqualFold(hash, checksum) {
+		return fmt.Errorf("sha256 is %s, the manifest says %s", hash, checksum)
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// restoreModTimes sets the modification time of downloaded records files
+// to the one in the header of their index, when the index was built from
+// records with the checksum of the manifest. They aren't hashed again when
+// they are loaded then.
+func restoreModTimes(dir string, manifest *Manifest) error {
+	storeConfigs := []StoreConfig{manifest.StoreConfig}
+	for _, db := range manifest.Databases {
+		storeConfigs = append(storeConfigs, db.StoreConfig)
+	}
+	for _, storeConfig := range storeConfigs {
+		for _, layer := range storeConfig.layers() {
+			checksum, ok := manifest.Checksums[layer.RecordsFileName]
+			if !ok || store.IsURL(layer.IndexFileName) || layer.IndexFileName == "" {
+				continue
+			}
+			header, err := readIndexHeader(filepath.Join(dir, filepath.FromSlash(layer.IndexFileName)))
+			if err != nil {
+				return fmt.Errorf("%s: %w", layer.IndexFileName, err)
+			}
+			if header == nil || !strings.EqualFold(header.Records.Hash, checksum) {
+				continue
+			}
+			modTime := time.Unix(0, header.Records.ModTime)
+			if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(layer.RecordsFileName)), modTime, modTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readIndexHeader returns the header of an index file, nil if it has none
+func readIndexHeader(fileName string) (*store.IndexHeader, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	index, err := store.ReadIndex(file, true)
+	if err != nil {
+		return nil, err
+	}
+	defer index.Close()
+	if headerIndex, ok := index.(interface{ GetHeader() *store.IndexHeader }); ok {
+		return headerIndex.GetHeader(), nil
+	}
+	return nil, nil
+}
+
+func (f *manifestFetcher) versionDir(version string) string {
+	return filepath.Join(f.cacheDir, version)
+}
+
+// load reads the manifest of a cached version, and returns its config with
+// file names in the version directory
+func (f *manifestFetcher) load(version string) (Config, error) {
+	versionDir := f.versionDir(version)
+	manifestBytes, err := ioutil.ReadFile(filepath.Join(versionDir, manifestFileName))
+	if err != nil {
+		return Config{}, fmt.Errorf("version %s isn't in the cache: %w", version, err)
+	}
+	manifest, err := parseManifest(manifestBytes)
+	if err != nil {
+		return Config{}, err
+	}
+
+	config, err := manifest.Config.mapFileNames(func(fileName string) (string, error) {
+		if store.IsURL(fileName) {
+			return fileName, nil
+		}
+		return filepath.Join(versionDir, filepath.FromSlash(fileName)), nil
+	})
+	if err != nil {
+		return Config{}, err
+	}
+	// the modification time of a version directory is when it was last used
+	now := time.Now()
+	if err := os.Chtimes(versionDir, now, now); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// lastUsedVersion returns the most recently used version in the cache
+func (f *manifestFetcher) lastUsedVersion() (string, error) {
+	versions, err := f.cachedVersions()
+	if err != nil {
+		return "", err
+	}
+	for _, version := range versions {
+		if _, err := os.Stat(filepath.Join(f.versionDir(version.Name()), manifestFileName)); err == nil {
+			return version.Name(), nil
+		}
+	}
+	return "", fmt.Errorf("no version in %s", f.cacheDir)
+}
+
+// cachedVersions lists the version directories of the cache, from the most
+// to the least recently used
+func (f *manifestFetcher) cachedVersions() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(f.cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	versions := []os.FileInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			versions = append(versions, entry)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ModTime().After(versions[j].ModTime())
+	})
+	return versions, nil
+}
+
+// prune removes the least recently used versions from the cache, keeping
+// f.keep of them
+func (f *manifestFetcher) prune() {
+	versions, err := f.cachedVersions()
+	if err != nil {
+		log.Printf("Failed to list versions in %s: %v", f.cacheDir, err)
+		return
+	}
+	for i := f.keep; i < len(versions); i++ {
+		if versions[i].Name() == f.version {
+			continue
+		}
+		if err := os.RemoveAll(f.versionDir(versions[i].Name())); err != nil {
+			log.Printf("Failed to remove version %s: %v", versions[i].Name(), err)
+		}
+	}
+}
+
+// mapFileNames returns a copy of the config with records and index file
+// names mapped by mapName
+func (c Config) mapFileNames(mapName func(string) (string, error)) (Config, error) {
+	mapped := c
+	var err error
+	mapped.StoreConfig, err = c.StoreConfig.mapFileNames(mapName)
+	if err != nil {
+		return Config{}, err
+	}
+	mapped.Databases = nil
+	for _, db := range c.Databases {
+		db.StoreConfig, err = db.StoreConfig.mapFileNames(mapName)
+		if err != nil {
+			return Config{}, err
+		}
+		mapped.Databases = append(mapped.Databases, db)
+	}
+	return mapped, nil
+}
+
+func (c StoreConfig) mapFileNames(mapName func(string) (string, error)) (StoreConfig, error) {
+	mapped := c
+	var err error
+	mapped.LayerConfig, err = c.LayerConfig.mapFileNames(mapName)
+	if err != nil {
+		return StoreConfig{}, err
+	}
+	mapped.Layers = nil
+	for _, layer := range c.Layers {
+		layer, err = layer.mapFileNames(mapName)
+		if err != nil {
+			return StoreConfig{}, err
+		}
+		mapped.Layers = append(mapped.Layers, layer)
+	}
+	return mapped, nil
+}
+
+func (c LayerConfig) mapFileNames(mapName func(string) (string, error)) (LayerConfig, error) {
+	mapped := c
+	var err error
+	for _, fileName := range []*string{&mapped.RecordsFileName, &mapped.IndexFileName} {
+		if *fileName == "" {
+			continue
+		}
+		if *fileName, err = mapName(*fileName); err != nil {
+			return LayerConfig{}, err
+		}
+	}
+	return mapped, nil
+}
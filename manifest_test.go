@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tikibu/rostore/store"
+)
+
+// manifestServer serves a manifest with the ETag of its version, and the
+// files of the versions published
+type manifestServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	manifest []byte
+	etag     string
+	files    map[string][]byte
+	// requests counts GET requests by path, notModified the manifest
+	// requests answered with 304
+	requests    map[string]int
+	notModified int
+	// stall blocks requests for files until they are canceled
+	stall bool
+}
+
+func newManifestServer(t *testing.T) *manifestServer {
+	s := &manifestServer{files: map[string][]byte{}, requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *manifestServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	s.requests[r.URL.Path]++
+	if r.URL.Path == "/manifest.json" {
+		defer s.mutex.Unlock()
+		if r.Header.Get("If-None-Match") == s.etag {
+			s.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", s.etag)
+		w.Write(s.manifest)
+		return
+	}
+	file, ok := s.files[r.URL.Path]
+	stall := s.stall
+	s.mutex.Unlock()
+	if stall {
+		<-r.Context().Done()
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(file)
+}
+
+// publish makes a version of records with their index the latest one.
+// checksums override the checksums of the files.
+func (s *manifestServer) publish(t *testing.T, version string, checksums map[string]string) {
+	recordsBytes, indexBytes := recordsWithIndex(t)
+	manifest := Manifest{
+		Version: version,
+		Config: Config{StoreConfig: StoreConfig{LayerConfig: LayerConfig{
+			RecordsFileName: version + "/records.jsonl",
+			IndexFileName:   version + "/index.jsonl",
+		}}},
+		Checksums: map[string]string{},
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for fileName, fileBytes := range map[string][]byte{manifest.RecordsFileName: recordsBytes, manifest.IndexFileName: indexBytes} {
+		s.files["/"+fileName] = fileBytes
+		hash := sha256.Sum256(fileBytes)
+		manifest.Checksums[fileName] = hex.EncodeToString(hash[:])
+	}
+	for fileName, checksum := range checksums {
+		manifest.Checksums[fileName] = checksum
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	assert.NoError(t, err)
+	s.manifest, s.etag = manifestBytes, `"`+version+`"`
+}
+
+func (s *manifestServer) count(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[path]
+}
+
+// recordsWithIndex returns mock records, modified a while ago, and their
+// index with a header
+func recordsWithIndex(t *testing.T) (recordsBytes []byte, indexBytes []byte) {
+	recordsBytes = store.MockJsonlBytes(store.MockRecords())
+	recordsFileName := filepath.Join(t.TempDir(), "records.jsonl")
+	assert.NoError(t, ioutil.WriteFile(recordsFileName, recordsBytes, 0644))
+	modTime := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(recordsFileName, modTime, modTime))
+
+	recordsFile, err := os.Open(recordsFileName)
+	assert.NoError(t, err)
+	defer recordsFile.Close()
+	fingerprint, err := store.FingerprintRecords(recordsFile)
+	assert.NoError(t, err)
+	index, err := store.BuildIndex(bytes.NewReader(recordsBytes))
+	assert.NoError(t, err)
+	index.Header = &store.IndexHeader{Records: fingerprint}
+	var indexBuf bytes.Buffer
+	assert.NoError(t, index.WriteJsonl(&indexBuf))
+	return recordsBytes, indexBuf.Bytes()
+}
+
+// cachedVersionNames lists the directories of a cache, temporary ones too
+func cachedVersionNames(t *testing.T, cacheDir string) []string {
+	entries, err := ioutil.ReadDir(cacheDir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestManifestFetch(t *testing.T) {
+	server := newManifestServer(t)
+	server.publish(t, "v1", nil)
+	cacheDir := t.TempDir()
+	fetcher := newManifestFetcher(cacheDir, 2, time.Minute)
+	config := Config{ManifestURL: server.URL + "/manifest.json"}
+
+	resolved, changed, err := fetcher.resolve(config)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, filepath.Join(cacheDir, "v1", "v1", "records.jsonl"), resolved.RecordsFileName)
+	assert.Equal(t, filepath.Join(cacheDir, "v1", "v1", "index.jsonl"), resolved.IndexFileName)
+	// the version is renamed into place, no temporary directory is left
+	assert.Equal(t, []string{"v1"}, cachedVersionNames(t, cacheDir))
+
+	// downloaded records aren't hashed again when they are loaded
+	indexFile, err := os.Open(resolved.IndexFileName)
+	assert.NoError(t, err)
+	defer indexFile.Close()
+	st, err := store.NewStoreFromRecordsWithIndexAndConfig(func() (io.ReadSeekCloser, error) {
+		return os.Open(resolved.RecordsFileName)
+	}, indexFile, store.DefaultConfig())
+	assert.NoError(t, err)
+	st.Close()
+	header, err := readIndexHeader(resolved.IndexFileName)
+	assert.NoError(t, err)
+	stats, err := os.Stat(resolved.RecordsFileName)
+	assert.NoError(t, err)
+	assert.Equal(t, header.Records.ModTime, stats.ModTime().UnixNano())
+
+	// an unchanged manifest is answered with 304, and nothing is downloaded
+	resolved2, changed, err := fetcher.resolve(config)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, resolved, resolved2)
+	assert.Equal(t, 2, server.count("/manifest.json"))
+	assert.Equal(t, 1, server.notModified)
+	assert.Equal(t, 1, server.count("/v1/records.jsonl"))
+}
+
+func TestManifestChecksumMismatch(t *testing.T) {
+	server := newManifestServer(t)
+	server.publish(t, "v1", map[string]string{"v1/records.jsonl": strings.Repeat("0", 64)})
+	cacheDir := t.TempDir()
+	fetcher := newManifestFetcher(cacheDir, 2, time.Minute)
+	config := Config{ManifestURL: server.URL + "/manifest.json"}
+
+	_, _, err := fetcher.resolve(config)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "sha256")
+	assert.Equal(t, []string{}, cachedVersionNames(t, cacheDir))
+
+	// the manifest isn't taken for unchanged after a failed download
+	server.publish(t, "v1", nil)
+	resolved, changed, err := fetcher.resolve(config)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.FileExists(t, resolved.RecordsFileName)
+}
+
+func TestManifestPruneAndRollback(t *testing.T) {
+	server := newManifestServer(t)
+	cacheDir := t.TempDir()
+	fetcher := newManifestFetcher(cacheDir, 2, time.Minute)
+	config := Config{ManifestURL: server.URL + "/manifest.json"}
+
+	for _, version := range []string{"v1", "v2", "v3"} {
+		server.publish(t, version, nil)
+		_, changed, err := fetcher.resolve(config)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+	}
+	assert.Equal(t, []string{"v2", "v3"}, cachedVersionNames(t, cacheDir))
+
+	// a cached version is rolled back to without fetching the manifest
+	fetched := server.count("/manifest.json")
+	config.ManifestVersion = "v2"
+	resolved, changed, err := fetcher.resolve(config)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, filepath.Join(cacheDir, "v2", "v2", "records.jsonl"), resolved.RecordsFileName)
+	assert.Equal(t, fetched, server.count("/manifest.json"))
+
+	config.ManifestVersion = "v1"
+	_, _, err = fetcher.resolve(config)
+	assert.Error(t, err)
+}
+
+func TestManifestCachedVersionOnStartup(t *testing.T) {
+	server := newManifestServer(t)
+	server.publish(t, "v1", nil)
+	cacheDir := t.TempDir()
+	config := Config{ManifestURL: server.URL + "/manifest.json"}
+	_, _, err := newManifestFetcher(cacheDir, 2, time.Minute).resolve(config)
+	assert.NoError(t, err)
+	server.Close()
+
+	resolved, changed, err := newManifestFetcher(cacheDir, 2, time.Minute).resolve(config)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, filepath.Join(cacheDir, "v1", "v1", "records.jsonl"), resolved.RecordsFileName)
+
+	_, _, err = newManifestFetcher(t.TempDir(), 2, time.Minute).resolve(config)
+	assert.Error(t, err)
+}
+
+func TestManifestDownloadTimeout(t *testing.T) {
+	server := newManifestServer(t)
+	server.publish(t, "v1", nil)
+	server.stall = true
+	cacheDir := t.TempDir()
+	fetcher := newManifestFetcher(cacheDir, 2, 50*time.Millisecond)
+
+	_, _, err := fetcher.resolve(Config{ManifestURL: server.URL + "/manifest.json"})
+	assert.Error(t, err)
+	assert.Equal(t, []string{}, cachedVersionNames(t, cacheDir))
+}
+
+func TestParseManifest(t *testing.T) {
+	for _, manifest := range []string{
+		`{"version": "../v1", "checksums": {}}`,
+		`{"version": ".v1", "checksums": {}}`,
+		`{"version": "", "checksums": {}}`,
+		`{"version": "v1", "checksums": {"../records.jsonl": "00"}}`,
+		`{"version": "v1", "checksums": {"/records.jsonl": "00"}}`,
+		`{"version": "v1", "checksums": {"a/../../records.jsonl": "00"}}`,
+		`{"version": "v1", "checksums": {".manifest.json": "00"}}`,
+		`{"version": "v1", "records_file_name": "records.jsonl", "checksums": {}}`,
+		`{"version": "v1", "manifest_url": "http://localhost/manifest.json", "checksums": {}}`,
+	} {
+		_, err := parseManifest([]byte(manifest))
+		assert.Error(t, err, manifest)
+	}
+
+	manifest, err := parseManifest([]byte(`{"version": "v1", "records_file_name": "a/records.jsonl", "index_file_name": "https://objects.internal/index.bin", "checksums": {"a/records.jsonl": "00"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", manifest.Version)
+}
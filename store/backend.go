@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/tidwall/match"
+)
+
+// Backend is a read only store commands are served from. Store serves
+// indexed records files, MemoryStore serves records held in memory,
+// LayeredStore serves deltas over a base, and Overlay takes writes over
+// another backend.
+type Backend interface {
+	// GetLen returns the number of keys
+	GetLen() int
+	// GetRecordIndex returns the index record of a key, ErrKeyNotFound
+	// if there's no such key
+	GetRecordIndex(key string) (*IndexRecord, error)
+	// GetType returns the type of the record under key
+	GetType(key string) (string, error)
+	GetRecord(key string) (*Record, error)
+	// GetRecords returns records of several keys, nil for missing keys
+	GetRecords(keys []string) ([]*Record, error)
+	// ScanFields returns up to count index records matching pattern, from
+	// position start in key order, and the cursor to continue from
+	ScanFields(start int, count int, pattern string) (records []IndexRecord, cursor int, err error)
+	// Keys returns all keys matching pattern, in sorted order
+	Keys(pattern string) []string
+
+	// The Context variants return the error of ctx once it's done, reads
+	// in flight are abandoned then if ctx has a deadline
+	GetRecordContext(ctx context.Context, key string) (*Record, error)
+	GetRecordsContext(ctx context.Context, keys []string) ([]*Record, error)
+	ScanFieldsContext(ctx context.Context, start int, count int, pattern string) (records []IndexRecord, cursor int, err error)
+	KeysContext(ctx context.Context, pattern string) ([]string, error)
+	GetStringLenContext(ctx context.Context, key string) (int, error)
+	GetStringSliceContext(ctx context.Context, key string, from int, to int) (string, error)
+
+	// RandomKey returns a random key, ErrKeyNotFound if there are none
+	RandomKey() (string, error)
+	// GetStringLen returns the length of a string value, ErrWrongType if
+	// the key holds another type
+	GetStringLen(key string) (int, error)
+	// GetStringSlice returns bytes [from, to) of a string value, bounds
+	// past the value are cut to it, as it can change after its length is
+	// read
+	GetStringSlice(key string, from int, to int) (string, error)
+	// Close releases whatever the backend holds, it must not be used
+	// afterwards
+	Close() error
+}
+
+// CachingBackend is a Backend that caches decoded records
+type CachingBackend interface {
+	Backend
+	CacheStats() CacheStats
+}
+
+var (
+	_ Backend = &Store{}
+	_ Backend = &MemoryStore{}
+	_ Backend = &LayeredStore{}
+	_ Backend = &Overlay{}
+
+	_ CachingBackend = &Store{}
+	_ CachingBackend = &LayeredStore{}
+	_ CachingBackend = &Overlay{}
+
+	_ PoolingBackend = &Store{}
+	_ PoolingBackend = &LayeredStore{}
+	_ PoolingBackend = &Overlay{}
+)
+
+// PoolingBackend is a Backend that reads records with reader pools
+type PoolingBackend interface {
+	Backend
+	ReaderPoolStats() PoolStats
+}
+
+// cacheStats returns the cache counters of a backend, zero if it doesn't
+// cache records
+func cacheStats(backend Backend) CacheStats {
+	if caching, ok := backend.(CachingBackend); ok {
+		return caching.CacheStats()
+	}
+	return CacheStats{}
+}
+
+// readerPoolStats returns the reader pool counters of a backend, zero if it
+// has no reader pool
+func readerPoolStats(backend Backend) PoolStats {
+	if pooling, ok := backend.(PoolingBackend); ok {
+		return pooling.ReaderPoolStats()
+	}
+	return PoolStats{}
+}
+
+// contextCheckInterval is how many keys scans go through between checks
+// of their context
+const contextCheckInterval = 1024
+
+// scanIndex implements ScanFields over an index
+func scanIndex(ctx context.Context, index Index, start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	if start < 0 {
+		return nil, 0, fmt.Errorf("start must be >= 0")
+	}
+	var i = start
+	for ; i < index.Len(); i++ {
+		if (i-start)%contextCheckInterval == 0 && ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		key := index.KeyAt(i)
+
+		if pattern != "" && !match.Match(key, pattern) {
+			continue
+		}
+
+		records = append(records, index.At(i))
+
+		if len(records) >= count {
+			break
+		}
+	}
+	cursor = i + 1
+	if cursor > index.Len()-1 {
+		cursor = 0
+	}
+	return records, cursor, nil
+}
+
+// indexKeys implements Keys over an index. Only keys starting with the
+// literal prefix of the pattern are looked at.
+func indexKeys(ctx context.Context, index Index, pattern string) ([]string, error) {
+	prefix := pattern
+	if wildcard := strings.IndexAny(pattern, "*?\\"); wildcard >= 0 {
+		prefix = pattern[:wildcard]
+	}
+
+	keys := []string{}
+	start := index.Search(prefix)
+	for i := start; i < index.Len(); i++ {
+		if (i-start)%contextCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		key := index.KeyAt(i)
+		if !strings.HasPrefix(key, prefix) {
+			break
+		}
+		if match.Match(key, pattern) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// randomIndexKey implements RandomKey over an index
+func randomIndexKey(index Index) (string, error) {
+	if index.Len() == 0 {
+		return "", ErrKeyNotFound
+	}
+	return index.KeyAt(rand.Intn(index.Len())), nil
+}
+
+// indexType implements GetType over an index
+func indexType(index Index, key string) (string, error) {
+	indexRecord, ok := index.Get(key)
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return indexRecord.Type, nil
+}
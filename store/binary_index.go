@@ -0,0 +1,328 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+)
+
+// The binary index is a flat, little endian layout that can be served
+// straight from a memory mapped file:
+//
+//	header   magic, version, flags, entries count, keys blob offset and
+//	         length, and the records fingerprint of the IndexHeader
+//	entries  fixed size entries, sorted by key
+//	keys     all keys, concatenated in entries order
+//
+// A lookup is a binary search over entries, comparing keys in place,
+// so loading an index doesn't allocate anything per key.
+var binaryIndexMagic = []byte("ROSTIDX\x00")
+
+const (
+	binaryIndexVersion    = 2
+	binaryIndexHeaderSize = 88
+	binaryIndexEntrySize  = 52
+
+	// version 1 indexes have no records fingerprint, and no CRCs
+	binaryIndexV1HeaderSize = 40
+	binaryIndexV1EntrySize  = 48
+)
+
+// header field offsets
+const (
+	headerVersion     = 8
+	headerFlags       = 12
+	headerCount       = 16
+	headerKeysOffset  = 24
+	headerKeysLen     = 32
+	headerRecordsSize = 40
+	headerModTime     = 48
+	headerHash        = 56
+)
+
+// flagHasHeader is set when the index has an IndexHeader
+const flagHasHeader = 1
+
+// entry field offsets
+const (
+	entryKeyOffset   = 0
+	entryOffset      = 8
+	entryBlockOffset = 16
+	entryKeyLen      = 24
+	entryLen         = 28
+	entryBlockLen    = 32
+	entryValueOffset = 36
+	entryValueLen    = 40
+	entryType        = 44
+	entryCRC         = 48
+)
+
+var recordTypeCodes = map[string]byte{
+	StringType: 1,
+	HashType:   2,
+	ListType:   3,
+	SetType:    4,
+	ZSetType:   5,
+
+	TombstoneType: 6,
+}
+
+var recordTypeNames = map[byte]string{
+	1: StringType,
+	2: HashType,
+	3: ListType,
+	4: SetType,
+	5: ZSetType,
+
+	6: TombstoneType,
+}
+
+var ErrNotBinaryIndex = errors.New("not a binary index")
+
+// BinaryIndex is an Index over the binary index format
+type BinaryIndex struct {
+	data       []byte
+	count      int
+	keys       []byte
+	header     *IndexHeader
+	headerSize int
+	entrySize  int
+	release    func([]byte) error
+}
+
+// WriteBinary serializes the index in the binary index format
+func (s *StoreIndex) WriteBinary(out io.Writer) (err error) {
+	keys := make([]string, len(s.SortedKeys))
+	copy(keys, s.SortedKeys)
+	sort.Strings(keys)
+
+	keysLen := 0
+	for _, key := range keys {
+		keysLen += len(key)
+	}
+
+	writer := bufio.NewWriter(out)
+	header := make([]byte, binaryIndexHeaderSize)
+	copy(header, binaryIndexMagic)
+	binary.LittleEndian.PutUint32(header[headerVersion:], binaryIndexVersion)
+	binary.LittleEndian.PutUint64(header[headerCount:], uint64(len(keys)))
+	binary.LittleEndian.PutUint64(header[headerKeysOffset:], uint64(binaryIndexHeaderSize+binaryIndexEntrySize*len(keys)))
+	binary.LittleEndian.PutUint64(header[headerKeysLen:], uint64(keysLen))
+	if s.Header != nil {
+		hash, err := hex.DecodeString(s.Header.Records.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("can't write records hash %q to a binary index", s.Header.Records.Hash)
+		}
+		binary.LittleEndian.PutUint32(header[headerFlags:], flagHasHeader)
+		binary.LittleEndian.PutUint64(header[headerRecordsSize:], uint64(s.Header.Records.Size))
+		binary.LittleEndian.PutUint64(header[headerModTime:], uint64(s.Header.Records.ModTime))
+		copy(header[headerHash:], hash)
+	}
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	entry := make([]byte, binaryIndexEntrySize)
+	keyOffset := 0
+	for _, key := range keys {
+		indexRecord, ok := s.Index[key]
+		if !ok {
+			return ErrIndexKeySerialization
+		}
+		typeCode, ok := recordTypeCodes[indexRecord.Type]
+		if !ok {
+			return fmt.Errorf("can't write record type %q of key %s to a binary index", indexRecord.Type, key)
+		}
+
+		binary.LittleEndian.PutUint64(entry[entryKeyOffset:], uint64(keyOffset))
+		binary.LittleEndian.PutUint64(entry[entryOffset:], uint64(indexRecord.Offset))
+		binary.LittleEndian.PutUint64(entry[entryBlockOffset:], uint64(indexRecord.BlockOffset))
+		binary.LittleEndian.PutUint32(entry[entryKeyLen:], uint32(len(key)))
+		binary.LittleEndian.PutUint32(entry[entryLen:], uint32(indexRecord.Len))
+		binary.LittleEndian.PutUint32(entry[entryBlockLen:], uint32(indexRecord.BlockLen))
+		binary.LittleEndian.PutUint32(entry[entryValueOffset:], uint32(indexRecord.ValueOffset))
+		binary.LittleEndian.PutUint32(entry[entryValueLen:], uint32(indexRecord.ValueLen))
+		entry[entryType] = typeCode
+		binary.LittleEndian.PutUint32(entry[entryCRC:], indexRecord.CRC)
+		if _, err := writer.Write(entry); err != nil {
+			return err
+		}
+		keyOffset += len(key)
+	}
+
+	for _, key := range keys {
+		if _, err := writer.WriteString(key); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// NewBinaryIndex serves an index from data in the binary index format
+func NewBinaryIndex(data []byte) (index *BinaryIndex, err error) {
+	if len(data) < binaryIndexV1HeaderSize || !bytes.Equal(data[:len(binaryIndexMagic)], binaryIndexMagic) {
+		return nil, ErrNotBinaryIndex
+	}
+	index = &BinaryIndex{data: data}
+	switch version := binary.LittleEndian.Uint32(data[headerVersion:]); version {
+	case 1:
+		index.headerSize, index.entrySize = binaryIndexV1HeaderSize, binaryIndexV1EntrySize
+	case binaryIndexVersion:
+		index.headerSize, index.entrySize = binaryIndexHeaderSize, binaryIndexEntrySize
+	default:
+		return nil, fmt.Errorf("unsupported binary index version %d", version)
+	}
+	if len(data) < index.headerSize {
+		return nil, fmt.Errorf("binary index is truncated or corrupted")
+	}
+
+	count := binary.LittleEndian.Uint64(data[headerCount:])
+	keysOffset := binary.LittleEndian.Uint64(data[headerKeysOffset:])
+	keysLen := binary.LittleEndian.Uint64(data[headerKeysLen:])
+	if keysOffset != uint64(index.headerSize)+uint64(index.entrySize)*count || keysOffset+keysLen != uint64(len(data)) {
+		return nil, fmt.Errorf("binary index is truncated or corrupted")
+	}
+	index.count = int(count)
+	index.keys = data[keysOffset:]
+
+	if index.headerSize == binaryIndexHeaderSize && binary.LittleEndian.Uint32(data[headerFlags:])&flagHasHeader != 0 {
+		index.header = &IndexHeader{Records: RecordsFingerprint{
+			Size:    int64(binary.LittleEndian.Uint64(data[headerRecordsSize:])),
+			ModTime: int64(binary.LittleEndian.Uint64(data[headerModTime:])),
+			Hash:    hex.EncodeToString(data[headerHash : headerHash+sha256.Size]),
+		}}
+	}
+	if index.count > 0 {
+		last := index.entry(index.count - 1)
+		if binary.LittleEndian.Uint64(last[entryKeyOffset:])+uint64(binary.LittleEndian.Uint32(last[entryKeyLen:])) != keysLen {
+			return nil, fmt.Errorf("binary index keys are corrupted")
+		}
+	}
+	return index, nil
+}
+
+// OpenBinaryIndex memory maps a binary index file, where the platform
+// allows that. The file can be closed once the index is open.
+func OpenBinaryIndex(file *os.File) (index *BinaryIndex, err error) {
+	data, release, err := mapFile(file)
+	if err != nil {
+		return nil, err
+	}
+	index, err = NewBinaryIndex(data)
+	if err != nil {
+		release(data)
+		return nil, err
+	}
+	index.release = release
+	// methods reading mapped bytes call runtime.KeepAlive once they're done
+	// with them, the finalizer could unmap them during the read otherwise
+	runtime.SetFinalizer(index, (*BinaryIndex).unmap)
+	return index, nil
+}
+
+// ReadIndex reads an index in either format: binary indexes are memory
+// mapped when in is an *os.File, jsonl ones are read with ReadJsonlIndex
+func ReadIndex(in io.Reader, keysDontNeedSorting bool) (Index, error) {
+	reader := bufio.NewReader(in)
+	head, _ := reader.Peek(len(binaryIndexMagic))
+	if !bytes.Equal(head, binaryIndexMagic) {
+		return ReadJsonlIndex(reader, keysDontNeedSorting)
+	}
+
+	if file, ok := in.(*os.File); ok {
+		return OpenBinaryIndex(file)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewBinaryIndex(data)
+}
+
+func (b *BinaryIndex) entry(i int) []byte {
+	start := b.headerSize + i*b.entrySize
+	return b.data[start : start+b.entrySize]
+}
+
+// GetHeader returns the header of the index, nil if it has none
+func (b *BinaryIndex) GetHeader() *IndexHeader {
+	return b.header
+}
+
+func (b *BinaryIndex) keyBytes(i int) []byte {
+	entry := b.entry(i)
+	offset := binary.LittleEndian.Uint64(entry[entryKeyOffset:])
+	length := uint64(binary.LittleEndian.Uint32(entry[entryKeyLen:]))
+	if offset+length > uint64(len(b.keys)) {
+		return nil
+	}
+	return b.keys[offset : offset+length]
+}
+
+func (b *BinaryIndex) Len() int {
+	return b.count
+}
+
+func (b *BinaryIndex) KeyAt(i int) string {
+	key := string(b.keyBytes(i))
+	runtime.KeepAlive(b)
+	return key
+}
+
+func (b *BinaryIndex) At(i int) IndexRecord {
+	entry := b.entry(i)
+	indexRecord := IndexRecord{
+		Key:         string(b.keyBytes(i)),
+		Offset:      int64(binary.LittleEndian.Uint64(entry[entryOffset:])),
+		Len:         int(binary.LittleEndian.Uint32(entry[entryLen:])),
+		Type:        recordTypeNames[entry[entryType]],
+		BlockOffset: int64(binary.LittleEndian.Uint64(entry[entryBlockOffset:])),
+		BlockLen:    int(binary.LittleEndian.Uint32(entry[entryBlockLen:])),
+		ValueOffset: int(binary.LittleEndian.Uint32(entry[entryValueOffset:])),
+		ValueLen:    int(binary.LittleEndian.Uint32(entry[entryValueLen:])),
+	}
+	if len(entry) == binaryIndexEntrySize {
+		indexRecord.CRC = binary.LittleEndian.Uint32(entry[entryCRC:])
+	}
+	runtime.KeepAlive(b)
+	return indexRecord
+}
+
+func (b *BinaryIndex) Search(key string) int {
+	i := sort.Search(b.count, func(i int) bool {
+		return string(b.keyBytes(i)) >= key
+	})
+	runtime.KeepAlive(b)
+	return i
+}
+
+func (b *BinaryIndex) Get(key string) (IndexRecord, bool) {
+	i := b.Search(key)
+	found := i < b.count && string(b.keyBytes(i)) == key
+	runtime.KeepAlive(b)
+	if !found {
+		return IndexRecord{}, false
+	}
+	return b.At(i), true
+}
+
+// Close leaves a memory mapped index mapped, as commands still holding a
+// replaced store may look keys up after it's closed, and unmapping it would
+// crash them. It's unmapped once it's garbage collected.
+func (b *BinaryIndex) Close() error {
+	return nil
+}
+
+// unmap is the finalizer of a memory mapped index
+func (b *BinaryIndex) unmap() {
+	// there's nobody to report a failure to, the mapping is leaked then
+	b.release(b.data)
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryIndex(t *testing.T) {
+	records := MockRecords()
+	index, err := BuildIndex(bytes.NewReader(MockJsonlBytes(records)))
+	assert.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	assert.NoError(t, index.WriteBinary(&buf))
+
+	binaryIndex, err := NewBinaryIndex(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, index.Len(), binaryIndex.Len())
+
+	for i := 0; i < index.Len(); i++ {
+		assert.Equal(t, index.KeyAt(i), binaryIndex.KeyAt(i))
+		assert.Equal(t, index.At(i), binaryIndex.At(i))
+
+		indexRecord, ok := binaryIndex.Get(index.KeyAt(i))
+		assert.True(t, ok)
+		assert.Equal(t, index.At(i), indexRecord)
+	}
+
+	_, ok := binaryIndex.Get("nosuchkey")
+	assert.False(t, ok)
+	_, ok = binaryIndex.Get("")
+	assert.False(t, ok)
+	assert.Equal(t, index.Search("key5"), binaryIndex.Search("key5"))
+}
+
+func TestBinaryIndexCorrupted(t *testing.T) {
+	index, err := BuildIndex(bytes.NewReader(MockJsonlBytes(MockRecords())))
+	assert.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	assert.NoError(t, index.WriteBinary(&buf))
+
+	_, err = NewBinaryIndex(buf.Bytes()[:buf.Len()-1])
+	assert.Error(t, err)
+
+	_, err = NewBinaryIndex([]byte(`{"key":"key0:hash"}`))
+	assert.Equal(t, ErrNotBinaryIndex, err)
+}
+
+func TestStoreWithMappedBinaryIndex(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+	index, err := BuildIndex(bytes.NewReader(recordsBytes))
+	assert.NoError(t, err)
+
+	indexFileName := filepath.Join(t.TempDir(), "index.bin")
+	indexFile, err := os.Create(indexFileName)
+	assert.NoError(t, err)
+	assert.NoError(t, index.WriteBinary(indexFile))
+	assert.NoError(t, indexFile.Close())
+
+	indexFile, err = os.Open(indexFileName)
+	assert.NoError(t, err)
+	store, err := NewStoreFromRecordsWithIndex(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes)), nil
+	}, indexFile)
+	assert.NoError(t, err)
+	// the mapping outlives the file
+	assert.NoError(t, indexFile.Close())
+
+	_, isBinary := store.StoreIndex.(*BinaryIndex)
+	assert.True(t, isBinary)
+	assert.Equal(t, len(records), store.GetLen())
+	for _, record := range records {
+		rec, err := store.GetRecord(record.Key)
+		assert.NoError(t, err)
+		assert.JSONEq(t, record.String(), rec.String())
+	}
+	assert.Equal(t, []string{"key1:hash", "key1:list", "key1:set", "key1:string", "key1:zset"}, store.Keys("key1:*"))
+
+	// commands still holding a closed store can look keys up, only reading
+	// records fails
+	assert.NoError(t, store.Close())
+	assert.Equal(t, []string{"key1:hash", "key1:list", "key1:set", "key1:string", "key1:zset"}, store.Keys("key1:*"))
+	_, err = store.GetRecordIndex("key1:hash")
+	assert.NoError(t, err)
+	_, err = store.GetRecord("key1:hash")
+	assert.Error(t, err)
+}
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+)
+
+// A bloom filter file is a sidecar of an index, answering lookups of most
+// missing keys without searching the index. Like the binary index it's a
+// flat, little endian layout that is memory mapped on load:
+//
+//	header  magic, version, hash functions count, bits count, keys count,
+//	        false positive rate, and the records fingerprint of the index
+//	bits    the filter bits
+//
+// A filter is only used with the index it was built from, which is told
+// by the keys count and the records fingerprint. A filter of an index
+// without a header can't be told apart from one of another index with as
+// many keys, it's never used.
+var bloomFilterMagic = []byte("ROSTBLM\x00")
+
+const (
+	bloomFilterVersion    = 1
+	bloomFilterHeaderSize = 96
+)
+
+// bloom filter header field offsets
+const (
+	bloomHashes            = 12
+	bloomBits              = 16
+	bloomKeys              = 24
+	bloomFalsePositiveRate = 32
+	bloomFlags             = 40
+	bloomRecordsSize       = 48
+	bloomModTime           = 56
+	bloomHash              = 64
+)
+
+var ErrNotBloomFilter = errors.New("not a bloom filter")
+
+// BloomFilter is a set of keys with false positives but no false negatives
+type BloomFilter struct {
+	bits              []byte
+	bitCount          uint64
+	hashes            uint32
+	keys              uint64
+	falsePositiveRate float64
+	// header is the header of the index the filter was built from
+	header  *IndexHeader
+	data    []byte
+	release func([]byte) error
+}
+
+// NewBloomFilter returns an empty filter sized for keys keys at
+// falsePositiveRate, which must be between 0 and 1
+func NewBloomFilter(keys int, falsePositiveRate float64) (*BloomFilter, error) {
+	if !(falsePositiveRate > 0 && falsePositiveRate < 1) {
+		return nil, fmt.Errorf("bloom filter false positive rate %v isn't between 0 and 1", falsePositiveRate)
+	}
+	n := math.Max(float64(keys), 1)
+	bitCount := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if bitCount < 64 {
+		bitCount = 64
+	}
+	hashes := uint32(math.Round(float64(bitCount) / n * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{
+		bits:              make([]byte, (bitCount+7)/8),
+		bitCount:          bitCount,
+		hashes:            hashes,
+		falsePositiveRate: falsePositiveRate,
+	}, nil
+}
+
+// BuildBloomFilter builds the filter of all keys of an index
+func BuildBloomFilter(index Index, falsePositiveRate float64) (*BloomFilter, error) {
+	filter, err := NewBloomFilter(index.Len(), falsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < index.Len(); i++ {
+		filter.Add(index.KeyAt(i))
+	}
+	if headerIndex, ok := index.(headerIndex); ok {
+		filter.header = headerIndex.GetHeader()
+	}
+	return filter, nil
+}
+
+// keyHashes returns the two hashes of a key the filter positions are
+// derived from, FNV-1a and a mix of it
+func keyHashes(key string) (uint64, uint64) {
+	h1 := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h1 ^= uint64(key[i])
+		h1 *= 1099511628211
+	}
+	h2 := h1
+	h2 ^= h2 >> 33
+	h2 *= 0xff51afd7ed558ccd
+	h2 ^= h2 >> 33
+	h2 *= 0xc4ceb9fe1a85ec53
+	h2 ^= h2 >> 33
+	return h1, h2 | 1
+}
+
+// Add adds a key to the filter
+func (b *BloomFilter) Add(key string) {
+	h1, h2 := keyHashes(key)
+	for i := uint64(0); i < uint64(b.hashes); i++ {
+		bit := (h1 + i*h2) % b.bitCount
+		b.bits[bit/8] |= 1 << (bit % 8)
+	}
+	b.keys++
+	runtime.KeepAlive(b)
+}
+
+// MayContain is false if the key was never added to the filter. A nil
+// filter may contain any key.
+func (b *BloomFilter) MayContain(key string) bool {
+	if b == nil {
+		return true
+	}
+	h1, h2 := keyHashes(key)
+	contains := true
+	for i := uint64(0); i < uint64(b.hashes) && contains; i++ {
+		bit := (h1 + i*h2) % b.bitCount
+		contains = b.bits[bit/8]&(1<<(bit%8)) != 0
+	}
+	runtime.KeepAlive(b)
+	return contains
+}
+
+// FalsePositiveRate is the rate the filter was sized for
+func (b *BloomFilter) FalsePositiveRate() float64 {
+	return b.falsePositiveRate
+}
+
+// matches tells whether the filter was built from index, with a false
+// positive rate of at most falsePositiveRate
+func (b *BloomFilter) matches(index Index, falsePositiveRate float64) bool {
+	if b.keys != uint64(index.Len()) || b.falsePositiveRate > falsePositiveRate {
+		return false
+	}
+	var header *IndexHeader
+	if headerIndex, ok := index.(headerIndex); ok {
+		header = headerIndex.GetHeader()
+	}
+	// without headers a stale filter of as many keys would be taken
+	return header != nil && b.header != nil && *header == *b.header
+}
+
+// WriteBinary serializes the filter in the bloom filter format
+func (b *BloomFilter) WriteBinary(out io.Writer) error {
+	header := make([]byte, bloomFilterHeaderSize)
+	copy(header, bloomFilterMagic)
+	binary.LittleEndian.PutUint32(header[headerVersion:], bloomFilterVersion)
+	binary.LittleEndian.PutUint32(header[bloomHashes:], b.hashes)
+	binary.LittleEndian.PutUint64(header[bloomBits:], b.bitCount)
+	binary.LittleEndian.PutUint64(header[bloomKeys:], b.keys)
+	binary.LittleEndian.PutUint64(header[bloomFalsePositiveRate:], math.Float64bits(b.falsePositiveRate))
+	if b.header != nil {
+		hash, err := hex.DecodeString(b.header.Records.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("can't write records hash %q to a bloom filter", b.header.Records.Hash)
+		}
+		binary.LittleEndian.PutUint32(header[bloomFlags:], flagHasHeader)
+		binary.LittleEndian.PutUint64(header[bloomRecordsSize:], uint64(b.header.Records.Size))
+		binary.LittleEndian.PutUint64(header[bloomModTime:], uint64(b.header.Records.ModTime))
+		copy(header[bloomHash:], hash)
+	}
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+	_, err := out.Write(b.bits)
+	runtime.KeepAlive(b)
+	return err
+}
+
+// NewBloomFilterFromBytes serves a filter from data in the bloom filter
+// format
+func NewBloomFilterFromBytes(data []byte) (*BloomFilter, error) {
+	if len(data) < bloomFilterHeaderSize || !bytes.Equal(data[:len(bloomFilterMagic)], bloomFilterMagic) {
+		return nil, ErrNotBloomFilter
+	}
+	if version := binary.LittleEndian.Uint32(data[headerVersion:]); version != bloomFilterVersion {
+		return nil, fmt.Errorf("unsupported bloom filter version %d", version)
+	}
+	filter := &BloomFilter{
+		bits:              data[bloomFilterHeaderSize:],
+		bitCount:          binary.LittleEndian.Uint64(data[bloomBits:]),
+		hashes:            binary.LittleEndian.Uint32(data[bloomHashes:]),
+		keys:              binary.LittleEndian.Uint64(data[bloomKeys:]),
+		falsePositiveRate: math.Float64frombits(binary.LittleEndian.Uint64(data[bloomFalsePositiveRate:])),
+		data:              data,
+	}
+	if filter.bitCount == 0 || filter.hashes == 0 || uint64(len(filter.bits)) != (filter.bitCount+7)/8 {
+		return nil, fmt.Errorf("bloom filter is truncated or corrupted")
+	}
+	if binary.LittleEndian.Uint32(data[bloomFlags:])&flagHasHeader != 0 {
+		filter.header = &IndexHeader{Records: RecordsFingerprint{
+			Size:    int64(binary.LittleEndian.Uint64(data[bloomRecordsSize:])),
+			ModTime: int64(binary.LittleEndian.Uint64(data[bloomModTime:])),
+			Hash:    hex.EncodeToString(data[bloomHash : bloomHash+sha256.Size]),
+		}}
+	}
+	return filter, nil
+}
+
+// ReadBloomFilter reads a filter, memory mapping it when in is an *os.File.
+// The file can be closed once the filter is read.
+func ReadBloomFilter(in io.Reader) (*BloomFilter, error) {
+	file, ok := in.(*os.File)
+	if !ok {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return nil, err
+		}
+		return NewBloomFilterFromBytes(data)
+	}
+
+	data, release, err := mapFile(file)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := NewBloomFilterFromBytes(data)
+	if err != nil {
+		release(data)
+		return nil, err
+	}
+	filter.release = release
+	// methods reading mapped bits call runtime.KeepAlive once they're done
+	// with them, like the ones of BinaryIndex
+	runtime.SetFinalizer(filter, (*BloomFilter).unmap)
+	return filter, nil
+}
+
+// Close leaves a memory mapped filter mapped, like BinaryIndex.Close, it's
+// unmapped once it's garbage collected
+func (b *BloomFilter) Close() error {
+	return nil
+}
+
+// unmap is the finalizer of a memory mapped filter
+func (b *BloomFilter) unmap() {
+	// there's nobody to report a failure to, the mapping is leaked then
+	b.release(b.data)
+}
+
+// loadBloomFilter returns the filter a store serves index with: filter if
+// it was built from index, precisely enough for config, otherwise one built
+// from index if config.BuildBloomFilter is set, or none. It's nil when
+// config disables bloom filters.
+func loadBloomFilter(filter *BloomFilter, index Index, config Config) (*BloomFilter, error) {
+	if config.BloomFalsePositiveRate <= 0 {
+		filter.Close()
+		return nil, nil
+	}
+	if filter != nil && filter.matches(index, config.BloomFalsePositiveRate) {
+		return filter, nil
+	}
+	filter.Close()
+	if !config.BuildBloomFilter {
+		return nil, nil
+	}
+	return BuildBloomFilter(index, config.BloomFalsePositiveRate)
+}
@@ -0,0 +1,190 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	filter, err := NewBloomFilter(10000, 0.01)
+	assert.NoError(t, err)
+	for i := 0; i < 10000; i++ {
+		filter.Add(fmt.Sprintf("key:%d", i))
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		assert.True(t, filter.MayContain(fmt.Sprintf("key:%d", i)))
+		if filter.MayContain(fmt.Sprintf("missing:%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, 200)
+
+	var filterBytes bytes.Buffer
+	assert.NoError(t, filter.WriteBinary(&filterBytes))
+	read, err := NewBloomFilterFromBytes(filterBytes.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, 0.01, read.FalsePositiveRate())
+	for i := 0; i < 10000; i++ {
+		assert.Equal(t, filter.MayContain(fmt.Sprintf("missing:%d", i)), read.MayContain(fmt.Sprintf("missing:%d", i)))
+	}
+
+	_, err = NewBloomFilterFromBytes(filterBytes.Bytes()[:filterBytes.Len()-1])
+	assert.Error(t, err)
+	_, err = NewBloomFilterFromBytes([]byte("not a bloom filter, but long enough to hold a header of one ......................................"))
+	assert.Equal(t, ErrNotBloomFilter, err)
+
+	var nilFilter *BloomFilter
+	assert.True(t, nilFilter.MayContain("key"))
+
+	for _, falsePositiveRate := range []float64{0, -0.1, 1, 2, math.NaN()} {
+		_, err := NewBloomFilter(10000, falsePositiveRate)
+		assert.Error(t, err, falsePositiveRate)
+	}
+}
+
+// countingIndex counts the lookups of an index
+type countingIndex struct {
+	Index
+	gets int
+}
+
+func (c *countingIndex) Get(key string) (IndexRecord, bool) {
+	c.gets++
+	return c.Index.Get(key)
+}
+
+// writeBloomFilter writes the bloom filter file of an index
+func writeBloomFilter(t *testing.T, index Index, falsePositiveRate float64) string {
+	fileName := filepath.Join(t.TempDir(), "index.bloom")
+	var filterBytes bytes.Buffer
+	filter, err := BuildBloomFilter(index, falsePositiveRate)
+	assert.NoError(t, err)
+	assert.NoError(t, filter.WriteBinary(&filterBytes))
+	assert.NoError(t, os.WriteFile(fileName, filterBytes.Bytes(), 0644))
+	return fileName
+}
+
+func TestStoreBloomFilter(t *testing.T) {
+	records := MockRecords()
+	recordsFileName := writeRecordsFile(t, MockJsonlBytes(records))
+	index := indexWithHeader(t, recordsFileName)
+	filterFileName := writeBloomFilter(t, index, 0.01)
+
+	open := func(filterFileName string, falsePositiveRate float64, build bool) *Store {
+		filterFile, err := os.Open(filterFileName)
+		assert.NoError(t, err)
+		defer filterFile.Close()
+		config := DefaultConfig()
+		config.BloomFalsePositiveRate = falsePositiveRate
+		config.BuildBloomFilter = build
+		store, err := NewStoreFromRecordsWithIndexAndBloomFilter(openFile(recordsFileName), jsonlIndex(t, index), filterFile, config)
+		assert.NoError(t, err)
+		return store
+	}
+
+	// the filter file is used, and missing keys don't reach the index
+	store := open(filterFileName, 0.01, false)
+	assert.NotNil(t, store.bloom.release)
+	counting := &countingIndex{Index: store.StoreIndex}
+	store.StoreIndex = counting
+	for i := 0; i < 1000; i++ {
+		_, err := store.GetRecordIndex(fmt.Sprintf("missing:%d", i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	assert.Less(t, counting.gets, 50)
+	for _, record := range records {
+		_, err := store.GetRecord(record.Key)
+		assert.NoError(t, err)
+	}
+	report, err := store.Validate()
+	assert.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.NoError(t, store.Close())
+
+	// a filter less precise than the config, or of another index, isn't
+	// used, and is rebuilt from the index only when asked to
+	store = open(filterFileName, 0.001, false)
+	assert.Nil(t, store.bloom)
+	assert.NoError(t, store.Close())
+	store = open(filterFileName, 0.001, true)
+	assert.Nil(t, store.bloom.release)
+	assert.Equal(t, 0.001, store.bloom.FalsePositiveRate())
+	assert.NoError(t, store.Close())
+
+	otherIndex, err := BuildIndex(bytes.NewReader(MockJsonlBytes(records[1:])))
+	assert.NoError(t, err)
+	otherFilterFileName := writeBloomFilter(t, otherIndex, 0.01)
+	store = open(otherFilterFileName, 0.01, false)
+	assert.Nil(t, store.bloom)
+	assert.NoError(t, store.Close())
+	store = open(otherFilterFileName, 0.01, true)
+	assert.Nil(t, store.bloom.release)
+	for _, record := range records {
+		_, err := store.GetRecordIndex(record.Key)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, store.Close())
+
+	// disabled
+	store = open(filterFileName, 0, true)
+	assert.Nil(t, store.bloom)
+	assert.NoError(t, store.Close())
+}
+
+func TestStoreBloomFilterWithoutIndex(t *testing.T) {
+	config := DefaultConfig()
+	config.BloomFalsePositiveRate = 0.01
+	store, err := NewStoreFromRecordsWithConfig(openBytes(MockJsonlBytes(MockRecords())), config)
+	assert.NoError(t, err)
+	assert.Nil(t, store.bloom)
+
+	config.BuildBloomFilter = true
+	store, err = NewStoreFromRecordsWithConfig(openBytes(MockJsonlBytes(MockRecords())), config)
+	assert.NoError(t, err)
+	assert.NotNil(t, store.bloom)
+	for _, record := range MockRecords() {
+		recordType, err := store.GetType(record.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, record.Type, recordType)
+	}
+	_, err = store.GetType("nosuchkey")
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestBloomFilterWithoutHeader(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+	index, err := BuildIndex(bytes.NewReader(recordsBytes))
+	assert.NoError(t, err)
+
+	// a stale filter of as many keys, next to an index without a header,
+	// would answer that existing keys are missing
+	stale := make([]Record, len(records))
+	for i := range records {
+		stale[i] = stringRecord(fmt.Sprintf("stale:%d", i), "value")
+	}
+	staleIndex, err := BuildIndex(bytes.NewReader(MockJsonlBytes(stale)))
+	assert.NoError(t, err)
+	filterFile, err := os.Open(writeBloomFilter(t, staleIndex, 0.01))
+	assert.NoError(t, err)
+	defer filterFile.Close()
+
+	config := DefaultConfig()
+	config.BloomFalsePositiveRate = 0.01
+	config.BuildBloomFilter = true
+	store, err := NewStoreFromRecordsWithIndexAndBloomFilter(openBytes(recordsBytes), jsonlIndex(t, index), filterFile, config)
+	assert.NoError(t, err)
+	assert.Nil(t, store.bloom.release)
+	for _, record := range records {
+		_, err := store.GetRecord(record.Key)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, store.Close())
+}
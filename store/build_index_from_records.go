@@ -4,35 +4,62 @@ import (
 	"bufio"
 	"encoding/json"
 	"io"
-	"sort"
 )
 
-// This is an extremely simple implementation that assumes that newline
-// is only one symbol long
+// BuildIndex indexes a records file, plain or block compressed (which is
+// detected from the first bytes of the file). Lines can be of any length,
+// and end with "\n" or "\r\n". Blank lines are skipped.
 func BuildIndex(in io.Reader) (store *StoreIndex, err error) {
-	scanner := bufio.NewScanner(in)
-	store = &StoreIndex{}
-	store.Index = make(map[string]IndexRecord)
-	offset := int64(0)
-	for scanner.Scan() {
-		var record Record
-		bts := scanner.Bytes()
-		err = json.Unmarshal(bts, &record)
+	reader := bufio.NewReader(in)
+	head, _ := reader.Peek(len(zstdMagic))
+	if compression := detectCompression(head); compression != NoCompression {
+		return buildCompressedIndex(reader, compression)
+	}
+
+	lines := newLineReader(reader)
+	store = newStoreIndex()
+	for {
+		bts, offset, err := lines.next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return store, err
 		}
-		indexRecord := IndexRecord{
-			Key:    record.Key,
-			Offset: offset,
-			Len:    len(bts),
-			Type:   record.Type,
+		if len(bts) == 0 {
+			continue
 		}
-		offset += int64(len(bts)) + 1 // +1 is for newline
-		store.Index[record.Key] = indexRecord
-		store.SortedKeys = append(store.SortedKeys, record.Key)
+		indexRecord, err := indexRecordFromLine(bts, offset)
+		if err != nil {
+			return store, lines.wrap(err, offset)
+		}
+		store.add(indexRecord)
 	}
 
-	sort.Strings(store.SortedKeys)
+	store.sortKeys()
 
-	return store, err
+	return store, nil
+}
+
+// indexRecordFromLine decodes a records line found at offset
+func indexRecordFromLine(bts []byte, offset int64) (indexRecord IndexRecord, err error) {
+	var record Record
+	err = json.Unmarshal(bts, &record)
+	if err != nil {
+		return indexRecord, err
+	}
+	indexRecord = IndexRecord{
+		Key:    record.Key,
+		Offset: offset,
+		Len:    len(bts),
+		Type:   record.Type,
+		CRC:    recordCRC(bts),
+	}
+	if record.Type == StringType {
+		if valueOffset, valueLen, ok := stringValueSpan(bts); ok {
+			indexRecord.ValueOffset = valueOffset
+			indexRecord.ValueLen = valueLen
+		}
+	}
+	return indexRecord, nil
 }
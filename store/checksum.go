@@ -0,0 +1,128 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// An index can be linked to the records file it was built from with an
+// IndexHeader, which is verified when a store is loaded, and index records
+// can carry the CRC of their record line, which is verified on every read.
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// recordCRC is the CRC of a record line, as stored in IndexRecord.CRC
+func recordCRC(line []byte) uint32 {
+	return crc32.Checksum(line, crcTable)
+}
+
+// ClearCRC drops the CRCs of index records, so they aren't written with
+// the index
+func (s *StoreIndex) ClearCRC() {
+	for key, indexRecord := range s.Index {
+		indexRecord.CRC = 0
+		s.Index[key] = indexRecord
+	}
+}
+
+var ErrRecordChecksum = errors.New("record checksum mismatch, the records file doesn't match its index")
+
+// RecordsFingerprint identifies a records file
+type RecordsFingerprint struct {
+	Size int64 `json:"size"`
+	// ModTime is in unix nanoseconds
+	ModTime int64 `json:"mtime"`
+	// Hash is the hex encoded sha256 of the file
+	Hash string `json:"hash"`
+}
+
+// IndexHeader is what an index file knows about the records file it was
+// built from
+type IndexHeader struct {
+	Records RecordsFingerprint `json:"records"`
+}
+
+// FingerprintRecords reads a whole records file to fingerprint it
+func FingerprintRecords(file *os.File) (fingerprint RecordsFingerprint, err error) {
+	stats, err := file.Stat()
+	if err != nil {
+		return fingerprint, err
+	}
+	hash, size, err := hashRecords(file)
+	if err != nil {
+		return fingerprint, err
+	}
+	return RecordsFingerprint{Size: size, ModTime: stats.ModTime().UnixNano(), Hash: hash}, nil
+}
+
+func hashRecords(in io.Reader) (hash string, size int64, err error) {
+	hasher := sha256.New()
+	size, err = io.Copy(hasher, in)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// ErrIndexMismatch is returned when loading a store whose index was built
+// from another records file
+type ErrIndexMismatch struct {
+	Reason string
+}
+
+func (e *ErrIndexMismatch) Error() string {
+	return fmt.Sprintf("index doesn't match the records file: %s", e.Reason)
+}
+
+// headerIndex is an Index read from a file, which may have a header
+type headerIndex interface {
+	GetHeader() *IndexHeader
+}
+
+// verifyRecords checks that records are the file described by header.
+// Size is always checked, and the hash too unless mtime matches and
+// alwaysHash isn't set. Remote records are only hashed when alwaysHash is
+// set, hashing them would download them on every load. Records must be at
+// their start.
+func verifyRecords(records io.ReadSeeker, header *IndexHeader, alwaysHash bool) error {
+	expected := header.Records
+	size, err := records.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if size != expected.Size {
+		return &ErrIndexMismatch{fmt.Sprintf("records file is %d bytes, index was built from %d bytes", size, expected.Size)}
+	}
+
+	// reads of remote records fail once they change on the server, so
+	// their size is checked against the records that are served
+	if _, ok := records.(*httpReader); ok && !alwaysHash {
+		return nil
+	}
+	if file, ok := records.(interface{ Stat() (os.FileInfo, error) }); ok && !alwaysHash {
+		stats, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if stats.ModTime().UnixNano() == expected.ModTime {
+			return nil
+		}
+	}
+
+	if _, err := records.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash, _, err := hashRecords(records)
+	if err != nil {
+		return err
+	}
+	if hash != expected.Hash {
+		return &ErrIndexMismatch{fmt.Sprintf("records hash is %s, index was built from %s", hash, expected.Hash)}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// indexWithHeader builds the index of records, with a header fingerprinting
+// them as a file named recordsFileName
+func indexWithHeader(t *testing.T, recordsFileName string) *StoreIndex {
+	recordsFile, err := os.Open(recordsFileName)
+	assert.NoError(t, err)
+	defer recordsFile.Close()
+
+	index, err := BuildIndex(recordsFile)
+	assert.NoError(t, err)
+	_, err = recordsFile.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	fingerprint, err := FingerprintRecords(recordsFile)
+	assert.NoError(t, err)
+	index.Header = &IndexHeader{Records: fingerprint}
+	return index
+}
+
+func writeRecordsFile(t *testing.T, recordsBytes []byte) string {
+	recordsFileName := filepath.Join(t.TempDir(), "records.jsonl")
+	assert.NoError(t, os.WriteFile(recordsFileName, recordsBytes, 0644))
+	return recordsFileName
+}
+
+func openFile(fileName string) OpenReaderSeekCloser {
+	return func() (io.ReadSeekCloser, error) {
+		return os.Open(fileName)
+	}
+}
+
+func TestIndexHeader(t *testing.T) {
+	recordsFileName := writeRecordsFile(t, MockJsonlBytes(MockRecords()))
+	index := indexWithHeader(t, recordsFileName)
+	assert.Equal(t, int64(len(MockJsonlBytes(MockRecords()))), index.Header.Records.Size)
+
+	var jsonl bytes.Buffer
+	assert.NoError(t, index.WriteJsonl(&jsonl))
+	jsonlIndex, err := ReadJsonlIndex(&jsonl, true)
+	assert.NoError(t, err)
+	assert.Equal(t, index, jsonlIndex)
+
+	var binaryBytes bytes.Buffer
+	assert.NoError(t, index.WriteBinary(&binaryBytes))
+	binaryIndex, err := NewBinaryIndex(binaryBytes.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, index.Header, binaryIndex.GetHeader())
+	for i := 0; i < index.Len(); i++ {
+		assert.NotZero(t, binaryIndex.At(i).CRC)
+		assert.Equal(t, index.At(i), binaryIndex.At(i))
+	}
+}
+
+// binaryIndexV1 rewrites a binary index in the version 1 layout
+func binaryIndexV1(data []byte) []byte {
+	count := int(binary.LittleEndian.Uint64(data[headerCount:]))
+	v1 := append([]byte(nil), data[:binaryIndexV1HeaderSize]...)
+	binary.LittleEndian.PutUint32(v1[headerVersion:], 1)
+	binary.LittleEndian.PutUint32(v1[headerFlags:], 0)
+	binary.LittleEndian.PutUint64(v1[headerKeysOffset:], uint64(binaryIndexV1HeaderSize+binaryIndexV1EntrySize*count))
+	for i := 0; i < count; i++ {
+		start := binaryIndexHeaderSize + i*binaryIndexEntrySize
+		v1 = append(v1, data[start:start+binaryIndexV1EntrySize]...)
+	}
+	return append(v1, data[binaryIndexHeaderSize+count*binaryIndexEntrySize:]...)
+}
+
+func TestBinaryIndexV1(t *testing.T) {
+	recordsFileName := writeRecordsFile(t, MockJsonlBytes(MockRecords()))
+	index := indexWithHeader(t, recordsFileName)
+	var binaryBytes bytes.Buffer
+	assert.NoError(t, index.WriteBinary(&binaryBytes))
+
+	binaryIndex, err := NewBinaryIndex(binaryIndexV1(binaryBytes.Bytes()))
+	assert.NoError(t, err)
+	assert.Nil(t, binaryIndex.GetHeader())
+	assert.Equal(t, index.Len(), binaryIndex.Len())
+	for i := 0; i < index.Len(); i++ {
+		expected := index.At(i)
+		expected.CRC = 0
+		assert.Equal(t, expected, binaryIndex.At(i))
+	}
+
+	// an index without a header serves any records
+	store, err := NewStoreFromRecordsWithIndex(openBytes([]byte{}), bytes.NewReader(binaryIndexV1(binaryBytes.Bytes())))
+	assert.NoError(t, err)
+	assert.Equal(t, index.Len(), store.GetLen())
+}
+
+func TestIndexMismatch(t *testing.T) {
+	records := MockRecords()
+	recordsFileName := writeRecordsFile(t, MockJsonlBytes(records))
+	index := indexWithHeader(t, recordsFileName)
+
+	changed := append(records, stringRecord("added", "value"))
+	changedFileName := writeRecordsFile(t, MockJsonlBytes(changed))
+
+	_, err := NewStoreFromRecordsWithIndex(openFile(changedFileName), jsonlIndex(t, index))
+	var mismatch *ErrIndexMismatch
+	assert.True(t, errors.As(err, &mismatch))
+
+	config := DefaultConfig()
+	config.RebuildMismatchedIndex = true
+	store, err := NewStoreFromRecordsWithIndexAndConfig(openFile(changedFileName), jsonlIndex(t, index), config)
+	assert.NoError(t, err)
+	assert.Equal(t, len(changed), store.GetLen())
+	record, err := store.GetRecord("added")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", record.StringRecord.Value)
+
+	store, err = NewStoreFromRecordsWithIndex(openFile(recordsFileName), jsonlIndex(t, index))
+	assert.NoError(t, err)
+	assert.Equal(t, len(records), store.GetLen())
+}
+
+func TestRecordChecksum(t *testing.T) {
+	recordsBytes := MockJsonlBytes(MockRecords())
+	recordsFileName := writeRecordsFile(t, recordsBytes)
+	index := indexWithHeader(t, recordsFileName)
+	stats, err := os.Stat(recordsFileName)
+	assert.NoError(t, err)
+
+	// same size and modification time, but another value
+	changed := bytes.Replace(recordsBytes, []byte(`"element3:1"`), []byte(`"element3:X"`), 1)
+	changed = bytes.Replace(changed, []byte(`"key3:string","type":"string","string_record":{"value":"value1"}`), []byte(`"key3:string","type":"string","string_record":{"value":"valueX"}`), 1)
+	assert.NoError(t, os.WriteFile(recordsFileName, changed, 0644))
+	assert.NoError(t, os.Chtimes(recordsFileName, stats.ModTime(), stats.ModTime()))
+
+	store, err := NewStoreFromRecordsWithIndex(openFile(recordsFileName), jsonlIndex(t, index))
+	assert.NoError(t, err)
+	_, err = store.GetRecord("key3:list")
+	assert.True(t, errors.Is(err.(ErrReadingRecordFromDisk).Err, ErrRecordChecksum))
+	_, err = store.GetRecord("key3:hash")
+	assert.NoError(t, err)
+	// a slice of a string value is checked against the whole record
+	_, err = store.GetStringSlice("key3:string", 1, 4)
+	assert.True(t, errors.Is(err.(ErrReadingRecordFromDisk).Err, ErrRecordChecksum))
+	value, err := store.GetStringSlice("key2:string", 1, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "alu", value)
+
+	// hashing the records catches it on load
+	config := DefaultConfig()
+	config.VerifyRecordsHash = true
+	_, err = NewStoreFromRecordsWithIndexAndConfig(openFile(recordsFileName), jsonlIndex(t, index), config)
+	var mismatch *ErrIndexMismatch
+	assert.True(t, errors.As(err, &mismatch))
+}
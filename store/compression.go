@@ -0,0 +1,434 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed records files are a concatenation of independently compressed
+// blocks (gzip members or zstd frames), each holding whole jsonl lines.
+// Such a file is still a valid .gz or .zst file, while a single record
+// can be read by decompressing only the block it's in. Index records of a
+// compressed file point at a block with BlockOffset and BlockLen, and their
+// Offset is relative to the decompressed block.
+type Compression string
+
+const (
+	NoCompression   Compression = ""
+	GzipCompression Compression = "gzip"
+	ZstdCompression Compression = "zstd"
+)
+
+// DefaultBlockSize is the uncompressed size a block is filled up to.
+// Bigger blocks compress better, smaller ones are faster to read from.
+const DefaultBlockSize = 64 * 1024
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+var ErrUnknownCompression = errors.New("unknown compression")
+
+func detectCompression(head []byte) Compression {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return GzipCompression
+	case bytes.HasPrefix(head, zstdMagic):
+		return ZstdCompression
+	}
+	return NoCompression
+}
+
+// sniffCompression detects compression from the first bytes of a records
+// file, and rewinds it
+func sniffCompression(in io.ReadSeeker) (Compression, error) {
+	head := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(in, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return NoCompression, err
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return NoCompression, err
+	}
+	return detectCompression(head[:n]), nil
+}
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+)
+
+// sharedZstdDecoder returns a decoder for DecodeAll, which is safe
+// for concurrent use
+func sharedZstdDecoder() (*zstd.Decoder, error) {
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdDecoder, zstdDecoderErr
+}
+
+func decompressBlock(compression Compression, block []byte) ([]byte, error) {
+	switch compression {
+	case GzipCompression:
+		reader, err := gzip.NewReader(bytes.NewReader(block))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	case ZstdCompression:
+		decoder, err := sharedZstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return decoder.DecodeAll(block, nil)
+	}
+	return nil, ErrUnknownCompression
+}
+
+func compressBlock(compression Compression, block []byte) ([]byte, error) {
+	var out bytes.Buffer
+	var writer io.WriteCloser
+	var err error
+	switch compression {
+	case GzipCompression:
+		writer = gzip.NewWriter(&out)
+	case ZstdCompression:
+		writer, err = zstd.NewWriter(&out)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnknownCompression
+	}
+
+	if _, err := writer.Write(block); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// blockReader walks the blocks of a compressed records file
+type blockReader interface {
+	// next returns the decompressed contents of the next block, its
+	// compressed length, and the length of whatever was skipped before it.
+	// It returns io.EOF after the last block.
+	next() (skipped int, blockLen int, block []byte, err error)
+}
+
+func newBlockReader(compression Compression, in *bufio.Reader) (blockReader, error) {
+	switch compression {
+	case GzipCompression:
+		return &gzipBlockReader{in: &countingByteReader{reader: in}}, nil
+	case ZstdCompression:
+		return &zstdBlockReader{in: in}, nil
+	}
+	return nil, ErrUnknownCompression
+}
+
+// countingByteReader counts consumed bytes. Being an io.ByteReader it stops
+// gzip from reading ahead, so the count ends exactly at the member end.
+type countingByteReader struct {
+	reader *bufio.Reader
+	count  int64
+}
+
+func (c *countingByteReader) Read(p []byte) (n int, err error) {
+	n, err = c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+func (c *countingByteReader) ReadByte() (b byte, err error) {
+	b, err = c.reader.ReadByte()
+	if err == nil {
+		c.count++
+	}
+	return b, err
+}
+
+type gzipBlockReader struct {
+	in     *countingByteReader
+	reader *gzip.Reader
+}
+
+func (g *gzipBlockReader) next() (skipped int, blockLen int, block []byte, err error) {
+	start := g.in.count
+	if g.reader == nil {
+		g.reader, err = gzip.NewReader(g.in)
+	} else {
+		err = g.reader.Reset(g.in)
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	g.reader.Multistream(false)
+
+	block, err = io.ReadAll(g.reader)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return 0, int(g.in.count - start), block, nil
+}
+
+type zstdBlockReader struct {
+	in *bufio.Reader
+}
+
+func (z *zstdBlockReader) next() (skipped int, blockLen int, block []byte, err error) {
+	for {
+		frame, skippable, err := readZstdFrame(z.in)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if skippable {
+			skipped += len(frame)
+			continue
+		}
+		block, err = decompressBlock(ZstdCompression, frame)
+		return skipped, len(frame), block, err
+	}
+}
+
+// readZstdFrame reads one whole zstd frame, walking its block headers
+// without decompressing anything
+func readZstdFrame(in *bufio.Reader) (frame []byte, skippable bool, err error) {
+	var buf bytes.Buffer
+	read := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(in, b); err != nil {
+			if err == io.EOF && buf.Len() > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		buf.Write(b)
+		return b, nil
+	}
+
+	magic, err := read(4)
+	if err != nil {
+		return nil, false, err
+	}
+	if !bytes.Equal(magic, zstdMagic) {
+		if binary.LittleEndian.Uint32(magic)&0xfffffff0 != 0x184d2a50 {
+			return nil, false, fmt.Errorf("bad zstd frame magic %x", magic)
+		}
+		size, err := read(4)
+		if err != nil {
+			return nil, false, err
+		}
+		if _, err := read(int(binary.LittleEndian.Uint32(size))); err != nil {
+			return nil, false, err
+		}
+		return buf.Bytes(), true, nil
+	}
+
+	descriptor, err := read(1)
+	if err != nil {
+		return nil, false, err
+	}
+	contentSizeFlag := descriptor[0] >> 6
+	singleSegment := descriptor[0]>>5&1 == 1
+	hasChecksum := descriptor[0]>>2&1 == 1
+	dictionaryIDFlag := descriptor[0] & 3
+
+	headerLen := []int{0, 1, 2, 4}[dictionaryIDFlag]
+	if !singleSegment {
+		headerLen++ // window descriptor
+	}
+	switch contentSizeFlag {
+	case 0:
+		if singleSegment {
+			headerLen++
+		}
+	case 1:
+		headerLen += 2
+	case 2:
+		headerLen += 4
+	case 3:
+		headerLen += 8
+	}
+	if _, err := read(headerLen); err != nil {
+		return nil, false, err
+	}
+
+	for {
+		header, err := read(3)
+		if err != nil {
+			return nil, false, err
+		}
+		blockHeader := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+		lastBlock := blockHeader&1 == 1
+		blockType := blockHeader >> 1 & 3
+		blockSize := int(blockHeader >> 3)
+		switch blockType {
+		case 1: // RLE block, a single byte repeated blockSize times
+			blockSize = 1
+		case 3:
+			return nil, false, fmt.Errorf("reserved zstd block type")
+		}
+		if _, err := read(blockSize); err != nil {
+			return nil, false, err
+		}
+		if lastBlock {
+			break
+		}
+	}
+
+	if hasChecksum {
+		if _, err := read(4); err != nil {
+			return nil, false, err
+		}
+	}
+	return buf.Bytes(), false, nil
+}
+
+// readBlockRecord decompresses the block an index record points at,
+// and returns the record line from it
+func readBlockRecord(reader io.ReaderAt, compression Compression, indexRecord IndexRecord) ([]byte, error) {
+	compressed := make([]byte, indexRecord.BlockLen)
+	if bytesRead, err := reader.ReadAt(compressed, indexRecord.BlockOffset); bytesRead != len(compressed) {
+		return nil, ErrReadingRecordFromDisk{err}
+	}
+
+	block, err := decompressBlock(compression, compressed)
+	if err != nil {
+		return nil, ErrReadingRecordFromDisk{err}
+	}
+
+	end := indexRecord.Offset + int64(indexRecord.Len)
+	if indexRecord.Offset < 0 || end > int64(len(block)) {
+		return nil, ErrReadingRecordFromDisk{errors.New("record is out of its block")}
+	}
+	return block[indexRecord.Offset:end], nil
+}
+
+// WriteCompressedRecords compresses a plain jsonl records file into blocks
+// of about blockSize uncompressed bytes, and returns the index of the
+// compressed file
+func WriteCompressedRecords(in io.Reader, out io.Writer, compression Compression, blockSize int) (store *StoreIndex, err error) {
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+
+	store = newStoreIndex()
+	lines := newLineReader(in)
+	var block bytes.Buffer
+	var blockRecords []IndexRecord
+	blockOffset := int64(0)
+
+	flush := func() error {
+		if block.Len() == 0 {
+			return nil
+		}
+		compressed, err := compressBlock(compression, block.Bytes())
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(compressed); err != nil {
+			return err
+		}
+		for _, indexRecord := range blockRecords {
+			indexRecord.BlockOffset = blockOffset
+			indexRecord.BlockLen = len(compressed)
+			store.add(indexRecord)
+		}
+		blockOffset += int64(len(compressed))
+		block.Reset()
+		blockRecords = blockRecords[:0]
+		return nil
+	}
+
+	for {
+		line, offset, err := lines.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return store, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		indexRecord, err := indexRecordFromLine(line, int64(block.Len()))
+		if err != nil {
+			return store, lines.wrap(err, offset)
+		}
+		blockRecords = append(blockRecords, indexRecord)
+		block.Write(line)
+		block.WriteByte('\n')
+		if block.Len() >= blockSize {
+			if err := flush(); err != nil {
+				return store, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return store, err
+	}
+
+	store.sortKeys()
+	return store, nil
+}
+
+// buildCompressedIndex indexes a block compressed records file
+func buildCompressedIndex(in *bufio.Reader, compression Compression) (store *StoreIndex, err error) {
+	blocks, err := newBlockReader(compression, in)
+	if err != nil {
+		return nil, err
+	}
+
+	store = newStoreIndex()
+	blockOffset := int64(0)
+	for {
+		skipped, blockLen, block, err := blocks.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return store, err
+		}
+		blockOffset += int64(skipped)
+
+		offset := int64(0)
+		for len(block) > 0 {
+			line := block
+			if newline := bytes.IndexByte(block, '\n'); newline >= 0 {
+				line = block[:newline]
+			}
+			// files compressed by other tools may have "\r\n" line ends
+			if record := bytes.TrimSuffix(line, []byte("\r")); len(record) > 0 {
+				indexRecord, err := indexRecordFromLine(record, offset)
+				if err != nil {
+					return store, err
+				}
+				indexRecord.BlockOffset = blockOffset
+				indexRecord.BlockLen = blockLen
+				store.add(indexRecord)
+			}
+			offset += int64(len(line)) + 1
+			if len(line) >= len(block) {
+				break
+			}
+			block = block[len(line)+1:]
+		}
+		blockOffset += int64(blockLen)
+	}
+
+	store.sortKeys()
+	return store, nil
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressedRecords(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+
+	for _, compression := range []Compression{GzipCompression, ZstdCompression} {
+		t.Run(string(compression), func(t *testing.T) {
+			compressed := bytes.Buffer{}
+			// small blocks, so records end up in many of them
+			index, err := WriteCompressedRecords(bytes.NewReader(recordsBytes), &compressed, compression, 300)
+			assert.NoError(t, err)
+			assert.Len(t, index.SortedKeys, len(records))
+
+			// an index built from the compressed file is the same
+			rebuilt, err := BuildIndex(bytes.NewReader(compressed.Bytes()))
+			assert.NoError(t, err)
+			assert.Equal(t, index, rebuilt)
+
+			indexBuf := bytes.Buffer{}
+			assert.NoError(t, index.WriteJsonl(&indexBuf))
+
+			store, err := NewStoreFromRecordsWithIndex(func() (io.ReadSeekCloser, error) {
+				return NewReadSeekCloser(bytes.NewReader(compressed.Bytes())), nil
+			}, &indexBuf)
+			assert.NoError(t, err)
+
+			for _, record := range records {
+				rec, err := store.GetRecord(record.Key)
+				assert.NoError(t, err)
+				assert.JSONEq(t, record.String(), rec.String())
+			}
+
+			value, err := store.GetStringSlice("key3:string", 1, 4)
+			assert.NoError(t, err)
+			assert.Equal(t, "alu", value)
+		})
+	}
+}
+
+func TestCompressedRecordsAreStandardFiles(t *testing.T) {
+	recordsBytes := MockJsonlBytes(MockRecords())
+
+	compressed := bytes.Buffer{}
+	_, err := WriteCompressedRecords(bytes.NewReader(recordsBytes), &compressed, GzipCompression, 100)
+	assert.NoError(t, err)
+	gzipReader, err := gzip.NewReader(&compressed)
+	assert.NoError(t, err)
+	decompressed, err := io.ReadAll(gzipReader)
+	assert.NoError(t, err)
+	assert.Equal(t, recordsBytes, decompressed)
+
+	compressed.Reset()
+	_, err = WriteCompressedRecords(bytes.NewReader(recordsBytes), &compressed, ZstdCompression, 100)
+	assert.NoError(t, err)
+	zstdReader, err := zstd.NewReader(&compressed)
+	assert.NoError(t, err)
+	decompressed, err = io.ReadAll(zstdReader)
+	assert.NoError(t, err)
+	assert.Equal(t, recordsBytes, decompressed)
+}
+
+func TestStoreFromCompressedRecordsWithoutIndex(t *testing.T) {
+	records := MockRecords()
+	compressed := bytes.Buffer{}
+	_, err := WriteCompressedRecords(bytes.NewReader(MockJsonlBytes(records)), &compressed, ZstdCompression, 0)
+	assert.NoError(t, err)
+
+	store, err := NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(compressed.Bytes())), nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(records), store.GetLen())
+
+	rec, err := store.GetRecord(records[5].Key)
+	assert.NoError(t, err)
+	assert.JSONEq(t, records[5].String(), rec.String())
+}
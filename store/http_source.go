@@ -0,0 +1,421 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHTTPBlockSize is the size of the blocks records are fetched in
+	DefaultHTTPBlockSize = 64 << 10
+	// DefaultHTTPCacheSize is the memory fetched blocks of a records file
+	// can take
+	DefaultHTTPCacheSize = 64 << 20
+	// DefaultHTTPTimeout is how long a request of the default client can
+	// take, its body included
+	DefaultHTTPTimeout = time.Minute
+)
+
+// defaultHTTPClient is the client of sources and fetches without one
+var defaultHTTPClient = &http.Client{Timeout: DefaultHTTPTimeout}
+
+// IsURL tells whether a records or index file name is an http(s) URL
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// HTTPSourceConfig configures an HTTPSource
+type HTTPSourceConfig struct {
+	// Client fetches records, a client with DefaultHTTPTimeout if it's nil.
+	// Reads are abandoned with their context too.
+	Client *http.Client
+	// BlockSize is the size of the blocks records are fetched in, a read
+	// fetches all the blocks it needs that aren't cached in one request
+	BlockSize int64
+	// CacheSize is the memory cached blocks can take, the least recently
+	// used ones are evicted first. 0 disables the cache.
+	CacheSize int64
+}
+
+// DefaultHTTPSourceConfig returns the config of an HTTPSource with
+// default block and cache sizes
+func DefaultHTTPSourceConfig() HTTPSourceConfig {
+	return HTTPSourceConfig{BlockSize: DefaultHTTPBlockSize, CacheSize: DefaultHTTPCacheSize}
+}
+
+// HTTPSource is a records file served over HTTP, read with Range requests
+// and cached in blocks. Readers of the source share its cache, so a
+// store has one source per records file, opening readers with Open.
+// Records that change on the server while they are served fail reads,
+// when the server sends an ETag or a Last-Modified date.
+type HTTPSource struct {
+	url       string
+	client    *http.Client
+	blockSize int64
+	size      int64
+	etag      string
+	// lastModified is only used when there's no ETag
+	lastModified string
+	cache        *blockCache
+}
+
+// NewHTTPSource asks the server for the size of the records at url,
+// nothing else is fetched before it's read
+func NewHTTPSource(url string, config HTTPSourceConfig) (*HTTPSource, error) {
+	if config.Client == nil {
+		config.Client = defaultHTTPClient
+	}
+	if config.BlockSize <= 0 {
+		config.BlockSize = DefaultHTTPBlockSize
+	}
+
+	response, err := config.Client.Head(url)
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+	if err := httpStatusError(url, response); err != nil {
+		return nil, err
+	}
+	if response.ContentLength < 0 {
+		return nil, fmt.Errorf("%s: the server doesn't tell the size of records", url)
+	}
+
+	return &HTTPSource{
+		url:          url,
+		client:       config.Client,
+		blockSize:    config.BlockSize,
+		size:         response.ContentLength,
+		etag:         response.Header.Get("ETag"),
+		lastModified: response.Header.Get("Last-Modified"),
+		cache:        newBlockCache(config.CacheSize),
+	}, nil
+}
+
+// Size returns the size of the records
+func (s *HTTPSource) Size() int64 {
+	return s.size
+}
+
+// Open opens a reader of the source, it can be passed as the
+// OpenReaderSeekCloser of a store
+func (s *HTTPSource) Open() (io.ReadSeekCloser, error) {
+	return &httpReader{source: s}, nil
+}
+
+// ReadAt reads records at offset, from cached blocks or from the server
+func (s *HTTPSource) ReadAt(p []byte, offset int64) (n int, err error) {
+	return s.readAtContext(context.Background(), p, offset)
+}
+
+// readAtContext is ReadAt, abandoning requests to the server once ctx is
+// done
+func (s *HTTPSource) readAtContext(ctx context.Context, p []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	if offset >= s.size {
+		return 0, io.EOF
+	}
+	end := offset + int64(len(p))
+	if end > s.size {
+		end = s.size
+	}
+
+	first, last := offset/s.blockSize, (end-1)/s.blockSize
+	blocks := make([][]byte, last-first+1)
+	missing := []int64{}
+	for i := first; i <= last; i++ {
+		block, ok := s.cache.get(i)
+		if !ok {
+			missing = append(missing, i)
+		}
+		blocks[i-first] = block
+	}
+	if len(missing) > 0 {
+		// blocks between the first and the last missing ones are fetched
+		// in the same request, even if some of them are cached
+		fetched, err := s.fetchBlocks(ctx, missing[0], missing[len(missing)-1])
+		if err != nil {
+			return 0, err
+		}
+		for i, block := range fetched {
+			blocks[missing[0]-first+int64(i)] = block
+		}
+	}
+
+	for i, block := range blocks {
+		start := (first + int64(i)) * s.blockSize
+		from := offset + int64(n) - start
+		n += copy(p[n:end-offset], block[from:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetchBlocks fetches blocks first to last with a Range request, and caches
+// them
+func (s *HTTPSource) fetchBlocks(ctx context.Context, first int64, last int64) ([][]byte, error) {
+	start := first * s.blockSize
+	end := (last+1)*s.blockSize - 1
+	if end >= s.size {
+		end = s.size - 1
+	}
+	data, err := s.fetchRange(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := [][]byte{}
+	for i := first; i <= last; i++ {
+		from := (i - first) * s.blockSize
+		to := from + s.blockSize
+		if to > int64(len(data)) {
+			to = int64(len(data))
+		}
+		// blocks are capped, so that appending to one doesn't overwrite
+		// the next
+		block := data[from:to:to]
+		s.cache.put(i, block)
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// fetchRange fetches bytes start to end of the records, end included
+func (s *HTTPSource) fetchRange(ctx context.Context, start int64, end int64) ([]byte, error) {
+	response, err := s.getRange(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(response.Body, data); err != nil {
+		return nil, fmt.Errorf("%s: error reading bytes %d-%d %w", s.url, start, end, err)
+	}
+	return data, nil
+}
+
+// getRange requests bytes start to end of the records, end included. The
+// body of the response must be closed.
+func (s *HTTPSource) getRange(ctx context.Context, start int64, end int64) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if s.etag != "" {
+		request.Header.Set("If-Match", s.etag)
+	} else if s.lastModified != "" {
+		request.Header.Set("If-Unmodified-Since", s.lastModified)
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		// a different range would be cached as the blocks requested
+		err = checkContentRange(response.Header.Get("Content-Range"), start, end)
+		if err == nil {
+			return response, nil
+		}
+		err = fmt.Errorf("%s: %w", s.url, err)
+	case http.StatusPreconditionFailed:
+		err = fmt.Errorf("%s: records changed on the server since they were loaded", s.url)
+	case http.StatusOK:
+		err = fmt.Errorf("%s: the server doesn't support range requests", s.url)
+	default:
+		err = httpStatusError(s.url, response)
+	}
+	response.Body.Close()
+	return nil, err
+}
+
+// checkContentRange checks that the Content-Range of a partial response is
+// bytes start to end
+func checkContentRange(contentRange string, start int64, end int64) error {
+	var sentStart, sentEnd int64
+	var size string
+	if _, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &sentStart, &sentEnd, &size); err != nil || sentStart != start || sentEnd != end {
+		return fmt.Errorf("the server sent range %q for bytes %d-%d", contentRange, start, end)
+	}
+	return nil
+}
+
+// httpReader is a reader of an HTTPSource. It's an io.ReaderAt, so stores
+// read it concurrently in ReadModePread.
+type httpReader struct {
+	source *HTTPSource
+	offset int64
+	// ctx abandons requests to the server, it's nil for readers that
+	// aren't bound to a context
+	ctx context.Context
+}
+
+// withContext returns a reader of the source whose requests are abandoned
+// once ctx is done, stores read with it for the context of the read
+func (r *httpReader) withContext(ctx context.Context) io.ReaderAt {
+	return &httpReader{source: r.source, offset: r.offset, ctx: ctx}
+}
+
+func (r *httpReader) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+func (r *httpReader) Read(p []byte) (n int, err error) {
+	n, err = r.source.readAtContext(r.context(), p, r.offset)
+	r.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *httpReader) ReadAt(p []byte, offset int64) (n int, err error) {
+	return r.source.readAtContext(r.context(), p, offset)
+}
+
+func (r *httpReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.source.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// WriteTo streams the records from the offset of the reader to their end
+// in one request, without caching them. io.Copy uses it, so hashing
+// remote records with Config.VerifyRecordsHash doesn't fetch them block
+// by block.
+func (r *httpReader) WriteTo(w io.Writer) (n int64, err error) {
+	if r.offset >= r.source.size {
+		return 0, nil
+	}
+	response, err := r.source.getRange(r.context(), r.offset, r.source.size-1)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	n, err = io.Copy(w, response.Body)
+	r.offset += n
+	return n, err
+}
+
+// Close does nothing, cached blocks belong to the source
+func (r *httpReader) Close() error {
+	return nil
+}
+
+// FetchURL fetches a whole file, an error wrapping os.ErrNotExist is
+// returned if there's no such file on the server. A nil client is one with
+// DefaultHTTPTimeout.
+func FetchURL(client *http.Client, url string) ([]byte, error) {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	response, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if err := httpStatusError(url, response); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(response.Body)
+}
+
+// httpStatusError returns an error for responses that aren't a success
+func httpStatusError(url string, response *http.Response) error {
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s: %w", url, os.ErrNotExist)
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return errors.New(url + ": " + response.Status)
+	}
+	return nil
+}
+
+// blockCache is a LRU cache of fetched blocks, bounded by their size
+type blockCache struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[int64]*list.Element
+	// lru is ordered from the most to the least recently used
+	lru *list.List
+}
+
+type cachedBlock struct {
+	index int64
+	data  []byte
+}
+
+// newBlockCache returns a cache of up to maxBytes, or nil for a cache
+// that's disabled when maxBytes is 0
+func newBlockCache(maxBytes int64) *blockCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &blockCache{
+		maxBytes: maxBytes,
+		entries:  map[int64]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+func (c *blockCache) get(index int64) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[index]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*cachedBlock).data, true
+}
+
+func (c *blockCache) put(index int64, data []byte) {
+	if c == nil || int64(len(data)) > c.maxBytes {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[index]; ok {
+		return
+	}
+	c.entries[index] = c.lru.PushFront(&cachedBlock{index: index, data: data})
+	c.bytes += int64(len(data))
+	for c.bytes > c.maxBytes {
+		oldest := c.lru.Back()
+		block := oldest.Value.(*cachedBlock)
+		c.lru.Remove(oldest)
+		delete(c.entries, block.index)
+		c.bytes -= int64(len(block.data))
+	}
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveRecords serves a records file with an ETag, counting GET requests
+func serveRecords(t *testing.T, recordsFileName string, etag *atomic.Value) (server *httptest.Server, gets *int64) {
+	gets = new(int64)
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt64(gets, 1)
+		}
+		w.Header().Set("ETag", etag.Load().(string))
+		http.ServeFile(w, r, recordsFileName)
+	}))
+	t.Cleanup(server.Close)
+	return server, gets
+}
+
+func TestHTTPSourceReadAt(t *testing.T) {
+	recordsBytes := MockJsonlBytes(MockRecords())
+	etag := &atomic.Value{}
+	etag.Store(`"v1"`)
+	server, _ := serveRecords(t, writeRecordsFile(t, recordsBytes), etag)
+
+	source, err := NewHTTPSource(server.URL, HTTPSourceConfig{BlockSize: 100, CacheSize: 1 << 20})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(recordsBytes)), source.Size())
+
+	for _, length := range []int{1, 99, 100, 101, 350} {
+		for offset := 0; offset < len(recordsBytes); offset += 37 {
+			p := make([]byte, length)
+			n, err := source.ReadAt(p, int64(offset))
+			end := offset + length
+			if end > len(recordsBytes) {
+				end = len(recordsBytes)
+				assert.Equal(t, io.EOF, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, recordsBytes[offset:end], p[:n])
+		}
+	}
+}
+
+func TestHTTPSourceStore(t *testing.T) {
+	records := MockRecords()
+	recordsFileName := writeRecordsFile(t, MockJsonlBytes(records))
+	etag := &atomic.Value{}
+	etag.Store(`"v1"`)
+	server, gets := serveRecords(t, recordsFileName, etag)
+
+	source, err := NewHTTPSource(server.URL, HTTPSourceConfig{BlockSize: 256, CacheSize: 1 << 20})
+	assert.NoError(t, err)
+	config := DefaultConfig()
+	config.VerifyRecordsHash = true
+	config.KeysDontNeedSorting = true
+	store, err := NewStoreFromRecordsWithIndexAndConfig(source.Open, jsonlIndex(t, indexWithHeader(t, recordsFileName)), config)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	for _, expected := range records {
+		record, err := store.GetRecord(expected.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.Key, record.Key)
+		assert.Equal(t, expected.Type, record.Type)
+	}
+
+	// records are read from cached blocks the second time
+	fetched := atomic.LoadInt64(gets)
+	for _, expected := range records {
+		_, err := store.GetRecord(expected.Key)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, fetched, atomic.LoadInt64(gets))
+}
+
+func TestHTTPSourceLoad(t *testing.T) {
+	records := MockRecords()
+	var compressed bytes.Buffer
+	_, err := WriteCompressedRecords(bytes.NewReader(MockJsonlBytes(records)), &compressed, ZstdCompression, 256)
+	assert.NoError(t, err)
+	recordsFileName := writeRecordsFile(t, compressed.Bytes())
+	etag := &atomic.Value{}
+	etag.Store(`"v1"`)
+	server, gets := serveRecords(t, recordsFileName, etag)
+
+	// loading checks the size of the records, without fetching them
+	source, err := NewHTTPSource(server.URL, DefaultHTTPSourceConfig())
+	assert.NoError(t, err)
+	config := DefaultConfig()
+	config.KeysDontNeedSorting = true
+	store, err := NewStoreFromRecordsWithIndexAndConfig(source.Open, jsonlIndex(t, indexWithHeader(t, recordsFileName)), config)
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.Equal(t, int64(0), atomic.LoadInt64(gets))
+
+	// compression is detected on the first read
+	record, err := store.GetRecord(records[0].Key)
+	assert.NoError(t, err)
+	assert.JSONEq(t, records[0].String(), record.String())
+
+	index := indexWithHeader(t, recordsFileName)
+	index.Header.Records.Size++
+	_, err = NewStoreFromRecordsWithIndexAndConfig(source.Open, jsonlIndex(t, index), config)
+	var mismatch *ErrIndexMismatch
+	assert.ErrorAs(t, err, &mismatch)
+}
+
+func TestHTTPSourceReadContext(t *testing.T) {
+	records := MockRecords()
+	recordsFileName := writeRecordsFile(t, MockJsonlBytes(records))
+	// GET requests stall until they are canceled
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			<-r.Context().Done()
+			return
+		}
+		http.ServeFile(w, r, recordsFileName)
+	}))
+	defer server.Close()
+
+	source, err := NewHTTPSource(server.URL, DefaultHTTPSourceConfig())
+	assert.NoError(t, err)
+	config := DefaultConfig()
+	config.MaxConnections = 1
+	store, err := NewStoreFromRecordsWithIndexAndConfig(source.Open, jsonlIndex(t, indexWithHeader(t, recordsFileName)), config)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// the request of an abandoned read is canceled, which frees its reader
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = store.GetRecordContext(ctx, records[0].Key)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Eventually(t, func() bool {
+		return store.ReaderPoolStats().InUse == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestHTTPSourceContentRange(t *testing.T) {
+	recordsBytes := MockJsonlBytes(MockRecords())
+	// the server always answers with the first bytes
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(recordsBytes)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-99/%d", len(recordsBytes)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(recordsBytes[:100])
+	}))
+	defer server.Close()
+
+	source, err := NewHTTPSource(server.URL, HTTPSourceConfig{BlockSize: 100})
+	assert.NoError(t, err)
+	_, err = source.ReadAt(make([]byte, 10), 0)
+	assert.NoError(t, err)
+	_, err = source.ReadAt(make([]byte, 10), 100)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bytes 0-99")
+}
+
+func TestHTTPSourceChanged(t *testing.T) {
+	recordsFileName := writeRecordsFile(t, MockJsonlBytes(MockRecords()))
+	etag := &atomic.Value{}
+	etag.Store(`"v1"`)
+	server, _ := serveRecords(t, recordsFileName, etag)
+
+	source, err := NewHTTPSource(server.URL, HTTPSourceConfig{BlockSize: 256})
+	assert.NoError(t, err)
+	_, err = source.ReadAt(make([]byte, 10), 0)
+	assert.NoError(t, err)
+
+	etag.Store(`"v2"`)
+	_, err = source.ReadAt(make([]byte, 10), 0)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "records changed on the server")
+}
+
+func TestFetchURL(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir(t.TempDir())))
+	defer server.Close()
+
+	_, err := FetchURL(nil, server.URL+"/missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// Layer is a Backend that exposes its index, so it can be merged with
+// others in a LayeredStore
+type Layer interface {
+	Backend
+	GetIndex() Index
+}
+
+func (s *Store) GetIndex() Index {
+	return s.StoreIndex
+}
+
+func (m *MemoryStore) GetIndex() Index {
+	return m.index
+}
+
+// LayeredStore serves a base layer with delta layers over it. A key is
+// resolved from the newest layer down, and a tombstone record in a
+// layer deletes the key from the layers under it.
+type LayeredStore struct {
+	// layers are ordered from the base to the newest delta
+	layers []Layer
+	merged *mergedIndex
+}
+
+// NewLayeredStore merges layers, given from the base to the newest delta.
+// The layered store owns them, and closes them on Close.
+func NewLayeredStore(layers ...Layer) (*LayeredStore, error) {
+	if len(layers) == 0 {
+		return nil, errors.New("a layered store needs at least one layer")
+	}
+	return &LayeredStore{
+		layers: layers,
+		merged: newMergedIndex(layers),
+	}, nil
+}
+
+// resolve finds the layer serving key, ok is false if the key is
+// missing or deleted
+func (l *LayeredStore) resolve(key string) (layer Layer, indexRecord IndexRecord, ok bool) {
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		indexRecord, ok := l.layers[i].GetIndex().Get(key)
+		if !ok {
+			continue
+		}
+		if indexRecord.Type == TombstoneType {
+			return nil, IndexRecord{}, false
+		}
+		return l.layers[i], indexRecord, true
+	}
+	return nil, IndexRecord{}, false
+}
+
+func (l *LayeredStore) GetLen() int {
+	return l.merged.Len()
+}
+
+func (l *LayeredStore) GetRecordIndex(key string) (*IndexRecord, error) {
+	_, indexRecord, ok := l.resolve(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &indexRecord, nil
+}
+
+func (l *LayeredStore) GetType(key string) (string, error) {
+	_, indexRecord, ok := l.resolve(key)
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return indexRecord.Type, nil
+}
+
+func (l *LayeredStore) GetRecord(key string) (*Record, error) {
+	return l.GetRecordContext(context.Background(), key)
+}
+
+func (l *LayeredStore) GetRecordContext(ctx context.Context, key string) (*Record, error) {
+	layer, _, ok := l.resolve(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return layer.GetRecordContext(ctx, key)
+}
+
+func (l *LayeredStore) GetRecords(keys []string) ([]*Record, error) {
+	return l.GetRecordsContext(context.Background(), keys)
+}
+
+func (l *LayeredStore) GetRecordsContext(ctx context.Context, keys []string) ([]*Record, error) {
+	records := make([]*Record, len(keys))
+	for i, key := range keys {
+		layer, _, ok := l.resolve(key)
+		if !ok {
+			continue
+		}
+		record, err := layer.GetRecordContext(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = record
+	}
+	return records, nil
+}
+
+// ScanFields implements SCAN over the union of the layers, the cursor is a
+// position in the merged key order
+func (l *LayeredStore) ScanFields(start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	return l.ScanFieldsContext(context.Background(), start, count, pattern)
+}
+
+func (l *LayeredStore) ScanFieldsContext(ctx context.Context, start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	return scanIndex(ctx, l.merged, start, count, pattern)
+}
+
+func (l *LayeredStore) Keys(pattern string) []string {
+	keys, _ := l.KeysContext(context.Background(), pattern)
+	return keys
+}
+
+func (l *LayeredStore) KeysContext(ctx context.Context, pattern string) ([]string, error) {
+	return indexKeys(ctx, l.merged, pattern)
+}
+
+func (l *LayeredStore) RandomKey() (string, error) {
+	return randomIndexKey(l.merged)
+}
+
+func (l *LayeredStore) GetStringLen(key string) (int, error) {
+	return l.GetStringLenContext(context.Background(), key)
+}
+
+func (l *LayeredStore) GetStringLenContext(ctx context.Context, key string) (int, error) {
+	layer, _, ok := l.resolve(key)
+	if !ok {
+		return 0, ErrKeyNotFound
+	}
+	return layer.GetStringLenContext(ctx, key)
+}
+
+func (l *LayeredStore) GetStringSlice(key string, from int, to int) (string, error) {
+	return l.GetStringSliceContext(context.Background(), key, from, to)
+}
+
+func (l *LayeredStore) GetStringSliceContext(ctx context.Context, key string, from int, to int) (string, error) {
+	layer, _, ok := l.resolve(key)
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return layer.GetStringSliceContext(ctx, key, from, to)
+}
+
+// CacheStats sums the cache counters of the layers
+func (l *LayeredStore) CacheStats() (stats CacheStats) {
+	for _, layer := range l.layers {
+		stats = stats.add(cacheStats(layer))
+	}
+	return stats
+}
+
+// ReaderPoolStats sums the reader pool counters of the layers
+func (l *LayeredStore) ReaderPoolStats() (stats PoolStats) {
+	for _, layer := range l.layers {
+		stats = stats.add(readerPoolStats(layer))
+	}
+	return stats
+}
+
+// Close closes all layers, and returns the first error
+func (l *LayeredStore) Close() (err error) {
+	for _, layer := range l.layers {
+		if closeErr := layer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// mergedIndex is an Index over the union of layer indexes, without
+// tombstones and shadowed keys. It only keeps a position per key, keys
+// themselves are read from the layer indexes.
+type mergedIndex struct {
+	layers  []Layer
+	entries []mergedEntry
+}
+
+type mergedEntry struct {
+	layer    int32
+	position int32
+}
+
+// newMergedIndex merges sorted layer indexes. When several layers hold a
+// key, the newest one wins.
+func newMergedIndex(layers []Layer) *mergedIndex {
+	merged := &mergedIndex{layers: layers}
+	positions := make([]int, len(layers))
+	heads := make([]string, len(layers))
+	for i, layer := range layers {
+		if layer.GetIndex().Len() > 0 {
+			heads[i] = layer.GetIndex().KeyAt(0)
+		}
+	}
+
+	for {
+		newest := -1
+		for i, layer := range layers {
+			if positions[i] >= layer.GetIndex().Len() {
+				continue
+			}
+			if newest < 0 || heads[i] <= heads[newest] {
+				newest = i
+			}
+		}
+		if newest < 0 {
+			break
+		}
+
+		key := heads[newest]
+		index := layers[newest].GetIndex()
+		if index.At(positions[newest]).Type != TombstoneType {
+			merged.entries = append(merged.entries, mergedEntry{int32(newest), int32(positions[newest])})
+		}
+
+		// move every layer holding key past it
+		for i, layer := range layers {
+			index := layer.GetIndex()
+			if positions[i] >= index.Len() || heads[i] != key {
+				continue
+			}
+			positions[i]++
+			if positions[i] < index.Len() {
+				heads[i] = index.KeyAt(positions[i])
+			}
+		}
+	}
+	return merged
+}
+
+func (m *mergedIndex) Len() int {
+	return len(m.entries)
+}
+
+func (m *mergedIndex) At(i int) IndexRecord {
+	entry := m.entries[i]
+	return m.layers[entry.layer].GetIndex().At(int(entry.position))
+}
+
+func (m *mergedIndex) KeyAt(i int) string {
+	entry := m.entries[i]
+	return m.layers[entry.layer].GetIndex().KeyAt(int(entry.position))
+}
+
+func (m *mergedIndex) Get(key string) (IndexRecord, bool) {
+	i := m.Search(key)
+	if i >= len(m.entries) || m.KeyAt(i) != key {
+		return IndexRecord{}, false
+	}
+	return m.At(i), true
+}
+
+func (m *mergedIndex) Search(key string) int {
+	return sort.Search(len(m.entries), func(i int) bool {
+		return m.KeyAt(i) >= key
+	})
+}
+
+// Close does nothing, layer indexes are closed with their layers
+func (m *mergedIndex) Close() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stringRecord(key string, value string) Record {
+	return Record{Key: key, Type: StringType, StringRecord: &StringRecord{Value: value}}
+}
+
+func tombstone(key string) Record {
+	return Record{Key: key, Type: TombstoneType}
+}
+
+func storeFromRecords(t *testing.T, records []Record) *Store {
+	recordsBytes := MockJsonlBytes(records)
+	store, err := NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes)), nil
+	})
+	assert.NoError(t, err)
+	return store
+}
+
+func TestLayeredStore(t *testing.T) {
+	base := storeFromRecords(t, []Record{
+		stringRecord("a", "base"),
+		stringRecord("b", "base"),
+		stringRecord("c", "base"),
+		stringRecord("e", "base"),
+	})
+	delta, err := NewMemoryStore([]Record{
+		stringRecord("b", "delta"),
+		tombstone("c"),
+		stringRecord("d", "delta"),
+		tombstone("nosuchkey"),
+	})
+	assert.NoError(t, err)
+	newest := storeFromRecords(t, []Record{
+		{Key: "a", Type: HashType, HashRecord: &HashRecord{Fields: map[string]string{"f": "v"}}},
+		stringRecord("c", "newest"),
+	})
+
+	layered, err := NewLayeredStore(base, delta, newest)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 5, layered.GetLen())
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, layered.Keys("*"))
+
+	recordType, err := layered.GetType("a")
+	assert.NoError(t, err)
+	assert.Equal(t, HashType, recordType)
+
+	for key, value := range map[string]string{"b": "delta", "c": "newest", "d": "delta", "e": "base"} {
+		record, err := layered.GetRecord(key)
+		assert.NoError(t, err)
+		assert.Equal(t, value, record.StringRecord.Value, key)
+	}
+
+	_, err = layered.GetRecord("nosuchkey")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	value, err := layered.GetStringSlice("b", 1, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "el", value)
+
+	records, err := layered.GetRecords([]string{"e", "nosuchkey", "d"})
+	assert.NoError(t, err)
+	assert.Equal(t, "base", records[0].StringRecord.Value)
+	assert.Nil(t, records[1])
+	assert.Equal(t, "delta", records[2].StringRecord.Value)
+}
+
+func TestLayeredStoreTombstones(t *testing.T) {
+	base := storeFromRecords(t, []Record{
+		stringRecord("a", "base"),
+		stringRecord("b", "base"),
+	})
+	delta := storeFromRecords(t, []Record{
+		tombstone("a"),
+	})
+
+	layered, err := NewLayeredStore(base, delta)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, layered.GetLen())
+	_, err = layered.GetRecord("a")
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = layered.GetRecordIndex("a")
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = layered.GetStringLen("a")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	key, err := layered.RandomKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", key)
+}
+
+func TestLayeredStoreScan(t *testing.T) {
+	base, err := NewMemoryStore(MockRecords())
+	assert.NoError(t, err)
+	delta, err := NewMemoryStore([]Record{
+		tombstone("key0:string"),
+		tombstone("key5:hash"),
+		stringRecord("key1:string", "updated"),
+		stringRecord("key10:string", "added"),
+	})
+	assert.NoError(t, err)
+
+	layered, err := NewLayeredStore(base, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, len(MockRecords())-1, layered.GetLen())
+
+	// a scan in small steps returns every key exactly once, in order
+	scanned := []string{}
+	cursor := 0
+	for {
+		records, next, err := layered.ScanFields(cursor, 3, "*")
+		assert.NoError(t, err)
+		for _, record := range records {
+			scanned = append(scanned, record.Key)
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, layered.Keys("*"), scanned)
+	assert.NotContains(t, scanned, "key0:string")
+	assert.NotContains(t, scanned, "key5:hash")
+	assert.Contains(t, scanned, "key10:string")
+}
+
+func TestTombstoneInBinaryIndex(t *testing.T) {
+	index, err := BuildIndex(bytes.NewReader(MockJsonlBytes([]Record{tombstone("a")})))
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, index.WriteBinary(&buf))
+	binaryIndex, err := NewBinaryIndex(buf.Bytes())
+	assert.NoError(t, err)
+
+	indexRecord, ok := binaryIndex.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, TombstoneType, indexRecord.Type)
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// ErrParsingLine is an error in a line of a records or index file
+type ErrParsingLine struct {
+	// Line is 1 based
+	Line int
+	// Offset is where the line starts in the file
+	Offset int64
+	Err    error
+}
+
+func (e *ErrParsingLine) Error() string {
+	return fmt.Sprintf("line %d at offset %d: %s", e.Line, e.Offset, e.Err)
+}
+
+func (e *ErrParsingLine) Unwrap() error {
+	return e.Err
+}
+
+// lineReader reads lines of any length, ended with "\n" or "\r\n", and
+// keeps track of where each of them starts
+type lineReader struct {
+	reader *bufio.Reader
+	// line and offset of the next line
+	line   int
+	offset int64
+}
+
+func newLineReader(in io.Reader) *lineReader {
+	reader, ok := in.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReader(in)
+	}
+	return &lineReader{reader: reader, line: 1}
+}
+
+// next returns the next line without its line end, and the offset it
+// starts at. The line is only valid until the next call. The last line
+// doesn't need a line end, io.EOF is returned after it.
+func (l *lineReader) next() (line []byte, offset int64, err error) {
+	line, err = l.reader.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// a line longer than the buffer, keep on reading it into a copy
+		long := append([]byte(nil), line...)
+		for err == bufio.ErrBufferFull {
+			line, err = l.reader.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		line = long
+	}
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, l.offset, err
+	}
+
+	offset = l.offset
+	l.offset += int64(len(line))
+	l.line++
+	return trimLineEnd(line), offset, nil
+}
+
+// wrap adds the position of the line last returned by next to err
+func (l *lineReader) wrap(err error, offset int64) error {
+	return &ErrParsingLine{Line: l.line - 1, Offset: offset, Err: err}
+}
+
+// trimLineEnd removes a trailing "\n" or "\r\n"
+func trimLineEnd(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+	}
+	return line
+}
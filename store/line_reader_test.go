@@ -0,0 +1,94 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineReader(t *testing.T) {
+	long := strings.Repeat("x", 200*1024)
+	lines := newLineReader(strings.NewReader("a\r\n" + long + "\n\nb"))
+
+	var got []string
+	var offsets []int64
+	for {
+		line, offset, err := lines.next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		got = append(got, string(line))
+		offsets = append(offsets, offset)
+	}
+	assert.Equal(t, []string{"a", long, "", "b"}, got)
+	assert.Equal(t, []int64{0, 3, int64(3 + len(long) + 1), int64(3 + len(long) + 2)}, offsets)
+}
+
+func TestBuildIndexLongLines(t *testing.T) {
+	records := []Record{
+		stringRecord("a", "short"),
+		stringRecord("b", strings.Repeat("long ", 50*1024)),
+		stringRecord("c", "short again"),
+	}
+	var recordsBytes bytes.Buffer
+	for _, record := range records {
+		recordsBytes.WriteString(record.String())
+		recordsBytes.WriteString("\n")
+	}
+
+	store, err := NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes.Bytes())), nil
+	})
+	assert.NoError(t, err)
+	for _, record := range records {
+		rec, err := store.GetRecord(record.Key)
+		assert.NoError(t, err)
+		assert.JSONEq(t, record.String(), rec.String())
+	}
+}
+
+func TestBuildIndexCRLF(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := bytes.ReplaceAll(MockJsonlBytes(records), []byte("\n"), []byte("\r\n"))
+
+	index, err := BuildIndex(bytes.NewReader(recordsBytes))
+	assert.NoError(t, err)
+	assert.Equal(t, len(records), index.Len())
+
+	store, err := NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes)), nil
+	})
+	assert.NoError(t, err)
+	for _, record := range records {
+		rec, err := store.GetRecord(record.Key)
+		assert.NoError(t, err)
+		assert.JSONEq(t, record.String(), rec.String())
+	}
+
+	var indexBytes bytes.Buffer
+	assert.NoError(t, index.WriteJsonl(&indexBytes))
+	index2, err := ReadJsonlIndex(bytes.NewReader(bytes.ReplaceAll(indexBytes.Bytes(), []byte("\n"), []byte("\r\n"))), false)
+	assert.NoError(t, err)
+	assert.Equal(t, index, index2)
+}
+
+func TestBuildIndexErrorPosition(t *testing.T) {
+	a, b := stringRecord("a", "1"), stringRecord("b", "2")
+	recordsBytes := []byte(a.String() + "\r\n" + b.String() + "\n{broken\n")
+
+	_, err := BuildIndex(bytes.NewReader(recordsBytes))
+	var lineErr *ErrParsingLine
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, 3, lineErr.Line)
+	assert.Equal(t, int64(bytes.Index(recordsBytes, []byte("{broken"))), lineErr.Offset)
+
+	_, err = ReadJsonlIndex(strings.NewReader("{\"key\":\"a\"}\nnot json\n"), false)
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, 2, lineErr.Line)
+	assert.Equal(t, int64(12), lineErr.Offset)
+}
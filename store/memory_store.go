@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// MemoryStore is a Backend over records held in memory, for tests and
+// datasets small enough not to need a records file. Records it returns
+// are shared, and must not be modified.
+type MemoryStore struct {
+	index   *StoreIndex
+	records map[string]*Record
+}
+
+// NewMemoryStore serves records, a later record replaces an earlier one
+// with the same key. Index records have no offsets, their Len is the
+// length of the record serialized to json.
+func NewMemoryStore(records []Record) (*MemoryStore, error) {
+	m := &MemoryStore{
+		index:   newStoreIndex(),
+		records: make(map[string]*Record, len(records)),
+	}
+	for i := range records {
+		record := records[i]
+		recordBytes, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		indexRecord := IndexRecord{Key: record.Key, Len: len(recordBytes), Type: record.Type}
+		if _, ok := m.records[record.Key]; ok {
+			m.index.Index[record.Key] = indexRecord
+		} else {
+			m.index.add(indexRecord)
+		}
+		m.records[record.Key] = &record
+	}
+	m.index.sortKeys()
+	return m, nil
+}
+
+func (m *MemoryStore) GetLen() int {
+	return m.index.Len()
+}
+
+func (m *MemoryStore) GetRecordIndex(key string) (*IndexRecord, error) {
+	indexRecord, ok := m.index.Get(key)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &indexRecord, nil
+}
+
+func (m *MemoryStore) GetType(key string) (string, error) {
+	return indexType(m.index, key)
+}
+
+func (m *MemoryStore) GetRecord(key string) (*Record, error) {
+	record, ok := m.records[key]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return record, nil
+}
+
+// GetRecordContext is GetRecord, records are in memory so ctx only
+// matters when it's already done
+func (m *MemoryStore) GetRecordContext(ctx context.Context, key string) (*Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.GetRecord(key)
+}
+
+func (m *MemoryStore) GetRecordsContext(ctx context.Context, keys []string) ([]*Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.GetRecords(keys)
+}
+
+func (m *MemoryStore) GetRecords(keys []string) ([]*Record, error) {
+	records := make([]*Record, len(keys))
+	for i, key := range keys {
+		records[i] = m.records[key]
+	}
+	return records, nil
+}
+
+func (m *MemoryStore) ScanFields(start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	return m.ScanFieldsContext(context.Background(), start, count, pattern)
+}
+
+func (m *MemoryStore) ScanFieldsContext(ctx context.Context, start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	return scanIndex(ctx, m.index, start, count, pattern)
+}
+
+func (m *MemoryStore) Keys(pattern string) []string {
+	keys, _ := m.KeysContext(context.Background(), pattern)
+	return keys
+}
+
+func (m *MemoryStore) KeysContext(ctx context.Context, pattern string) ([]string, error) {
+	return indexKeys(ctx, m.index, pattern)
+}
+
+func (m *MemoryStore) RandomKey() (string, error) {
+	return randomIndexKey(m.index)
+}
+
+func (m *MemoryStore) GetStringLenContext(ctx context.Context, key string) (int, error) {
+	return m.GetStringLen(key)
+}
+
+func (m *MemoryStore) GetStringLen(key string) (int, error) {
+	record, err := m.getString(key)
+	if err != nil || record.StringRecord == nil {
+		return 0, err
+	}
+	return len(record.StringRecord.Value), nil
+}
+
+func (m *MemoryStore) GetStringSliceContext(ctx context.Context, key string, from int, to int) (string, error) {
+	return m.GetStringSlice(key, from, to)
+}
+
+func (m *MemoryStore) GetStringSlice(key string, from int, to int) (string, error) {
+	record, err := m.getString(key)
+	if err != nil || record.StringRecord == nil {
+		return "", err
+	}
+	return sliceString(record.StringRecord.Value, from, to), nil
+}
+
+func (m *MemoryStore) getString(key string) (*Record, error) {
+	record, err := m.GetRecord(key)
+	if err != nil {
+		return nil, err
+	}
+	if record.Type != StringType {
+		return nil, ErrWrongType
+	}
+	return record, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
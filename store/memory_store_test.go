@@ -0,0 +1,68 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreMatchesStore(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+	store, err := NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes)), nil
+	})
+	assert.NoError(t, err)
+
+	memoryStore, err := NewMemoryStore(records)
+	assert.NoError(t, err)
+
+	assert.Equal(t, store.GetLen(), memoryStore.GetLen())
+	assert.Equal(t, store.Keys("key1*"), memoryStore.Keys("key1*"))
+
+	scanned, cursor, err := store.ScanFields(3, 5, "*")
+	assert.NoError(t, err)
+	memoryScanned, memoryCursor, err := memoryStore.ScanFields(3, 5, "*")
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, memoryCursor)
+	for i := range scanned {
+		assert.Equal(t, scanned[i].Key, memoryScanned[i].Key)
+		assert.Equal(t, scanned[i].Len, memoryScanned[i].Len)
+	}
+
+	for _, record := range records {
+		recordType, err := memoryStore.GetType(record.Key)
+		assert.NoError(t, err)
+		assert.Equal(t, record.Type, recordType)
+
+		got, err := memoryStore.GetRecord(record.Key)
+		assert.NoError(t, err)
+		assert.JSONEq(t, record.String(), got.String())
+	}
+
+	value, err := memoryStore.GetStringSlice("key0:string", 1, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "alu", value)
+	_, err = memoryStore.GetStringLen("key0:hash")
+	assert.Equal(t, ErrWrongType, err)
+
+	got, err := memoryStore.GetRecords([]string{"nosuchkey", "key0:string"})
+	assert.NoError(t, err)
+	assert.Nil(t, got[0])
+	assert.Equal(t, "value1", got[1].StringRecord.Value)
+}
+
+func TestMemoryStoreLaterRecordWins(t *testing.T) {
+	memoryStore, err := NewMemoryStore([]Record{
+		{Key: "a", Type: StringType, StringRecord: &StringRecord{Value: "first"}},
+		{Key: "a", Type: HashType, HashRecord: &HashRecord{Fields: map[string]string{"f": "v"}}},
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, memoryStore.GetLen())
+	recordType, err := memoryStore.GetType("a")
+	assert.NoError(t, err)
+	assert.Equal(t, HashType, recordType)
+}
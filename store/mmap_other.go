@@ -0,0 +1,21 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package store
+
+import (
+	"io"
+	"os"
+)
+
+// mapFile reads a whole file in memory, where memory mapping is not available
+func mapFile(file *os.File) (data []byte, release func([]byte) error, err error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	data, err = io.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func([]byte) error { return nil }, nil
+}
@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile memory maps a whole file read only
+func mapFile(file *os.File) (data []byte, release func([]byte) error, err error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if stat.Size() == 0 {
+		return []byte{}, func([]byte) error { return nil }, nil
+	}
+
+	data, err = syscall.Mmap(int(file.Fd()), 0, int(stat.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, syscall.Munmap, nil
+}
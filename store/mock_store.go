@@ -55,6 +55,17 @@ func MockRecords() (records []Record) {
 			},
 		})
 
+		records = append(records, Record{
+			Key:  fmt.Sprintf("key%d:set", i),
+			Type: SetType,
+			SetRecord: &SetRecord{
+				Members: []string{
+					fmt.Sprintf("member%d:1", i),
+					fmt.Sprintf("member%d:2", i),
+				},
+			},
+		})
+
 	}
 	return records
 }
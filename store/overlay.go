@@ -0,0 +1,707 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/match"
+)
+
+// Overlay is a writable Backend over a read only one, for staging and
+// debugging. Writes are kept in memory: a written key holds a copy of
+// its record, a deleted key a tombstone, and an expiring key its expiry
+// time. The base is never modified.
+type Overlay struct {
+	base  Backend
+	state *overlayState
+}
+
+type overlayState struct {
+	mutex   sync.Mutex
+	entries map[string]*overlayEntry
+	// added are keys missing from the base, in the order they were added,
+	// so that SCAN positions past the base don't move as keys are added
+	added    []string
+	addedSet map[string]bool
+}
+
+// overlayEntry either replaces a record, deletes it, or only sets an
+// expiry on the base record
+type overlayEntry struct {
+	record      *Record
+	indexRecord IndexRecord
+	deleted     bool
+	inBase      bool
+	expireAt    time.Time
+}
+
+func NewOverlay(base Backend) *Overlay {
+	return &Overlay{
+		base: base,
+		state: &overlayState{
+			entries:  map[string]*overlayEntry{},
+			addedSet: map[string]bool{},
+		},
+	}
+}
+
+// Rebase returns an overlay with a copy of the writes over another base.
+// The previous overlay keeps serving its own writes over its own base
+// while it's being replaced, writes it takes from then on aren't carried
+// over.
+func (o *Overlay) Rebase(base Backend) *Overlay {
+	o.state.mutex.Lock()
+	entries := make(map[string]*overlayEntry, len(o.state.entries))
+	for key, entry := range o.state.entries {
+		// records of entries are never modified, a write replaces them
+		copied := *entry
+		entries[key] = &copied
+	}
+	o.state.mutex.Unlock()
+
+	rebased := &Overlay{
+		base:  base,
+		state: &overlayState{entries: entries, addedSet: map[string]bool{}},
+	}
+	for _, key := range rebased.sortedEntryKeys() {
+		entry := entries[key]
+		entry.inBase = rebased.inBase(key)
+		// deletions and expiries of keys the base no longer has are moot
+		if !entry.inBase && entry.record == nil {
+			delete(entries, key)
+			continue
+		}
+		if !entry.inBase {
+			rebased.state.addAdded(key)
+		}
+	}
+	return rebased
+}
+
+func (s *overlayState) addAdded(key string) {
+	if !s.addedSet[key] {
+		s.addedSet[key] = true
+		s.added = append(s.added, key)
+	}
+}
+
+func (o *Overlay) sortedEntryKeys() []string {
+	keys := make([]string, 0, len(o.state.entries))
+	for key := range o.state.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// entry returns the entry of a key, turning an expired one into a deletion.
+// The state must be locked.
+func (o *Overlay) entry(key string) *overlayEntry {
+	entry, ok := o.state.entries[key]
+	if !ok {
+		return nil
+	}
+	if !entry.expireAt.IsZero() && !time.Now().Before(entry.expireAt) {
+		o.remove(key, entry.inBase)
+		return o.state.entries[key]
+	}
+	return entry
+}
+
+// remove deletes a key, a key of the base needs a tombstone
+func (o *Overlay) remove(key string, inBase bool) {
+	if inBase {
+		o.state.entries[key] = &overlayEntry{deleted: true, inBase: true}
+		return
+	}
+	delete(o.state.entries, key)
+}
+
+// purgeExpired removes expired keys. The state must be locked.
+func (o *Overlay) purgeExpired() {
+	for key := range o.state.entries {
+		o.entry(key)
+	}
+}
+
+// lookup returns the record of a key if the overlay holds it. When the
+// overlay doesn't, overridden is false and the key is read from the base.
+func (o *Overlay) lookup(key string) (record *Record, indexRecord IndexRecord, overridden bool, err error) {
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+
+	entry := o.entry(key)
+	switch {
+	case entry == nil || (entry.record == nil && !entry.deleted):
+		return nil, IndexRecord{}, false, nil
+	case entry.deleted:
+		return nil, IndexRecord{}, true, ErrKeyNotFound
+	}
+	return entry.record, entry.indexRecord, true, nil
+}
+
+func (o *Overlay) GetLen() int {
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+	o.purgeExpired()
+
+	length := o.base.GetLen()
+	for _, entry := range o.state.entries {
+		switch {
+		case entry.deleted:
+			length--
+		case !entry.inBase:
+			length++
+		}
+	}
+	return length
+}
+
+func (o *Overlay) GetRecordIndex(key string) (*IndexRecord, error) {
+	_, indexRecord, overridden, err := o.lookup(key)
+	if !overridden {
+		return o.base.GetRecordIndex(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &indexRecord, nil
+}
+
+func (o *Overlay) GetType(key string) (string, error) {
+	record, _, overridden, err := o.lookup(key)
+	if !overridden {
+		return o.base.GetType(key)
+	}
+	if err != nil {
+		return "", err
+	}
+	return record.Type, nil
+}
+
+// GetRecord returns the record of a key. Records of the overlay are never
+// modified, a write replaces them.
+func (o *Overlay) GetRecord(key string) (*Record, error) {
+	return o.GetRecordContext(context.Background(), key)
+}
+
+func (o *Overlay) GetRecordContext(ctx context.Context, key string) (*Record, error) {
+	record, _, overridden, err := o.lookup(key)
+	if !overridden {
+		return o.base.GetRecordContext(ctx, key)
+	}
+	return record, err
+}
+
+func (o *Overlay) GetRecords(keys []string) ([]*Record, error) {
+	return o.GetRecordsContext(context.Background(), keys)
+}
+
+func (o *Overlay) GetRecordsContext(ctx context.Context, keys []string) ([]*Record, error) {
+	records := make([]*Record, len(keys))
+	for i, key := range keys {
+		record, err := o.GetRecordContext(ctx, key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		records[i] = record
+	}
+	return records, nil
+}
+
+// ScanFields scans the base first, skipping deleted keys, and then the
+// keys added by the overlay. Cursors past the base length are positions
+// in the added keys.
+func (o *Overlay) ScanFields(start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	return o.ScanFieldsContext(context.Background(), start, count, pattern)
+}
+
+func (o *Overlay) ScanFieldsContext(ctx context.Context, start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	baseLen := o.base.GetLen()
+	if start < baseLen {
+		baseRecords, baseCursor, err := o.base.ScanFieldsContext(ctx, start, count, pattern)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		o.state.mutex.Lock()
+		defer o.state.mutex.Unlock()
+		for _, indexRecord := range baseRecords {
+			entry := o.entry(indexRecord.Key)
+			switch {
+			case entry == nil:
+				records = append(records, indexRecord)
+			case entry.deleted:
+			case entry.record == nil:
+				records = append(records, indexRecord)
+			default:
+				records = append(records, entry.indexRecord)
+			}
+		}
+		if baseCursor == 0 && len(o.state.added) > 0 {
+			baseCursor = baseLen
+		}
+		return records, baseCursor, nil
+	}
+
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+	i := start - baseLen
+	for ; i < len(o.state.added); i++ {
+		if len(records) >= count {
+			return records, baseLen + i, nil
+		}
+		key := o.state.added[i]
+		entry := o.entry(key)
+		if entry == nil || entry.deleted || entry.inBase {
+			continue
+		}
+		if pattern != "" && !match.Match(key, pattern) {
+			continue
+		}
+		records = append(records, entry.indexRecord)
+	}
+	return records, 0, nil
+}
+
+func (o *Overlay) Keys(pattern string) []string {
+	keys, _ := o.KeysContext(context.Background(), pattern)
+	return keys
+}
+
+func (o *Overlay) KeysContext(ctx context.Context, pattern string) ([]string, error) {
+	baseKeys, err := o.base.KeysContext(ctx, pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+	o.purgeExpired()
+
+	keys := []string{}
+	for _, key := range baseKeys {
+		if entry, ok := o.state.entries[key]; ok && entry.deleted {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	for key, entry := range o.state.entries {
+		if !entry.inBase && !entry.deleted && match.Match(key, pattern) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (o *Overlay) RandomKey() (string, error) {
+	// the base is usually much bigger than the overlay, and a few retries
+	// are enough to skip deleted keys
+	baseLen := o.base.GetLen()
+	for i := 0; i < 10; i++ {
+		o.state.mutex.Lock()
+		added := len(o.state.added)
+		if added > 0 && rand.Intn(baseLen+added) < added {
+			key := o.state.added[rand.Intn(added)]
+			entry := o.entry(key)
+			o.state.mutex.Unlock()
+			if entry != nil && !entry.deleted && !entry.inBase {
+				return key, nil
+			}
+			continue
+		}
+		o.state.mutex.Unlock()
+
+		key, err := o.base.RandomKey()
+		if err != nil {
+			break
+		}
+		o.state.mutex.Lock()
+		entry := o.entry(key)
+		o.state.mutex.Unlock()
+		if entry == nil || !entry.deleted {
+			return key, nil
+		}
+	}
+
+	keys := o.Keys("*")
+	if len(keys) == 0 {
+		return "", ErrKeyNotFound
+	}
+	return keys[rand.Intn(len(keys))], nil
+}
+
+func (o *Overlay) GetStringLen(key string) (int, error) {
+	return o.GetStringLenContext(context.Background(), key)
+}
+
+func (o *Overlay) GetStringLenContext(ctx context.Context, key string) (int, error) {
+	record, _, overridden, err := o.lookup(key)
+	if !overridden {
+		return o.base.GetStringLenContext(ctx, key)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if record.Type != StringType {
+		return 0, ErrWrongType
+	}
+	if record.StringRecord == nil {
+		return 0, nil
+	}
+	return len(record.StringRecord.Value), nil
+}
+
+func (o *Overlay) GetStringSlice(key string, from int, to int) (string, error) {
+	return o.GetStringSliceContext(context.Background(), key, from, to)
+}
+
+func (o *Overlay) GetStringSliceContext(ctx context.Context, key string, from int, to int) (string, error) {
+	record, _, overridden, err := o.lookup(key)
+	if !overridden {
+		return o.base.GetStringSliceContext(ctx, key, from, to)
+	}
+	if err != nil {
+		return "", err
+	}
+	if record.Type != StringType {
+		return "", ErrWrongType
+	}
+	if record.StringRecord == nil {
+		return "", nil
+	}
+	// a SET since the length was read can have made the value shorter
+	return sliceString(record.StringRecord.Value, from, to), nil
+}
+
+// CacheStats returns the cache counters of the base
+func (o *Overlay) CacheStats() CacheStats {
+	return cacheStats(o.base)
+}
+
+// ReaderPoolStats returns the reader pool counters of the base
+func (o *Overlay) ReaderPoolStats() PoolStats {
+	return readerPoolStats(o.base)
+}
+
+// Close closes the base, rebased overlays have their own copy of the
+// writes
+func (o *Overlay) Close() error {
+	return o.base.Close()
+}
+
+// inBase reports whether the base has a key. The base is read without the
+// state locked, like in baseRecord, so that a slow read doesn't hold up
+// every other command of the overlay. It's read only, what's read from it
+// stays true.
+func (o *Overlay) inBase(key string) bool {
+	_, err := o.base.GetRecordIndex(key)
+	return err == nil
+}
+
+// baseRecord reads the record of a key from the base, nil if the base
+// doesn't have it or the overlay overrides it
+func (o *Overlay) baseRecord(key string, inBase bool) (*Record, error) {
+	if !inBase {
+		return nil, nil
+	}
+	if _, _, overridden, _ := o.lookup(key); overridden {
+		return nil, nil
+	}
+	record, err := o.base.GetRecord(key)
+	if err == ErrKeyNotFound {
+		return nil, nil
+	}
+	return record, err
+}
+
+// current returns a copy of the record of a key to be modified, nil if
+// the key is missing. base is the record read with baseRecord. The state
+// must be locked.
+func (o *Overlay) current(key string, base *Record, inBase bool) (record *Record, err error) {
+	entry := o.entry(key)
+	switch {
+	case entry != nil && entry.deleted:
+		return nil, nil
+	case entry != nil && entry.record != nil:
+		record = entry.record
+	case base != nil:
+		record = base
+	case inBase:
+		// the overlay overrode the key when the base was read, and no
+		// longer does, which is rare enough to read it locked
+		record, err = o.base.GetRecord(key)
+		if err == ErrKeyNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	record = &Record{}
+	return record, json.Unmarshal(recordBytes, record)
+}
+
+// exists reports whether a key is there, inBase being whether the base
+// has it. The state must be locked.
+func (o *Overlay) exists(key string, inBase bool) bool {
+	if entry := o.entry(key); entry != nil {
+		return !entry.deleted
+	}
+	return inBase
+}
+
+// put stores a record, keeping the expiry of the key unless ttl is set.
+// The state must be locked.
+func (o *Overlay) put(record *Record, inBase bool, ttl time.Duration, keepExpiry bool) error {
+	recordBytes, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	entry := &overlayEntry{
+		record:      record,
+		indexRecord: IndexRecord{Key: record.Key, Len: len(recordBytes), Type: record.Type},
+		inBase:      inBase,
+	}
+	if previous := o.entry(record.Key); keepExpiry && previous != nil && !previous.deleted {
+		entry.expireAt = previous.expireAt
+	}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+	o.state.entries[record.Key] = entry
+	if !inBase {
+		o.state.addAdded(record.Key)
+	}
+	return nil
+}
+
+// Set sets a string value, it expires after ttl unless ttl is 0
+func (o *Overlay) Set(key string, value string, ttl time.Duration) error {
+	inBase := o.inBase(key)
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+
+	return o.put(&Record{Key: key, Type: StringType, StringRecord: &StringRecord{Value: value}}, inBase, ttl, false)
+}
+
+// Delete deletes keys, and returns how many of them were there
+func (o *Overlay) Delete(keys ...string) int {
+	inBase := make([]bool, len(keys))
+	for i, key := range keys {
+		inBase[i] = o.inBase(key)
+	}
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+
+	deleted := 0
+	for i, key := range keys {
+		if !o.exists(key, inBase[i]) {
+			continue
+		}
+		o.remove(key, inBase[i])
+		deleted++
+	}
+	return deleted
+}
+
+// Expire makes a key expire after ttl, a ttl that is not positive deletes
+// the key. It returns false if there's no such key.
+func (o *Overlay) Expire(key string, ttl time.Duration) bool {
+	inBase := o.inBase(key)
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+
+	if !o.exists(key, inBase) {
+		return false
+	}
+	if ttl <= 0 {
+		o.remove(key, inBase)
+		return true
+	}
+
+	entry := o.entry(key)
+	if entry == nil {
+		entry = &overlayEntry{inBase: inBase}
+		o.state.entries[key] = entry
+	}
+	entry.expireAt = time.Now().Add(ttl)
+	return true
+}
+
+// modify applies change to a copy of the record of a key, or to a new
+// empty record of recordType if the key is missing. A record left empty
+// by change is deleted, like Redis deletes empty aggregates.
+func (o *Overlay) modify(key string, recordType string, change func(record *Record) (result int, empty bool)) (int, error) {
+	inBase := o.inBase(key)
+	base, err := o.baseRecord(key, inBase)
+	if err != nil {
+		return 0, err
+	}
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+
+	record, err := o.current(key, base, inBase)
+	if err != nil {
+		return 0, err
+	}
+	if record == nil {
+		record = &Record{Key: key, Type: recordType}
+	}
+	if record.Type != recordType {
+		return 0, ErrWrongType
+	}
+
+	result, empty := change(record)
+	if empty {
+		if o.exists(key, inBase) {
+			o.remove(key, inBase)
+		}
+		return result, nil
+	}
+	return result, o.put(record, inBase, 0, true)
+}
+
+// HSet sets hash fields, and returns the number of fields that were added
+func (o *Overlay) HSet(key string, fields map[string]string) (int, error) {
+	return o.modify(key, HashType, func(record *Record) (int, bool) {
+		if record.HashRecord == nil {
+			record.HashRecord = &HashRecord{Fields: map[string]string{}}
+		}
+		if record.HashRecord.Fields == nil {
+			record.HashRecord.Fields = map[string]string{}
+		}
+		added := 0
+		for field, value := range fields {
+			if _, ok := record.HashRecord.Fields[field]; !ok {
+				added++
+			}
+			record.HashRecord.Fields[field] = value
+		}
+		// a precomputed order doesn't know about new fields
+		record.HashRecord.OrderedFields = nil
+		return added, false
+	})
+}
+
+// HDel deletes hash fields, and returns the number of fields that were there
+func (o *Overlay) HDel(key string, fields ...string) (int, error) {
+	return o.modify(key, HashType, func(record *Record) (int, bool) {
+		if record.HashRecord == nil {
+			return 0, true
+		}
+		deleted := 0
+		for _, field := range fields {
+			if _, ok := record.HashRecord.Fields[field]; ok {
+				delete(record.HashRecord.Fields, field)
+				deleted++
+			}
+		}
+		record.HashRecord.OrderedFields = nil
+		return deleted, len(record.HashRecord.Fields) == 0
+	})
+}
+
+// LPush prepends elements to a list one after another, and returns the
+// length of the list
+func (o *Overlay) LPush(key string, elements ...string) (int, error) {
+	return o.modify(key, ListType, func(record *Record) (int, bool) {
+		if record.ListRecord == nil {
+			record.ListRecord = &ListRecord{}
+		}
+		pushed := make([]string, 0, len(elements)+len(record.ListRecord.Elements))
+		for i := len(elements) - 1; i >= 0; i-- {
+			pushed = append(pushed, elements[i])
+		}
+		record.ListRecord.Elements = append(pushed, record.ListRecord.Elements...)
+		return len(record.ListRecord.Elements), false
+	})
+}
+
+// SAdd adds set members, and returns the number of members that were added
+func (o *Overlay) SAdd(key string, members ...string) (int, error) {
+	return o.modify(key, SetType, func(record *Record) (int, bool) {
+		if record.SetRecord == nil {
+			record.SetRecord = &SetRecord{}
+		}
+		added := 0
+		for _, member := range members {
+			if !record.SetRecord.IsMember(member) {
+				record.SetRecord.Members = append(record.SetRecord.Members, member)
+				added++
+			}
+		}
+		return added, false
+	})
+}
+
+// ZAdd adds sorted set elements or updates their scores, and returns the
+// number of elements that were added
+func (o *Overlay) ZAdd(key string, elements ...OrderedSetElement) (int, error) {
+	return o.modify(key, ZSetType, func(record *Record) (int, bool) {
+		if record.OrdderSetRecord == nil {
+			record.OrdderSetRecord = &OrderedSetRecord{}
+		}
+		added := 0
+		for _, element := range elements {
+			updated := false
+			for i := range record.OrdderSetRecord.Elements {
+				if record.OrdderSetRecord.Elements[i].Value == element.Value {
+					record.OrdderSetRecord.Elements[i].Score = element.Score
+					updated = true
+					break
+				}
+			}
+			if !updated {
+				record.OrdderSetRecord.Elements = append(record.OrdderSetRecord.Elements, element)
+				added++
+			}
+		}
+		return added, false
+	})
+}
+
+// Dump writes the overlay as a jsonl delta, sorted by key: written records,
+// and tombstones of deleted base keys. Expiries are not kept, keys that
+// haven't expired yet are written as they are.
+func (o *Overlay) Dump(out io.Writer) error {
+	o.state.mutex.Lock()
+	defer o.state.mutex.Unlock()
+	o.purgeExpired()
+
+	var lines strings.Builder
+	encoder := json.NewEncoder(&lines)
+	for _, key := range o.sortedEntryKeys() {
+		entry := o.state.entries[key]
+		var err error
+		switch {
+		case entry.deleted:
+			err = encoder.Encode(Record{Key: key, Type: TombstoneType})
+		case entry.record != nil:
+			err = encoder.Encode(entry.record)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(out, lines.String())
+	return err
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mockOverlay(t *testing.T) *Overlay {
+	base, err := NewMemoryStore(MockRecords())
+	assert.NoError(t, err)
+	return NewOverlay(base)
+}
+
+func TestOverlayWrites(t *testing.T) {
+	overlay := mockOverlay(t)
+	baseLen := overlay.GetLen()
+
+	assert.NoError(t, overlay.Set("key0:string", "changed", 0))
+	assert.NoError(t, overlay.Set("new:string", "added", 0))
+	assert.Equal(t, baseLen+1, overlay.GetLen())
+
+	record, err := overlay.GetRecord("key0:string")
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", record.StringRecord.Value)
+	length, err := overlay.GetStringLen("new:string")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, length)
+
+	assert.Equal(t, 2, overlay.Delete("key1:string", "new:string", "nosuchkey"))
+	assert.Equal(t, baseLen-1, overlay.GetLen())
+	_, err = overlay.GetRecord("key1:string")
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = overlay.GetType("new:string")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// a deleted key can be written again
+	assert.NoError(t, overlay.Set("key1:string", "back", 0))
+	recordType, err := overlay.GetType("key1:string")
+	assert.NoError(t, err)
+	assert.Equal(t, StringType, recordType)
+}
+
+func TestOverlayStringSliceOfShorterValue(t *testing.T) {
+	overlay := mockOverlay(t)
+	assert.NoError(t, overlay.Set("new:string", "a long value", 0))
+	length, err := overlay.GetStringLen("new:string")
+	assert.NoError(t, err)
+
+	// a SET between reading the length and the slice, as in GETRANGE
+	assert.NoError(t, overlay.Set("new:string", "short", 0))
+	value, err := overlay.GetStringSlice("new:string", 2, length)
+	assert.NoError(t, err)
+	assert.Equal(t, "ort", value)
+	value, err = overlay.GetStringSlice("new:string", 7, length)
+	assert.NoError(t, err)
+	assert.Equal(t, "", value)
+}
+
+func TestOverlayAggregates(t *testing.T) {
+	overlay := mockOverlay(t)
+
+	added, err := overlay.HSet("key0:hash", map[string]string{"field0:1": "changed", "new": "added"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	record, err := overlay.GetRecord("key0:hash")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"field0:1": "changed", "field0:2": "value1", "new": "added"}, record.HashRecord.Fields)
+
+	// the base record is untouched
+	base, err := overlay.base.GetRecord("key0:hash")
+	assert.NoError(t, err)
+	assert.Len(t, base.HashRecord.Fields, 2)
+
+	// a hash left without fields is deleted
+	deleted, err := overlay.HDel("key0:hash", "field0:1", "field0:2", "new", "nosuchfield")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+	_, err = overlay.GetRecord("key0:hash")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	length, err := overlay.LPush("key0:list", "a", "b")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, length)
+	record, err = overlay.GetRecord("key0:list")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "a", "element0:1", "element0:2"}, record.ListRecord.Elements)
+
+	added, err = overlay.SAdd("new:set", "a", "b", "a")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	added, err = overlay.ZAdd("key0:zset", OrderedSetElement{Value: "key0:zset:1", Score: 5}, OrderedSetElement{Value: "new", Score: 0})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, added)
+	record, err = overlay.GetRecord("key0:zset")
+	assert.NoError(t, err)
+	score, ok := record.OrdderSetRecord.Score("key0:zset:1")
+	assert.True(t, ok)
+	assert.Equal(t, 5.0, score)
+
+	_, err = overlay.SAdd("key0:string", "member")
+	assert.Equal(t, ErrWrongType, err)
+}
+
+func TestOverlayExpire(t *testing.T) {
+	overlay := mockOverlay(t)
+	baseLen := overlay.GetLen()
+
+	assert.True(t, overlay.Expire("key0:string", 10*time.Millisecond))
+	assert.False(t, overlay.Expire("nosuchkey", time.Second))
+	assert.NoError(t, overlay.Set("new:string", "added", 10*time.Millisecond))
+	assert.Equal(t, baseLen+1, overlay.GetLen())
+
+	// modifying an expiring key keeps its expiry
+	assert.True(t, overlay.Expire("key0:list", 10*time.Millisecond))
+	_, err := overlay.LPush("key0:list", "a")
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+	_, err = overlay.GetRecord("key0:string")
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = overlay.GetRecord("key0:list")
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, baseLen-2, overlay.GetLen())
+	assert.NotContains(t, overlay.Keys("*"), "new:string")
+
+	// a non positive ttl deletes right away
+	assert.True(t, overlay.Expire("key1:string", 0))
+	_, err = overlay.GetRecord("key1:string")
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestOverlayScan(t *testing.T) {
+	overlay := mockOverlay(t)
+	assert.NoError(t, overlay.Set("a:new", "added", 0))
+	assert.NoError(t, overlay.Set("z:new", "added", 0))
+	assert.Equal(t, 2, overlay.Delete("key0:string", "key3:hash"))
+	assert.NoError(t, overlay.Set("key1:string", "changed", 0))
+
+	scanned := []string{}
+	cursor := 0
+	for {
+		records, next, err := overlay.ScanFields(cursor, 7, "*")
+		assert.NoError(t, err)
+		for _, record := range records {
+			scanned = append(scanned, record.Key)
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.ElementsMatch(t, overlay.Keys("*"), scanned)
+	assert.Len(t, scanned, overlay.GetLen())
+}
+
+func TestOverlayDump(t *testing.T) {
+	overlay := mockOverlay(t)
+	assert.NoError(t, overlay.Set("key0:string", "changed", 0))
+	assert.Equal(t, 1, overlay.Delete("key1:string"))
+	_, err := overlay.SAdd("new:set", "a")
+	assert.NoError(t, err)
+	// added and deleted keys leave nothing behind
+	assert.NoError(t, overlay.Set("gone", "", 0))
+	assert.Equal(t, 1, overlay.Delete("gone"))
+
+	var dump bytes.Buffer
+	assert.NoError(t, overlay.Dump(&dump))
+	assert.Equal(t, `{"key":"key0:string","type":"string","string_record":{"value":"changed"}}
+{"key":"key1:string","type":"tombstone"}
+{"key":"new:set","type":"set","set_record":{"members":["a"]}}
+`, dump.String())
+
+	// the dump is a delta layer, that gives the same store over the base
+	delta, err := NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(dump.Bytes())), nil
+	})
+	assert.NoError(t, err)
+	base, err := NewMemoryStore(MockRecords())
+	assert.NoError(t, err)
+	layered, err := NewLayeredStore(base, delta)
+	assert.NoError(t, err)
+	assert.Equal(t, overlay.Keys("*"), layered.Keys("*"))
+}
+
+func TestOverlayRebase(t *testing.T) {
+	overlay := mockOverlay(t)
+	assert.NoError(t, overlay.Set("key0:string", "changed", 0))
+	assert.Equal(t, 1, overlay.Delete("key1:string"))
+
+	newBase, err := NewMemoryStore([]Record{stringRecord("key2:string", "new base")})
+	assert.NoError(t, err)
+	rebased := overlay.Rebase(newBase)
+
+	// the write is kept, the deletion of a key the base lost is moot
+	assert.Equal(t, []string{"key0:string", "key2:string"}, rebased.Keys("*"))
+	assert.Equal(t, 2, rebased.GetLen())
+	record, err := rebased.GetRecord("key0:string")
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", record.StringRecord.Value)
+
+	// the previous overlay still serves its base while it's replaced
+	assert.Equal(t, len(MockRecords())-1, overlay.GetLen())
+	assert.NoError(t, rebased.Set("key3:string", "rebased", 0))
+	record, err = overlay.GetRecord("key3:string")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "rebased", record.StringRecord.Value)
+}
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// minIndexChunkSize keeps small files from being split in tiny chunks
+const minIndexChunkSize = 1 << 20
+
+// BuildIndexParallel indexes a plain records file of size bytes like
+// BuildIndex does, splitting it in newline aligned chunks that are indexed
+// by workers goroutines (one per core when workers <= 0). Only key, type and
+// the string value position are extracted from a record, lines that aren't
+// valid json are refused like BuildIndex does, but unlike it the fields of
+// records aren't decoded. A compressed file is indexed by BuildIndex, as
+// its blocks have to be read in order.
+func BuildIndexParallel(in io.ReaderAt, size int64, workers int) (*StoreIndex, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	chunkSize := size / int64(4*workers)
+	if chunkSize < minIndexChunkSize {
+		chunkSize = minIndexChunkSize
+	}
+	return buildIndexInChunks(in, size, workers, chunkSize)
+}
+
+// buildIndex indexes records in parallel when they can be read at offsets,
+// workers is as in BuildIndexParallel, and 1 indexes them with BuildIndex
+func buildIndex(records io.ReadSeeker, workers int) (*StoreIndex, error) {
+	readerAt, ok := records.(io.ReaderAt)
+	if !ok || workers == 1 {
+		return BuildIndex(records)
+	}
+	size, err := records.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	return BuildIndexParallel(readerAt, size, workers)
+}
+
+// indexChunk is the part of the index built from a chunk of records
+type indexChunk struct {
+	start, end int64
+	// records are in file order, keys are sorted
+	records []IndexRecord
+	keys    []string
+	lines   int
+	err     error
+}
+
+func buildIndexInChunks(in io.ReaderAt, size int64, workers int, chunkSize int64) (*StoreIndex, error) {
+	head := make([]byte, len(zstdMagic))
+	n, err := in.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if detectCompression(head[:n]) != NoCompression {
+		return BuildIndex(io.NewSectionReader(in, 0, size))
+	}
+
+	chunks, err := splitInChunks(in, size, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+
+	next := make(chan *indexChunk, len(chunks))
+	for _, chunk := range chunks {
+		next <- chunk
+	}
+	close(next)
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(chunks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range next {
+				chunk.index(in)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return mergeIndexChunks(chunks)
+}
+
+// splitInChunks splits records in chunks of about chunkSize, each
+// starting at the beginning of a line
+func splitInChunks(in io.ReaderAt, size int64, chunkSize int64) (chunks []*indexChunk, err error) {
+	start := int64(0)
+	for start < size {
+		end := start + chunkSize
+		if end >= size {
+			end = size
+		} else if end, err = nextLineStart(in, end, size); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, &indexChunk{start: start, end: end})
+		start = end
+	}
+	return chunks, nil
+}
+
+// nextLineStart returns the start of the first line at or after offset
+func nextLineStart(in io.ReaderAt, offset int64, size int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	// a line starts at offset if the previous byte ends a line
+	for position := offset - 1; position < size; {
+		n, err := in.ReadAt(buf, position)
+		if newline := bytes.IndexByte(buf[:n], '\n'); newline >= 0 {
+			return position + int64(newline) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		position += int64(n)
+	}
+	return size, nil
+}
+
+func (c *indexChunk) index(in io.ReaderAt) {
+	lines := newLineReader(io.NewSectionReader(in, c.start, c.end-c.start))
+	for {
+		line, offset, err := lines.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			c.err = err
+			return
+		}
+		if len(line) == 0 {
+			continue
+		}
+		offset += c.start
+
+		// the scanner doesn't validate the line, a line that isn't valid
+		// json gets the error of json.Unmarshal
+		indexRecord, ok := scanIndexRecord(line, offset)
+		if !ok || !json.Valid(line) {
+			indexRecord, err = indexRecordFromLine(line, offset)
+			if err != nil {
+				c.err = lines.wrap(err, offset)
+				return
+			}
+		}
+		c.records = append(c.records, indexRecord)
+		c.keys = append(c.keys, indexRecord.Key)
+	}
+	c.lines = lines.line - 1
+	sort.Strings(c.keys)
+}
+
+// mergeIndexChunks merges chunk indexes, in file order, so that a
+// duplicate key is indexed at its last line like BuildIndex does
+func mergeIndexChunks(chunks []*indexChunk) (*StoreIndex, error) {
+	// total counts duplicates too, it only sizes the index
+	total := 0
+	lines := 0
+	for _, chunk := range chunks {
+		if chunk.err != nil {
+			var lineErr *ErrParsingLine
+			if errors.As(chunk.err, &lineErr) {
+				lineErr.Line += lines
+			}
+			return nil, chunk.err
+		}
+		total += len(chunk.records)
+		lines += chunk.lines
+	}
+
+	store := &StoreIndex{Index: make(map[string]IndexRecord, total)}
+	if total > 0 {
+		store.SortedKeys = make([]string, 0, total)
+	}
+	keys := &keyHeap{}
+	for _, chunk := range chunks {
+		for _, indexRecord := range chunk.records {
+			store.Index[indexRecord.Key] = indexRecord
+		}
+		if len(chunk.keys) > 0 {
+			keys.chunks = append(keys.chunks, chunk.keys)
+		}
+	}
+
+	heap.Init(keys)
+	for keys.Len() > 0 {
+		key := keys.chunks[0][0]
+		if n := len(store.SortedKeys); n == 0 || store.SortedKeys[n-1] != key {
+			store.SortedKeys = append(store.SortedKeys, key)
+		}
+		keys.chunks[0] = keys.chunks[0][1:]
+		if len(keys.chunks[0]) == 0 {
+			heap.Pop(keys)
+		} else {
+			heap.Fix(keys, 0)
+		}
+	}
+	return store, nil
+}
+
+// keyHeap orders sorted key lists by their first key
+type keyHeap struct {
+	chunks [][]string
+}
+
+func (h *keyHeap) Len() int           { return len(h.chunks) }
+func (h *keyHeap) Less(i, j int) bool { return h.chunks[i][0] < h.chunks[j][0] }
+func (h *keyHeap) Swap(i, j int)      { h.chunks[i], h.chunks[j] = h.chunks[j], h.chunks[i] }
+func (h *keyHeap) Push(x interface{}) { h.chunks = append(h.chunks, x.([]string)) }
+func (h *keyHeap) Pop() interface{} {
+	last := h.chunks[len(h.chunks)-1]
+	h.chunks = h.chunks[:len(h.chunks)-1]
+	return last
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildIndexParallel(t *testing.T) {
+	var recordsBytes bytes.Buffer
+	recordsBytes.Write(MockJsonlBytes(MockRecords()))
+	for i := 0; i < 100; i++ {
+		record := stringRecord(fmt.Sprintf("string%03d", i), strings.Repeat("v", i*37))
+		recordsBytes.WriteString(record.String() + "\r\n")
+	}
+	// a duplicate, and a line the scanner leaves to json.Unmarshal
+	recordsBytes.WriteString(`{"key":"string007","type":"string","string_record":{"value":"again"}}` + "\n\n")
+	recordsBytes.WriteString(`{"KEY":"upper","type":"string","string_record":{"value":"v"}}`)
+
+	expected, err := BuildIndex(bytes.NewReader(recordsBytes.Bytes()))
+	assert.NoError(t, err)
+
+	for _, chunkSize := range []int64{1, 7, 100, 1000, int64(recordsBytes.Len())} {
+		for _, workers := range []int{1, 3} {
+			index, err := buildIndexInChunks(bytes.NewReader(recordsBytes.Bytes()), int64(recordsBytes.Len()), workers, chunkSize)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, index, "chunk size %d, %d workers", chunkSize, workers)
+		}
+	}
+
+	index, err := BuildIndexParallel(bytes.NewReader(recordsBytes.Bytes()), int64(recordsBytes.Len()), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, index)
+}
+
+func TestBuildIndexParallelEmpty(t *testing.T) {
+	expected, err := BuildIndex(bytes.NewReader(nil))
+	assert.NoError(t, err)
+	index, err := BuildIndexParallel(bytes.NewReader(nil), 0, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, index)
+}
+
+func TestBuildIndexParallelErrorPosition(t *testing.T) {
+	recordsBytes := MockJsonlBytes(MockRecords())
+	lines := bytes.Count(recordsBytes, []byte("\n"))
+	broken := len(recordsBytes)
+	recordsBytes = append(recordsBytes, []byte("{broken\n")...)
+
+	_, err := buildIndexInChunks(bytes.NewReader(recordsBytes), int64(len(recordsBytes)), 2, 100)
+	var lineErr *ErrParsingLine
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, lines+1, lineErr.Line)
+	assert.Equal(t, int64(broken), lineErr.Offset)
+}
+
+func TestBuildIndexParallelCompressed(t *testing.T) {
+	records := MockRecords()
+	var compressed bytes.Buffer
+	expected, err := WriteCompressedRecords(bytes.NewReader(MockJsonlBytes(records)), &compressed, ZstdCompression, 256)
+	assert.NoError(t, err)
+
+	index, err := buildIndexInChunks(bytes.NewReader(compressed.Bytes()), int64(compressed.Len()), 2, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, index)
+}
+
+func TestStoreBuildsIndexInParallel(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+
+	// bytes.Reader is an io.ReaderAt
+	store, err := NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
+		return readerAtCloser{bytes.NewReader(recordsBytes)}, nil
+	})
+	assert.NoError(t, err)
+	for _, record := range records {
+		rec, err := store.GetRecord(record.Key)
+		assert.NoError(t, err)
+		assert.JSONEq(t, record.String(), rec.String())
+	}
+}
+
+type readerAtCloser struct {
+	*bytes.Reader
+}
+
+func (readerAtCloser) Close() error {
+	return nil
+}
+
+func TestBuildIndexParallelInvalidJSON(t *testing.T) {
+	// lines the scanner finds key and type in, but that aren't valid json
+	for _, line := range []string{
+		`{"key":"bad","type":"list","list_record":{"elements":[tru]}}`,
+		`{"key":"bad","type":"list","list_record":{"elements":["a",]}}`,
+		`{"key":"bad","type":"hash","hash_record":{"fields":{"a" "b"}}}`,
+	} {
+		recordsBytes := append(MockJsonlBytes(MockRecords()), []byte(line+"\n")...)
+		_, err := BuildIndex(bytes.NewReader(recordsBytes))
+		assert.Error(t, err, line)
+		_, err = buildIndexInChunks(bytes.NewReader(recordsBytes), int64(len(recordsBytes)), 2, 100)
+		var lineErr *ErrParsingLine
+		assert.True(t, errors.As(err, &lineErr), line)
+	}
+}
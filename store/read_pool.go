@@ -1,27 +1,98 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type OpenReaderSeekCloser func() (io.ReadSeekCloser, error)
 
-// ReaderPool is a NON-lazy pool of io.ReaderSeekCloser
-// TODO: make it lazy later
+// ReaderPool is a pool of io.ReaderSeekCloser. Readers are opened on
+// demand, up to a max size, and readers above a min size are closed once
+// they have been idle for a while.
 type ReaderPool struct {
-	readerPool     chan io.ReadSeekCloser
-	defaultTimeout time.Duration
-	drainTimeout   time.Duration
-	drained        bool
+	openReaderSeekCloser OpenReaderSeekCloser
+	minSize              int
+	defaultTimeout       time.Duration
+	drainTimeout         time.Duration
+	idleTimeout          time.Duration
+	// slots holds a token for every reader handed out, so that no more
+	// than max size readers are in use
+	slots chan struct{}
+
+	mutex sync.Mutex
+	// idle readers, from the least to the most recently returned
+	idle []idleReader
+	open int
+	// drained is set once draining starts, no readers are handed out
+	// after that
+	drained bool
+	// closed is set when draining gave up waiting, readers returned
+	// afterwards are closed right away
+	closed    bool
+	reapTimer *time.Timer
+
+	gets     int64
+	timeouts int64
+	waitTime int64
+}
+
+type idleReader struct {
+	reader io.ReadSeekCloser
+	since  time.Time
+}
+
+// ReaderPoolConfig sizes a lazy ReaderPool
+type ReaderPoolConfig struct {
+	// MinSize readers are opened with the pool, and kept open
+	MinSize int
+	// MaxSize is how many readers can be in use at once
+	MaxSize int
+	// DefaultTimeout is how long GetReader waits for a reader when MaxSize
+	// readers are in use
+	DefaultTimeout time.Duration
+	// DrainTimeout is how long Drain waits for readers in use
+	DrainTimeout time.Duration
+	// IdleTimeout closes readers above MinSize that were not used for that
+	// long, 0 keeps them open
+	IdleTimeout time.Duration
+}
+
+// PoolStats are the counters of a reader pool
+type PoolStats struct {
+	// Open readers, in use or idle
+	Open  int64
+	InUse int64
+	// MaxSize is how many readers can be in use at once, InUse / MaxSize
+	// is the utilization of the pool
+	MaxSize int64
+	// Gets counts readers handed out, Timeouts the gets that timed out
+	Gets     int64
+	Timeouts int64
+	// WaitTime is the total time spent getting readers
+	WaitTime time.Duration
+}
+
+func (p PoolStats) add(other PoolStats) PoolStats {
+	return PoolStats{
+		Open:     p.Open + other.Open,
+		InUse:    p.InUse + other.InUse,
+		MaxSize:  p.MaxSize + other.MaxSize,
+		Gets:     p.Gets + other.Gets,
+		Timeouts: p.Timeouts + other.Timeouts,
+		WaitTime: p.WaitTime + other.WaitTime,
+	}
 }
 
 // Always timeouting EmptyReaderPool
 func NewEmptyReaderPool() *ReaderPool {
 	return &ReaderPool{
-		readerPool:     make(chan io.ReadSeekCloser),
+		slots:          make(chan struct{}),
 		defaultTimeout: time.Millisecond,
 		drainTimeout:   time.Millisecond,
 	}
@@ -31,21 +102,43 @@ func NewReaderPool(openReaderSeekCloser OpenReaderSeekCloser) (readerPool *Reade
 	return NewReaderPoolAdvanced(openReaderSeekCloser, 100, 100*time.Millisecond, 1*time.Second)
 }
 
+// NewReaderPoolAdvanced opens all maxConnections readers of the pool
+// upfront, and keeps them open
 func NewReaderPoolAdvanced(openReaderSeekCloser OpenReaderSeekCloser,
 	maxConnections int,
 	defaultTimeout time.Duration,
 	drainTimeout time.Duration) (readerPool *ReaderPool, err error) {
+	return NewLazyReaderPool(openReaderSeekCloser, ReaderPoolConfig{
+		MinSize:        maxConnections,
+		MaxSize:        maxConnections,
+		DefaultTimeout: defaultTimeout,
+		DrainTimeout:   drainTimeout,
+	})
+}
+
+// NewLazyReaderPool opens config.MinSize readers, the others are opened
+// when they are needed
+func NewLazyReaderPool(openReaderSeekCloser OpenReaderSeekCloser, config ReaderPoolConfig) (readerPool *ReaderPool, err error) {
+	if config.MinSize > config.MaxSize {
+		config.MinSize = config.MaxSize
+	}
 	readerPool = &ReaderPool{
-		defaultTimeout: defaultTimeout,
-		drainTimeout:   drainTimeout,
-		readerPool:     make(chan io.ReadSeekCloser, maxConnections),
+		openReaderSeekCloser: openReaderSeekCloser,
+		minSize:              config.MinSize,
+		defaultTimeout:       config.DefaultTimeout,
+		drainTimeout:         config.DrainTimeout,
+		idleTimeout:          config.IdleTimeout,
+		slots:                make(chan struct{}, config.MaxSize),
 	}
-	for i := 0; i < maxConnections; i++ {
+	now := time.Now()
+	for i := 0; i < config.MinSize; i++ {
 		reader, err := openReaderSeekCloser()
 		if err != nil {
+			closeReaders(readerPool.idle)
 			return nil, fmt.Errorf(("error opening reader %w"), err)
 		}
-		readerPool.readerPool <- reader
+		readerPool.idle = append(readerPool.idle, idleReader{reader, now})
+		readerPool.open++
 	}
 	return readerPool, nil
 }
@@ -54,33 +147,113 @@ var ErrSecuringReaderTimeout = errors.New("timeout securing reader (timed out ge
 var ErrSecuringReaderPoolDrained = errors.New("pool is drained, no readers available")
 var ErrTimedOutDrainingPool = errors.New("timed out draining pool")
 
+// DrainWithTimeout closes all readers of the pool, waiting up to timeout
+// for readers that are still in use. Readers that are returned after it
+// timed out are closed by ReturnReader.
 func (p *ReaderPool) DrainWithTimeout(timeout time.Duration) (err error) {
-	p.drained = true // insignificant race condition
-	for i := 0; i < cap(p.readerPool); i++ {
+	p.mutex.Lock()
+	p.drained = true
+	if p.reapTimer != nil {
+		p.reapTimer.Stop()
+	}
+	p.mutex.Unlock()
+
+	// every reader in use holds a slot until it's returned
+	deadline := time.After(timeout)
+	for i := 0; i < cap(p.slots); i++ {
 		select {
-		case reader := <-p.readerPool:
-			reader.Close()
-		case <-time.After(timeout):
+		case p.slots <- struct{}{}:
+		case <-deadline:
+			p.mutex.Lock()
+			p.closed = true
+			p.mutex.Unlock()
+			p.closeIdle()
 			return ErrTimedOutDrainingPool
 		}
 	}
+	p.closeIdle()
 	return nil
 }
 
+// closeIdle closes the idle readers of the pool
+func (p *ReaderPool) closeIdle() {
+	p.mutex.Lock()
+	readers := p.idle
+	p.idle = nil
+	p.open -= len(readers)
+	p.mutex.Unlock()
+	closeReaders(readers)
+}
+
+func closeReaders(readers []idleReader) {
+	for _, idle := range readers {
+		idle.reader.Close()
+	}
+}
+
 func (p *ReaderPool) Drain() (err error) {
 	return p.DrainWithTimeout(p.drainTimeout)
 }
 
 func (p *ReaderPool) GetReaderWithTimeout(timeout time.Duration) (reader io.ReadSeekCloser, err error) {
-	if p.drained { // little race condition has never caused any problems ;)
+	return p.getReader(context.Background(), timeout)
+}
+
+// GetReaderContext gets a reader within the default timeout, or until ctx
+// is done, whichever comes first
+func (p *ReaderPool) GetReaderContext(ctx context.Context) (reader io.ReadSeekCloser, err error) {
+	return p.getReader(ctx, p.defaultTimeout)
+}
+
+func (p *ReaderPool) getReader(ctx context.Context, timeout time.Duration) (reader io.ReadSeekCloser, err error) {
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&p.waitTime, int64(time.Since(start)))
+	}()
+
+	p.mutex.Lock()
+	drained := p.drained
+	p.mutex.Unlock()
+	if drained {
 		return nil, ErrSecuringReaderPoolDrained
 	}
+
 	select {
-	case reader = <-p.readerPool:
-		return reader, nil
+	case p.slots <- struct{}{}:
 	case <-time.After(timeout):
+		atomic.AddInt64(&p.timeouts, 1)
 		return nil, ErrSecuringReaderTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mutex.Lock()
+	if p.drained {
+		p.mutex.Unlock()
+		<-p.slots
+		return nil, ErrSecuringReaderPoolDrained
+	}
+	if last := len(p.idle) - 1; last >= 0 {
+		reader = p.idle[last].reader
+		p.idle = p.idle[:last]
+		p.mutex.Unlock()
+		atomic.AddInt64(&p.gets, 1)
+		return reader, nil
+	}
+	// no idle reader, a new one is opened outside of the lock
+	p.open++
+	p.mutex.Unlock()
+
+	reader, err = p.openReaderSeekCloser()
+	if err != nil {
+		p.mutex.Lock()
+		p.open--
+		p.mutex.Unlock()
+		<-p.slots
+		return nil, fmt.Errorf(("error opening reader %w"), err)
 	}
+	atomic.AddInt64(&p.gets, 1)
+	return reader, nil
 }
 
 func (p *ReaderPool) GetReader() (reader io.ReadSeekCloser, err error) {
@@ -88,5 +261,58 @@ func (p *ReaderPool) GetReader() (reader io.ReadSeekCloser, err error) {
 }
 
 func (p *ReaderPool) ReturnReader(reader io.ReadSeekCloser) {
-	p.readerPool <- reader
+	p.mutex.Lock()
+	if p.closed {
+		p.open--
+		p.mutex.Unlock()
+		reader.Close()
+		<-p.slots
+		return
+	}
+	p.idle = append(p.idle, idleReader{reader, time.Now()})
+	if p.idleTimeout > 0 && p.reapTimer == nil && !p.drained && p.open > p.minSize {
+		p.reapTimer = time.AfterFunc(p.idleTimeout, p.reapIdle)
+	}
+	p.mutex.Unlock()
+	<-p.slots
+}
+
+// reapIdle closes readers above the min size that have been idle for the
+// idle timeout, and schedules itself again while there are others
+func (p *ReaderPool) reapIdle() {
+	p.mutex.Lock()
+	p.reapTimer = nil
+	if p.drained {
+		p.mutex.Unlock()
+		return
+	}
+	now := time.Now()
+	expired := 0
+	for expired < len(p.idle) && p.open-expired > p.minSize && now.Sub(p.idle[expired].since) >= p.idleTimeout {
+		expired++
+	}
+	reaped := append([]idleReader(nil), p.idle[:expired]...)
+	p.idle = p.idle[expired:]
+	p.open -= expired
+	if len(p.idle) > 0 && p.open > p.minSize {
+		p.reapTimer = time.AfterFunc(p.idle[0].since.Add(p.idleTimeout).Sub(now), p.reapIdle)
+	}
+	p.mutex.Unlock()
+
+	closeReaders(reaped)
+}
+
+// Stats returns the counters of the pool
+func (p *ReaderPool) Stats() PoolStats {
+	p.mutex.Lock()
+	open, idle := p.open, len(p.idle)
+	p.mutex.Unlock()
+	return PoolStats{
+		Open:     int64(open),
+		InUse:    int64(open - idle),
+		MaxSize:  int64(cap(p.slots)),
+		Gets:     atomic.LoadInt64(&p.gets),
+		Timeouts: atomic.LoadInt64(&p.timeouts),
+		WaitTime: time.Duration(atomic.LoadInt64(&p.waitTime)),
+	}
 }
@@ -3,6 +3,7 @@ package store
 import (
 	"bytes"
 	"io"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -90,3 +91,135 @@ func TestDrainedPool(t *testing.T) {
 	_, err = pool.GetReader()
 	assert.Error(t, err, ErrSecuringReaderPoolDrained)
 }
+
+type closeCountingReader struct {
+	io.ReadSeeker
+	closed *int32
+}
+
+func (c closeCountingReader) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return nil
+}
+
+func TestDrainTimeoutClosesLateReaders(t *testing.T) {
+	closed := int32(0)
+	pool, err := NewReaderPoolAdvanced(func() (io.ReadSeekCloser, error) {
+		return closeCountingReader{bytes.NewReader([]byte("test")), &closed}, nil
+	}, 2, time.Millisecond, time.Millisecond)
+	assert.NoError(t, err)
+
+	r, err := pool.GetReader()
+	assert.NoError(t, err)
+
+	// the reader in use is not waited for past the timeout
+	assert.Equal(t, ErrTimedOutDrainingPool, pool.Drain())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&closed))
+
+	// and it's closed once it's returned
+	pool.ReturnReader(r)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&closed))
+}
+
+func TestDrainWaitsForReaders(t *testing.T) {
+	closed := int32(0)
+	pool, err := NewReaderPoolAdvanced(func() (io.ReadSeekCloser, error) {
+		return closeCountingReader{bytes.NewReader([]byte("test")), &closed}, nil
+	}, 2, time.Millisecond, time.Second)
+	assert.NoError(t, err)
+
+	r, err := pool.GetReader()
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		pool.ReturnReader(r)
+	}()
+
+	assert.NoError(t, pool.Drain())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&closed))
+}
+
+func TestLazyPool(t *testing.T) {
+	opened := int32(0)
+	closed := int32(0)
+	pool, err := NewLazyReaderPool(func() (io.ReadSeekCloser, error) {
+		atomic.AddInt32(&opened, 1)
+		return closeCountingReader{bytes.NewReader([]byte("test")), &closed}, nil
+	}, ReaderPoolConfig{MinSize: 1, MaxSize: 3, DefaultTimeout: time.Millisecond, DrainTimeout: time.Second, IdleTimeout: 20 * time.Millisecond})
+	assert.NoError(t, err)
+	// only the min size is opened upfront
+	assert.Equal(t, int32(1), atomic.LoadInt32(&opened))
+
+	readers := []io.ReadSeekCloser{}
+	for i := 0; i < 3; i++ {
+		r, err := pool.GetReader()
+		assert.NoError(t, err)
+		readers = append(readers, r)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&opened))
+	_, err = pool.GetReader()
+	assert.Equal(t, ErrSecuringReaderTimeout, err)
+
+	stats := pool.Stats()
+	assert.Equal(t, int64(3), stats.Open)
+	assert.Equal(t, int64(3), stats.InUse)
+	assert.Equal(t, int64(3), stats.MaxSize)
+	assert.Equal(t, int64(3), stats.Gets)
+	assert.Equal(t, int64(1), stats.Timeouts)
+	assert.GreaterOrEqual(t, stats.WaitTime, time.Millisecond)
+
+	for _, r := range readers {
+		pool.ReturnReader(r)
+	}
+	assert.Equal(t, int64(0), pool.Stats().InUse)
+
+	// idle readers above the min size are closed
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&closed) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), pool.Stats().Open)
+
+	// and opened again when needed
+	r, err := pool.GetReader()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&opened))
+	pool.ReturnReader(r)
+
+	assert.NoError(t, pool.Drain())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&closed))
+	assert.Equal(t, int64(0), pool.Stats().Open)
+}
+
+func TestDrainWhileGettingReaders(t *testing.T) {
+	opened := int32(0)
+	closed := int32(0)
+	pool, err := NewLazyReaderPool(func() (io.ReadSeekCloser, error) {
+		atomic.AddInt32(&opened, 1)
+		return closeCountingReader{bytes.NewReader([]byte("test")), &closed}, nil
+	}, ReaderPoolConfig{MaxSize: 4, DefaultTimeout: time.Millisecond, DrainTimeout: time.Second})
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		go func() {
+			for {
+				r, err := pool.GetReader()
+				if err == ErrSecuringReaderPoolDrained {
+					done <- struct{}{}
+					return
+				}
+				if err == nil {
+					pool.ReturnReader(r)
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, pool.Drain())
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+	// every reader opened was closed, none is handed out after draining
+	assert.Equal(t, atomic.LoadInt32(&opened), atomic.LoadInt32(&closed))
+	assert.Equal(t, int64(0), pool.Stats().Open)
+}
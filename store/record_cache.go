@@ -0,0 +1,130 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// recordOverhead approximates the memory a cached record takes besides
+// its decoded contents: the cache entry, the map entry and the Record
+const recordOverhead = 200
+
+// recordCache is a LRU cache of decoded records, bounded by an estimate of
+// the memory they take. A Store is immutable, so cached records never go
+// stale, and they are dropped with the store when it's replaced.
+// Cached records are shared, callers must not modify them.
+type recordCache struct {
+	mutex    sync.Mutex
+	maxBytes int64
+	bytes    int64
+	entries  map[string]*list.Element
+	// lru is ordered from the most to the least recently used
+	lru *list.List
+
+	hits   int64
+	misses int64
+}
+
+type cachedRecord struct {
+	key    string
+	record *Record
+	size   int64
+}
+
+// CacheStats are counters of the record cache of a store
+type CacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int64
+	// Bytes is the estimated memory taken by cached records
+	Bytes int64
+}
+
+func (c CacheStats) add(other CacheStats) CacheStats {
+	return CacheStats{
+		Hits:    c.Hits + other.Hits,
+		Misses:  c.Misses + other.Misses,
+		Entries: c.Entries + other.Entries,
+		Bytes:   c.Bytes + other.Bytes,
+	}
+}
+
+// newRecordCache returns a cache of up to maxBytes, or nil for a cache
+// that's disabled when maxBytes is 0
+func newRecordCache(maxBytes int64) *recordCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &recordCache{
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// recordSize estimates the memory a decoded record takes from the length
+// of its json line, decoded strings and maps taking about twice as much
+func recordSize(key string, lineLen int) int64 {
+	return int64(2*lineLen+len(key)) + recordOverhead
+}
+
+func (c *recordCache) get(key string) (*Record, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	element, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+	c.mutex.Unlock()
+
+	if !ok {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddInt64(&c.hits, 1)
+	return element.Value.(*cachedRecord).record, true
+}
+
+// add caches a record read from a line of lineLen bytes. Records taking
+// more than a quarter of the cache are not cached, so that a few big
+// records don't evict all others.
+func (c *recordCache) add(key string, record *Record, lineLen int) {
+	if c == nil {
+		return
+	}
+	size := recordSize(key, lineLen)
+	if size > c.maxBytes/4 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cachedRecord{key: key, record: record, size: size})
+	c.bytes += size
+	for c.bytes > c.maxBytes {
+		oldest := c.lru.Back()
+		cached := c.lru.Remove(oldest).(*cachedRecord)
+		delete(c.entries, cached.key)
+		c.bytes -= cached.size
+	}
+}
+
+func (c *recordCache) stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return CacheStats{
+		Hits:    atomic.LoadInt64(&c.hits),
+		Misses:  atomic.LoadInt64(&c.misses),
+		Entries: int64(len(c.entries)),
+		Bytes:   c.bytes,
+	}
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordCacheEviction(t *testing.T) {
+	size := recordSize("key0", 100)
+	cache := newRecordCache(4 * size)
+
+	for i := 0; i < 4; i++ {
+		record := stringRecord(fmt.Sprintf("key%d", i), "value")
+		cache.add(record.Key, &record, 100)
+	}
+	// key0 becomes the most recently used, key1 is evicted
+	_, ok := cache.get("key0")
+	assert.True(t, ok)
+	record := stringRecord("key4", "value")
+	cache.add(record.Key, &record, 100)
+
+	_, ok = cache.get("key1")
+	assert.False(t, ok)
+	for _, key := range []string{"key0", "key2", "key3", "key4"} {
+		cached, ok := cache.get(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, key, cached.Key)
+	}
+	assert.Equal(t, CacheStats{Hits: 5, Misses: 1, Entries: 4, Bytes: 4 * size}, cache.stats())
+
+	// too big to be cached
+	record = stringRecord("big", "value")
+	cache.add(record.Key, &record, 1000)
+	_, ok = cache.get("big")
+	assert.False(t, ok)
+}
+
+func TestDisabledRecordCache(t *testing.T) {
+	cache := newRecordCache(0)
+	assert.Nil(t, cache)
+	record := stringRecord("key", "value")
+	cache.add(record.Key, &record, 10)
+	_, ok := cache.get("key")
+	assert.False(t, ok)
+	assert.Equal(t, CacheStats{}, cache.stats())
+}
+
+func TestStoreRecordCache(t *testing.T) {
+	records := MockRecords()
+	config := DefaultConfig()
+	config.RecordCacheSize = 1 << 20
+	store, err := NewStoreFromRecordsWithConfig(openBytes(MockJsonlBytes(records)), config)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		for _, record := range records {
+			rec, err := store.GetRecord(record.Key)
+			assert.NoError(t, err)
+			assert.JSONEq(t, record.String(), rec.String())
+		}
+	}
+	_, err = store.GetRecord("nosuchkey")
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	cached, err := store.GetRecords([]string{records[0].Key, "nosuchkey"})
+	assert.NoError(t, err)
+	assert.Equal(t, records[0].Key, cached[0].Key)
+	assert.Nil(t, cached[1])
+
+	stats := store.CacheStats()
+	assert.Equal(t, int64(2*len(records)+1), stats.Hits)
+	assert.Equal(t, int64(len(records)+2), stats.Misses)
+	assert.Equal(t, int64(len(records)), stats.Entries)
+
+	layered, err := NewLayeredStore(store, storeFromRecords(t, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, stats, layered.CacheStats())
+	assert.Equal(t, stats, NewOverlay(layered).CacheStats())
+}
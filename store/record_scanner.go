@@ -0,0 +1,239 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// scanIndexRecord is a lightweight indexRecordFromLine: it only walks the
+// structure of a records line to find key, type and the raw string value,
+// without decoding the rest. It doesn't fully validate the line as json.
+// ok is false for lines it can't handle the same way json.Unmarshal does
+// (escaped or non ascii field names, a key or type that isn't a string,
+// malformed json), those are left to indexRecordFromLine.
+func scanIndexRecord(line []byte, offset int64) (indexRecord IndexRecord, ok bool) {
+	// spans of the string_record fields, json.Unmarshal merges them all
+	stringRecords := [][2]int{}
+	unsupported := false
+
+	i, ok := scanObject(line, skipSpace(line, 0), func(name string, start int, end int) bool {
+		switch {
+		case name == "key" || name == "type":
+			value, ok := decodeJSONString(line[start:end])
+			if !ok {
+				unsupported = true
+				return false
+			}
+			if name == "key" {
+				indexRecord.Key = value
+			} else {
+				indexRecord.Type = value
+			}
+		case name == "string_record":
+			stringRecords = append(stringRecords, [2]int{start, end})
+		case strings.EqualFold(name, "key") || strings.EqualFold(name, "type") || strings.EqualFold(name, "string_record"):
+			// json.Unmarshal matches field names case insensitively
+			unsupported = true
+			return false
+		}
+		return true
+	})
+	if !ok || unsupported || skipSpace(line, i) != len(line) {
+		return IndexRecord{}, false
+	}
+	indexRecord.Offset = offset
+	indexRecord.Len = len(line)
+	indexRecord.CRC = recordCRC(line)
+	if indexRecord.Type == StringType {
+		valueOffset, valueLen, ok, supported := scanStringValueSpan(line, stringRecords)
+		if !supported {
+			return IndexRecord{}, false
+		}
+		if ok {
+			indexRecord.ValueOffset = valueOffset
+			indexRecord.ValueLen = valueLen
+		}
+	}
+	return indexRecord, true
+}
+
+// scanStringValueSpan is stringValueSpan over the string_record objects
+// found at stringRecords. supported is false for values json.Unmarshal
+// doesn't decode as a string, which are left to indexRecordFromLine.
+func scanStringValueSpan(line []byte, stringRecords [][2]int) (offset int, length int, ok bool, supported bool) {
+	found := false
+	for _, stringRecord := range stringRecords {
+		start, end := stringRecord[0], stringRecord[1]
+		if string(line[start:end]) == "null" {
+			found = false
+			continue
+		}
+		if line[start] != '{' {
+			return 0, 0, false, false
+		}
+		unsupported := false
+		_, walked := scanObject(line[:end], start, func(name string, valueStart int, valueEnd int) bool {
+			if !strings.EqualFold(name, "value") {
+				return true
+			}
+			switch {
+			case name != "value" || line[valueStart] != '"' && string(line[valueStart:valueEnd]) != "null":
+				unsupported = true
+				return false
+			case line[valueStart] == '"':
+				offset, length, ok = rawStringSpan(line, valueStart, valueEnd)
+				found = true
+			}
+			return true
+		})
+		if unsupported {
+			return 0, 0, false, false
+		}
+		if !walked {
+			// escaped field names are decoded by stringValueSpan
+			offset, length, ok = stringValueSpan(line)
+			return offset, length, ok, true
+		}
+	}
+	if !found || !ok {
+		return 0, 0, false, true
+	}
+	return offset, length, true, true
+}
+
+// scanObject walks the fields of the object starting at i, calling field
+// with the name and the span of the value of each. Walking stops when
+// field returns false. It returns the position after the object.
+func scanObject(line []byte, i int, field func(name string, start int, end int) bool) (int, bool) {
+	if i >= len(line) || line[i] != '{' {
+		return i, false
+	}
+	i = skipSpace(line, i+1)
+	if i < len(line) && line[i] == '}' {
+		return i + 1, true
+	}
+	for {
+		nameEnd, escaped, ok := scanString(line, i)
+		if !ok || escaped {
+			return i, false
+		}
+		name := line[i+1 : nameEnd-1]
+		for _, b := range name {
+			if b >= utf8.RuneSelf {
+				return i, false
+			}
+		}
+
+		i = skipSpace(line, nameEnd)
+		if i >= len(line) || line[i] != ':' {
+			return i, false
+		}
+		start := skipSpace(line, i+1)
+		end, ok := skipJSONValue(line, start)
+		if !ok {
+			return i, false
+		}
+		if !field(string(name), start, end) {
+			return end, true
+		}
+
+		i = skipSpace(line, end)
+		if i >= len(line) {
+			return i, false
+		}
+		switch line[i] {
+		case ',':
+			i = skipSpace(line, i+1)
+		case '}':
+			return i + 1, true
+		default:
+			return i, false
+		}
+	}
+}
+
+// skipJSONValue returns the position after the value starting at i
+func skipJSONValue(line []byte, i int) (int, bool) {
+	if i >= len(line) {
+		return i, false
+	}
+	switch line[i] {
+	case '"':
+		end, _, ok := scanString(line, i)
+		return end, ok
+	case '{', '[':
+		depth := 0
+		for i < len(line) {
+			switch line[i] {
+			case '"':
+				end, _, ok := scanString(line, i)
+				if !ok {
+					return i, false
+				}
+				i = end
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return i + 1, true
+				}
+			}
+			i++
+		}
+		return i, false
+	}
+	// a number, true, false or null
+	start := i
+	for ; i < len(line); i++ {
+		switch line[i] {
+		case ',', '}', ']', ' ', '\t', '\r', '\n':
+			return i, i > start
+		}
+	}
+	return i, i > start
+}
+
+// scanString returns the position after the string starting at i, and
+// whether it has escapes
+func scanString(line []byte, i int) (end int, escaped bool, ok bool) {
+	if i >= len(line) || line[i] != '"' {
+		return i, false, false
+	}
+	for i++; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			escaped = true
+			i++
+		case '"':
+			return i + 1, escaped, true
+		}
+	}
+	return i, escaped, false
+}
+
+// decodeJSONString decodes a json string, ok is false for other values
+func decodeJSONString(value []byte) (string, bool) {
+	if len(value) < 2 || value[0] != '"' {
+		return "", false
+	}
+	raw := value[1 : len(value)-1]
+	if bytes.IndexByte(raw, '\\') < 0 && utf8.Valid(raw) {
+		return string(raw), true
+	}
+	var decoded string
+	if err := json.Unmarshal(value, &decoded); err != nil {
+		return "", false
+	}
+	return decoded, true
+}
+
+func skipSpace(line []byte, i int) int {
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t' || line[i] == '\r' || line[i] == '\n') {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanIndexRecord(t *testing.T) {
+	lines := []string{
+		`{"key":"a","type":"string","string_record":{"value":"plain"}}`,
+		`{ "type" : "string" , "string_record" : { "other" : [1, {"value": "x"}], "value" : "spaced" } , "key" : "b" }`,
+		`{"key":"c","type":"string","string_record":{"value":"esc\"aped"}}`,
+		`{"key":"dé","type":"hash","hash_record":{"fields":{"key":"x","type":"y"}}}`,
+		`{"key":"e","type":"zset","ordered_set_record":{"elements":[{"value":"}","score":1.5e3}]}}`,
+		`{"key":"f","type":"string","string_record":{"value":"first"},"string_record":{"value":"second"}}`,
+		`{"key":"g","key":"h","type":"set","set_record":{"members":[]}}`,
+		`{"type":"tombstone","key":"i"}`,
+		`{"key":"j","type":"list","list_record":null}`,
+		`{"key":"k","type":"string","string_record":{"other":"value"}}`,
+		`{"string_record":{"value":null},"key":"l","type":"string"}`,
+		`{"key":"m","type":"string","string_record":{"value":"first","value":"second"}}`,
+		`{"key":"n","type":"string","string_record":{"value":"kept"},"string_record":{"value":null}}`,
+		`{"key":"o","type":"string","string_record":{"value":"x"},"string_record":null}`,
+	}
+	for _, line := range lines {
+		expected, err := indexRecordFromLine([]byte(line), 42)
+		assert.NoError(t, err)
+		indexRecord, ok := scanIndexRecord([]byte(line), 42)
+		assert.True(t, ok, line)
+		assert.Equal(t, expected, indexRecord, line)
+	}
+}
+
+func TestScanIndexRecordUnsupported(t *testing.T) {
+	lines := []string{
+		`{"KEY":"a","type":"string"}`,
+		`{"k\u0065y":"a","type":"string"}`,
+		`{"key":null,"type":"string"}`,
+		`{"key":"a","type":"string"`,
+		`{"key":"a","type":"string"} trailing`,
+		`{"key":"a","type":"string","string_record":{"value":5,"other":"x"}}`,
+		`{"key":"a","type":"string","String_Record":{"value":"x"}}`,
+		`{"key":"a","type":"string","string_record":{"Value":"x"}}`,
+		`["key","a"]`,
+		``,
+	}
+	for _, line := range lines {
+		_, ok := scanIndexRecord([]byte(line), 0)
+		assert.False(t, ok, line)
+	}
+}
@@ -2,7 +2,8 @@ package store
 
 import (
 	"encoding/json"
-	"hash/fnv"
+	"fmt"
+	"sort"
 
 	"github.com/tidwall/match"
 )
@@ -13,46 +14,62 @@ type StringRecord struct {
 
 type HashRecord struct {
 	Fields map[string]string `json:"fields"`
-	//let's go w/o this. This going to be slower, but this waves a requirement for syncing this
-	//OrderedFields []string          `json:"ordered_fields,omitempty"`
+	// OrderedFields is an optional, precomputed field order. When it is
+	// absent (or doesn't cover Fields), fields are sorted on every scan.
+	OrderedFields []string `json:"ordered_fields,omitempty"`
 }
 
-func makeHash(s string) int {
-	hash := fnv.New32a()
-	hash.Write([]byte(s))
-	return int(hash.Sum32())
+// SortedFields returns field names in a stable order: OrderedFields if the
+// record carries a valid one, byte-wise sorted names otherwise.
+func (hr *HashRecord) SortedFields() []string {
+	if hr.hasValidOrderedFields() {
+		return hr.OrderedFields
+	}
+	fields := make([]string, 0, len(hr.Fields))
+	for field := range hr.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
 }
 
-func (hr *HashRecord) ScanFields(cursor int, count int, pattern string) (fields []string, last int, err error) {
-	foundIt := cursor == 0
-	stoppedEarly := false
-	last = 0
-	for key, value := range hr.Fields {
-		// this is quite inefficient for big hashes, but this
-		// waves a requirement for syncing & keeping a state for the cursor
-		if !foundIt {
-			last = makeHash(key)
-			if last == cursor {
-				foundIt = true
-			}
-			continue
+// hasValidOrderedFields tells whether OrderedFields lists every field
+// exactly once
+func (hr *HashRecord) hasValidOrderedFields() bool {
+	if len(hr.OrderedFields) == 0 || len(hr.OrderedFields) != len(hr.Fields) {
+		return false
+	}
+	seen := make(map[string]bool, len(hr.OrderedFields))
+	for _, field := range hr.OrderedFields {
+		if _, ok := hr.Fields[field]; !ok || seen[field] {
+			return false
 		}
+		seen[field] = true
+	}
+	return true
+}
 
+// ScanFields returns up to count field, value pairs matching pattern.
+// The cursor is a position in SortedFields order, so every field is
+// returned exactly once over a full scan, no matter how it's split in calls.
+// The returned cursor is 0 when the scan is complete.
+func (hr *HashRecord) ScanFields(cursor int, count int, pattern string) (fields []string, last int, err error) {
+	if cursor < 0 {
+		return nil, 0, fmt.Errorf("cursor must be >= 0")
+	}
+	sorted := hr.SortedFields()
+	i := cursor
+	for ; i < len(sorted); i++ {
+		if len(fields) >= 2*count {
+			return fields, i, nil
+		}
+		key := sorted[i]
 		if pattern != "" && !match.Match(key, pattern) {
 			continue
 		}
-
-		fields = append(fields, key)
-		fields = append(fields, value)
-		if len(fields) >= 2*count {
-			stoppedEarly = true
-			break
-		}
+		fields = append(fields, key, hr.Fields[key])
 	}
-	if !stoppedEarly {
-		last = 0
-	}
-	return fields, last, nil
+	return fields, 0, nil
 }
 
 type ListRecord struct {
@@ -67,6 +84,91 @@ type OrderedSetRecord struct {
 	Elements []OrderedSetElement `json:"elements"`
 }
 
+// SortedElements returns a copy of the elements ordered the way Redis orders
+// a sorted set: by score, and by value (byte-wise) for equal scores.
+// Records files are not required to keep elements sorted.
+func (zr *OrderedSetRecord) SortedElements() []OrderedSetElement {
+	elements := make([]OrderedSetElement, len(zr.Elements))
+	copy(elements, zr.Elements)
+	sort.SliceStable(elements, func(i, j int) bool {
+		if elements[i].Score != elements[j].Score {
+			return elements[i].Score < elements[j].Score
+		}
+		return elements[i].Value < elements[j].Value
+	})
+	return elements
+}
+
+// Score returns the score of the value, and whether the value is a member
+func (zr *OrderedSetRecord) Score(value string) (score float64, ok bool) {
+	for _, element := range zr.Elements {
+		if element.Value == value {
+			return element.Score, true
+		}
+	}
+	return 0, false
+}
+
+type SetRecord struct {
+	Members []string `json:"members"`
+}
+
+// SortedMembers returns unique members in byte-wise order. The order is
+// stable between calls, which makes it usable for SSCAN cursors.
+func (sr *SetRecord) SortedMembers() []string {
+	members := make([]string, len(sr.Members))
+	copy(members, sr.Members)
+	sort.Strings(members)
+
+	unique := members[:0]
+	for i, member := range members {
+		if i > 0 && member == members[i-1] {
+			continue
+		}
+		unique = append(unique, member)
+	}
+	return unique
+}
+
+func (sr *SetRecord) IsMember(member string) bool {
+	for _, m := range sr.Members {
+		if m == member {
+			return true
+		}
+	}
+	return false
+}
+
+// Card returns the number of unique members, without sorting them
+func (sr *SetRecord) Card() int {
+	unique := make(map[string]struct{}, len(sr.Members))
+	for _, member := range sr.Members {
+		unique[member] = struct{}{}
+	}
+	return len(unique)
+}
+
+// ScanMembers returns up to count members matching pattern, starting at the
+// cursor position in SortedMembers order. The returned cursor is 0 when
+// the scan is complete.
+func (sr *SetRecord) ScanMembers(cursor int, count int, pattern string) (members []string, next int, err error) {
+	if cursor < 0 {
+		return nil, 0, fmt.Errorf("cursor must be >= 0")
+	}
+	sorted := sr.SortedMembers()
+	i := cursor
+	for ; i < len(sorted); i++ {
+		if len(members) >= count {
+			return members, i, nil
+		}
+		if pattern != "" && !match.Match(sorted[i], pattern) {
+			continue
+		}
+		members = append(members, sorted[i])
+	}
+	return members, 0, nil
+}
+
 // string enum
 const (
 	StringType string = "string"
@@ -74,6 +176,9 @@ const (
 	ListType          = "list"
 	SetType           = "set"
 	ZSetType          = "zset"
+	// TombstoneType marks a key as deleted, in a delta layer of a
+	// LayeredStore. A tombstone record has no value.
+	TombstoneType = "tombstone"
 )
 
 type Record struct {
@@ -84,6 +189,7 @@ type Record struct {
 	HashRecord      *HashRecord       `json:"hash_record,omitempty"`
 	ListRecord      *ListRecord       `json:"list_record,omitempty"`
 	OrdderSetRecord *OrderedSetRecord `json:"ordered_set_record,omitempty"`
+	SetRecord       *SetRecord        `json:"set_record,omitempty"`
 }
 
 func (r *Record) String() string {
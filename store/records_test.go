@@ -0,0 +1,63 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashScanFieldsVisitsEachFieldOnce(t *testing.T) {
+	hr := &HashRecord{Fields: map[string]string{}}
+	for i := 0; i < 1000; i++ {
+		hr.Fields[fmt.Sprintf("field%d", i)] = fmt.Sprintf("value%d", i)
+	}
+
+	seen := map[string]int{}
+	cursor := 0
+	for {
+		fields, next, err := hr.ScanFields(cursor, 7, "*")
+		assert.NoError(t, err)
+		for i := 0; i < len(fields); i += 2 {
+			seen[fields[i]]++
+			assert.Equal(t, hr.Fields[fields[i]], fields[i+1])
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	assert.Len(t, seen, len(hr.Fields))
+	for field, times := range seen {
+		assert.Equal(t, 1, times, field)
+	}
+}
+
+func TestHashSortedFields(t *testing.T) {
+	hr := &HashRecord{Fields: map[string]string{"b": "", "a": "", "c": ""}}
+	assert.Equal(t, []string{"a", "b", "c"}, hr.SortedFields())
+
+	hr.OrderedFields = []string{"c", "a", "b"}
+	assert.Equal(t, []string{"c", "a", "b"}, hr.SortedFields())
+
+	// stale ordered fields are ignored
+	hr.OrderedFields = []string{"c", "a", "x"}
+	assert.Equal(t, []string{"a", "b", "c"}, hr.SortedFields())
+
+	// so are duplicate ones, which would hide other fields
+	hr.OrderedFields = []string{"c", "a", "a"}
+	assert.Equal(t, []string{"a", "b", "c"}, hr.SortedFields())
+}
+
+func TestHashScanFieldsMatch(t *testing.T) {
+	hr := &HashRecord{Fields: map[string]string{"user:1": "a", "user:2": "b", "group:1": "c"}}
+
+	fields, cursor, err := hr.ScanFields(0, 10, "user:*")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, cursor)
+	assert.Equal(t, []string{"user:1", "a", "user:2", "b"}, fields)
+
+	_, _, err = hr.ScanFields(-1, 10, "*")
+	assert.Error(t, err)
+}
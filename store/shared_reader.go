@@ -0,0 +1,155 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ReadMode is how a store reads its records file
+type ReadMode string
+
+const (
+	// ReadModePool reads records with a pool of readers, each of them
+	// seeking to a record and reading it, one command at a time
+	ReadModePool ReadMode = ""
+	// ReadModePread reads records with ReadAt on a single reader, with as
+	// many reads in flight as the OS allows. Records sources that aren't an
+	// io.ReaderAt are read with a pool.
+	ReadModePread ReadMode = "pread"
+	// ReadModeMmap memory maps records files, where the platform allows
+	// that. Records sources that aren't files are read with a pool.
+	ReadModeMmap ReadMode = "mmap"
+)
+
+// ParseReadMode parses a ReadMode name, "pool" being ReadModePool
+func ParseReadMode(name string) (ReadMode, error) {
+	switch mode := ReadMode(name); mode {
+	case "pool":
+		return ReadModePool, nil
+	case ReadModePool, ReadModePread, ReadModeMmap:
+		return mode, nil
+	}
+	return ReadModePool, fmt.Errorf("unknown read mode %s", name)
+}
+
+// sharedReader serves concurrent reads of records from one io.ReaderAt,
+// an open file or a memory mapped one. It counts reads in flight, so that
+// it's only released once they are done.
+type sharedReader struct {
+	readerAt io.ReaderAt
+	release  func() error
+	// mapped is set for memory mapped records, which can't be released
+	// while they are read
+	mapped       bool
+	drainTimeout time.Duration
+
+	inFlight int64
+	closed   int32
+}
+
+// openSharedReader opens a shared reader of records for mode, nil if
+// mode reads with a pool or the records source doesn't allow another mode
+func openSharedReader(openReaderSeekCloser OpenReaderSeekCloser, mode ReadMode, drainTimeout time.Duration) (*sharedReader, error) {
+	if mode == ReadModePool {
+		return nil, nil
+	}
+	reader, err := openReaderSeekCloser()
+	if err != nil {
+		return nil, fmt.Errorf("error opening reader %w", err)
+	}
+
+	if file, ok := reader.(*os.File); ok && mode == ReadModeMmap {
+		data, unmap, err := mapFile(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &sharedReader{
+			readerAt: byteReaderAt(data),
+			release: func() error {
+				err := unmap(data)
+				if closeErr := file.Close(); err == nil {
+					err = closeErr
+				}
+				return err
+			},
+			mapped:       true,
+			drainTimeout: drainTimeout,
+		}, nil
+	}
+	if readerAt, ok := reader.(io.ReaderAt); ok {
+		return &sharedReader{readerAt: readerAt, release: reader.Close, drainTimeout: drainTimeout}, nil
+	}
+	return nil, reader.Close()
+}
+
+// acquire starts a read, ErrSecuringReaderPoolDrained is returned once
+// the reader is closed
+func (r *sharedReader) acquire() error {
+	atomic.AddInt64(&r.inFlight, 1)
+	if atomic.LoadInt32(&r.closed) == 1 {
+		r.done()
+		return ErrSecuringReaderPoolDrained
+	}
+	return nil
+}
+
+// done ends a read started with acquire
+func (r *sharedReader) done() {
+	atomic.AddInt64(&r.inFlight, -1)
+}
+
+// Close waits up to the drain timeout for reads in flight, and releases
+// the reader. A memory mapped file is left mapped if reads are still in
+// flight after that, as unmapping it would crash them.
+func (r *sharedReader) Close() error {
+	atomic.StoreInt32(&r.closed, 1)
+	deadline := time.Now().Add(r.drainTimeout)
+	for atomic.LoadInt64(&r.inFlight) > 0 {
+		if time.Now().After(deadline) {
+			if r.mapped {
+				return ErrTimedOutDrainingPool
+			}
+			r.release()
+			return ErrTimedOutDrainingPool
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return r.release()
+}
+
+// byteReaderAt reads memory mapped records
+type byteReaderAt []byte
+
+func (b byteReaderAt) ReadAt(p []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+	if offset >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n = copy(p, b[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// seekReaderAt reads at offsets of a reader from the pool
+type seekReaderAt struct {
+	reader io.ReadSeeker
+}
+
+func (s seekReaderAt) ReadAt(p []byte, offset int64) (n int, err error) {
+	if _, err := s.reader.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err = io.ReadFull(s.reader, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
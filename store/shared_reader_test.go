@@ -0,0 +1,117 @@
+package store
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReadMode(t *testing.T) {
+	for name, expected := range map[string]ReadMode{"": ReadModePool, "pool": ReadModePool, "pread": ReadModePread, "mmap": ReadModeMmap} {
+		mode, err := ParseReadMode(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, mode)
+	}
+	_, err := ParseReadMode("direct")
+	assert.Error(t, err)
+}
+
+func TestSharedReaderModes(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+	var compressed bytes.Buffer
+	compressedIndex, err := WriteCompressedRecords(bytes.NewReader(recordsBytes), &compressed, ZstdCompression, 256)
+	assert.NoError(t, err)
+
+	for _, mode := range []ReadMode{ReadModePread, ReadModeMmap} {
+		for name, recordsFileName := range map[string]string{
+			"plain":      writeRecordsFile(t, recordsBytes),
+			"compressed": writeRecordsFile(t, compressed.Bytes()),
+		} {
+			config := DefaultConfig()
+			config.ReadMode = mode
+			// reads don't go through the pool, it would time out at once
+			config.MaxConnections = 1
+			config.DefaultTimeout = time.Nanosecond
+
+			var store *Store
+			if name == "plain" {
+				store, err = NewStoreFromRecordsWithConfig(openFile(recordsFileName), config)
+			} else {
+				store, err = NewStoreFromRecordsWithIndexAndConfig(openFile(recordsFileName), jsonlIndex(t, compressedIndex), config)
+			}
+			assert.NoError(t, err, name)
+			assert.NotNil(t, store.shared, name)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for _, record := range records {
+						rec, err := store.GetRecord(record.Key)
+						assert.NoError(t, err, name)
+						assert.JSONEq(t, record.String(), rec.String(), name)
+					}
+				}()
+			}
+			wg.Wait()
+
+			report, err := store.Validate()
+			assert.NoError(t, err, name)
+			assert.True(t, report.OK(), name)
+
+			assert.NoError(t, store.Close())
+			_, err = store.GetRecord(records[0].Key)
+			assert.Equal(t, ErrSecuringReaderPoolDrained, err)
+		}
+	}
+}
+
+func TestSharedReaderFallsBackToPool(t *testing.T) {
+	config := DefaultConfig()
+	config.ReadMode = ReadModePread
+	store, err := NewStoreFromRecordsWithConfig(openBytes(MockJsonlBytes(MockRecords())), config)
+	assert.NoError(t, err)
+	assert.Nil(t, store.shared)
+	_, err = store.GetRecord("key3:list")
+	assert.NoError(t, err)
+}
+
+func TestSharedReaderWaitsForReads(t *testing.T) {
+	released := make(chan struct{})
+	reader := &sharedReader{
+		readerAt:     byteReaderAt("records"),
+		release:      func() error { close(released); return nil },
+		mapped:       true,
+		drainTimeout: time.Second,
+	}
+	assert.NoError(t, reader.acquire())
+
+	closed := make(chan error)
+	go func() { closed <- reader.Close() }()
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-released:
+		t.Fatal("released while a read is in flight")
+	default:
+	}
+	assert.Equal(t, ErrSecuringReaderPoolDrained, reader.acquire())
+
+	reader.done()
+	assert.NoError(t, <-closed)
+	<-released
+
+	// a mapped reader is left mapped when reads outlast the drain timeout
+	reader = &sharedReader{
+		readerAt:     byteReaderAt("records"),
+		release:      func() error { t.Fatal("released while a read is in flight"); return nil },
+		mapped:       true,
+		drainTimeout: time.Millisecond,
+	}
+	assert.NoError(t, reader.acquire())
+	assert.Equal(t, ErrTimedOutDrainingPool, reader.Close())
+}
@@ -1,34 +1,63 @@
 package store
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"time"
-
-	"github.com/tidwall/match"
 )
 
 type Store struct {
-	StoreIndex *StoreIndex
+	StoreIndex Index
 	readerPool *ReaderPool
+	// shared reads records without the pool, it's nil in ReadModePool
+	shared *sharedReader
+	// compression is detected from the first bytes of records when it's
+	// first needed, compressionMutex guards it
+	compressionMutex sync.Mutex
+	compression      Compression
+	compressionKnown bool
+	// cache is nil when caching is disabled
+	cache *recordCache
+	// bloom is nil when bloom filters are disabled
+	bloom *BloomFilter
 }
 
 var ErrKeyNotFound = errors.New("key not found")
 
 func (s *Store) GetLen() int {
-	return len(s.StoreIndex.SortedKeys)
+	return s.StoreIndex.Len()
 }
 
+// GetRecordIndex returns the index record of a key. Most missing keys are
+// answered by the bloom filter, without searching the index.
 func (s *Store) GetRecordIndex(key string) (*IndexRecord, error) {
-	indexRecord, ok := s.StoreIndex.Index[key]
+	indexRecord, ok := s.getIndexRecord(key)
 	if !ok {
 		return nil, ErrKeyNotFound
 	}
 	return &indexRecord, nil
 }
 
+// getIndexRecord looks a key up in the bloom filter, then in the index
+func (s *Store) getIndexRecord(key string) (IndexRecord, bool) {
+	if !s.bloom.MayContain(key) {
+		return IndexRecord{}, false
+	}
+	return s.StoreIndex.Get(key)
+}
+
+func (s *Store) GetType(key string) (string, error) {
+	indexRecord, ok := s.getIndexRecord(key)
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return indexRecord.Type, nil
+}
+
 type ErrReadingRecordFromDisk struct {
 	Err error
 }
@@ -37,76 +66,171 @@ func (e ErrReadingRecordFromDisk) Error() string {
 	return fmt.Sprintf("error reading record from disk: %s", e.Err.Error())
 }
 
-func (hr *Store) ScanFields(start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
-	if start < 0 {
-		return nil, 0, fmt.Errorf("start must be >= 0")
-	}
-	/*
-		// find the first key that greater than or equal to from with
-		// binary search in the hr.StoreIndex.Keys
-		// if from is nil, then start from the beginning
-		if from != nil {
-			start = sort.Search(len(hr.StoreIndex.SortedKeys), func(i int) bool {
-				return hr.StoreIndex.SortedKeys[i] >= *from
-			})
-			if start == len(hr.StoreIndex.SortedKeys) {
-				return []IndexRecord{}, nil // Finished scanning
-			}
-		}
-	*/
-	var i = start
-	for ; i < len(hr.StoreIndex.SortedKeys); i++ {
-		key := hr.StoreIndex.SortedKeys[i]
-
-		if pattern != "" && !match.Match(key, pattern) {
-			continue
-		}
+// ScanFields implements SCAN over the index
+func (s *Store) ScanFields(start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	return s.ScanFieldsContext(context.Background(), start, count, pattern)
+}
 
-		indexRecord, ok := hr.StoreIndex.Index[key]
-		if !ok {
-			return nil, 0, ErrKeyNotFound
-		}
+// ScanFieldsContext is ScanFields, stopping with the error of ctx once
+// it's done
+func (s *Store) ScanFieldsContext(ctx context.Context, start int, count int, pattern string) (records []IndexRecord, cursor int, err error) {
+	return scanIndex(ctx, s.StoreIndex, start, count, pattern)
+}
 
-		records = append(records, indexRecord)
+// GetRecord returns the record of a key. Records may come from the record
+// cache, and must not be modified.
+func (s *Store) GetRecord(key string) (record *Record, err error) {
+	return s.GetRecordContext(context.Background(), key)
+}
 
-		if len(records) >= count {
-			break
-		}
+// GetRecordContext is GetRecord, abandoning the read once ctx is done,
+// with the error of ctx
+func (s *Store) GetRecordContext(ctx context.Context, key string) (record *Record, err error) {
+	if record, ok := s.cache.get(key); ok {
+		return record, nil
 	}
-	cursor = i + 1
-	if cursor > len(hr.StoreIndex.SortedKeys)-1 {
-		cursor = 0
-	}
-	return records, cursor, nil
-}
 
-func (s *Store) GetRecord(key string) (record *Record, err error) {
 	// find record in s.StoreIndex first
-	indexRecord, ok := s.StoreIndex.Index[key]
+	indexRecord, ok := s.getIndexRecord(key)
 	if !ok {
 		return nil, ErrKeyNotFound
 	}
 
-	// get reader from pool
-	reader, err := s.readerPool.GetReader()
+	reader, release, err := s.getReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// an abandoned read goes on in the background, it mustn't write the
+	// results of the function
+	var read *Record
+	err = readContext(ctx, release, func() (err error) {
+		read, err = s.readRecord(reader, indexRecord)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer s.readerPool.ReturnReader(reader)
+	return read, nil
+}
+
+// GetRecords reads records for several keys with a single reader. Records
+// of missing keys are nil.
+func (s *Store) GetRecords(keys []string) (records []*Record, err error) {
+	return s.GetRecordsContext(context.Background(), keys)
+}
 
-	_, err = reader.Seek(indexRecord.Offset, io.SeekStart)
+// GetRecordsContext is GetRecords, abandoning the reads once ctx is done,
+// with the error of ctx
+func (s *Store) GetRecordsContext(ctx context.Context, keys []string) (records []*Record, err error) {
+	records = make([]*Record, len(keys))
+
+	// cached records are served right away, the others are read after
+	toRead := []int{}
+	indexRecords := make([]IndexRecord, len(keys))
+	for i, key := range keys {
+		if record, ok := s.cache.get(key); ok {
+			records[i] = record
+			continue
+		}
+		if indexRecord, ok := s.getIndexRecord(key); ok {
+			toRead = append(toRead, i)
+			indexRecords[i] = indexRecord
+		}
+	}
+	if len(toRead) == 0 {
+		return records, nil
+	}
+
+	reader, release, err := s.getReader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	read := make([]*Record, len(keys))
+	err = readContext(ctx, release, func() error {
+		for _, i := range toRead {
+			record, err := s.readRecord(reader, indexRecords[i])
+			if err != nil {
+				return err
+			}
+			read[i] = record
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	for _, i := range toRead {
+		records[i] = read[i]
+	}
+	return records, nil
+}
 
-	recordBytes := make([]byte, indexRecord.Len)
-	bytesRead, err := reader.Read(recordBytes)
+// contextReader is a reader of records whose reads can be bound to a
+// context, as the ones of an HTTPSource
+type contextReader interface {
+	withContext(ctx context.Context) io.ReaderAt
+}
+
+// getReader returns a reader of the records, and a function to call once
+// done with it. In ReadModePool the reader is one from the pool, waited
+// for until ctx is done, otherwise it's the shared one. Reads of readers
+// that support it are abandoned once ctx is done.
+func (s *Store) getReader(ctx context.Context) (reader io.ReaderAt, release func(), err error) {
+	if s.shared != nil {
+		if err := s.shared.acquire(); err != nil {
+			return nil, nil, err
+		}
+		reader = s.shared.readerAt
+		if contextReader, ok := reader.(contextReader); ok {
+			reader = contextReader.withContext(ctx)
+		}
+		return reader, s.shared.done, nil
+	}
+
+	poolReader, err := s.readerPool.GetReaderContext(ctx)
 	if err != nil {
-		return nil, ErrReadingRecordFromDisk{err}
+		return nil, nil, err
+	}
+	reader = seekReaderAt{poolReader}
+	if contextReader, ok := poolReader.(contextReader); ok {
+		reader = contextReader.withContext(ctx)
 	}
+	return reader, func() { s.readerPool.ReturnReader(poolReader) }, nil
+}
 
-	if bytesRead != indexRecord.Len {
-		return nil, ErrReadingRecordFromDisk{errors.New("not enough bytes read")}
+// readContext runs read, and returns the error of ctx if ctx is done
+// before read is. The read then goes on in the background, a stalled read
+// can't be interrupted, and release is only called once it's over, so
+// that the reader isn't used by another read in the meantime. Reads are
+// only run in the background when ctx has a deadline, otherwise they are
+// run right away, and ctx is only checked before.
+func readContext(ctx context.Context, release func(), read func() error) error {
+	if err := ctx.Err(); err != nil {
+		release()
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		defer release()
+		return read()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer release()
+		done <- read()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Store) readRecord(reader io.ReaderAt, indexRecord IndexRecord) (record *Record, err error) {
+	recordBytes, err := s.readRecordBytes(reader, indexRecord)
+	if err != nil {
+		return nil, err
 	}
 
 	record = &Record{}
@@ -115,8 +239,97 @@ func (s *Store) GetRecord(key string) (record *Record, err error) {
 		return nil, ErrReadingRecordFromDisk{err}
 	}
 
+	s.cache.add(indexRecord.Key, record, indexRecord.Len)
 	return record, nil
+}
+
+// readRecordBytes reads the jsonl line of a record
+func (s *Store) readRecordBytes(reader io.ReaderAt, indexRecord IndexRecord) (recordBytes []byte, err error) {
+	if indexRecord.IsCompressed() {
+		compression, err := s.recordsCompression(reader)
+		if err != nil {
+			return nil, ErrReadingRecordFromDisk{err}
+		}
+		recordBytes, err = readBlockRecord(reader, compression, indexRecord)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkRecordCRC(recordBytes, indexRecord); err != nil {
+			return nil, err
+		}
+		return recordBytes, nil
+	}
 
+	recordBytes = make([]byte, indexRecord.Len)
+	bytesRead, err := reader.ReadAt(recordBytes, indexRecord.Offset)
+	if bytesRead != indexRecord.Len {
+		if err == nil || err == io.EOF {
+			err = errors.New("not enough bytes read")
+		}
+		return nil, ErrReadingRecordFromDisk{err}
+	}
+
+	if err := checkRecordCRC(recordBytes, indexRecord); err != nil {
+		return nil, err
+	}
+	return recordBytes, nil
+}
+
+// checkRecordCRC checks a record line against the CRC of its index
+// record, if it has one
+func checkRecordCRC(recordBytes []byte, indexRecord IndexRecord) error {
+	if indexRecord.CRC != 0 && recordCRC(recordBytes) != indexRecord.CRC {
+		return ErrReadingRecordFromDisk{ErrRecordChecksum}
+	}
+	return nil
+}
+
+// CacheStats returns the counters of the record cache
+func (s *Store) CacheStats() CacheStats {
+	return s.cache.stats()
+}
+
+// ReaderPoolStats returns the counters of the reader pool, which are zero
+// when records are read without one
+func (s *Store) ReaderPoolStats() PoolStats {
+	return s.readerPool.Stats()
+}
+
+// Keys returns all keys matching the pattern, in sorted order
+func (s *Store) Keys(pattern string) []string {
+	keys, _ := s.KeysContext(context.Background(), pattern)
+	return keys
+}
+
+// KeysContext is Keys, stopping with the error of ctx once it's done
+func (s *Store) KeysContext(ctx context.Context, pattern string) ([]string, error) {
+	return indexKeys(ctx, s.StoreIndex, pattern)
+}
+
+// RandomKey returns a random key, ErrKeyNotFound if the store is empty
+func (s *Store) RandomKey() (string, error) {
+	return randomIndexKey(s.StoreIndex)
+}
+
+// Close drains the reader pool, or waits for reads of the shared reader,
+// and closes the index. Records can't be read afterwards, so a store that
+// is being swapped out should only be closed once commands that may still
+// hold it are done. Commands that outlive that get errors, memory mapped
+// files stay mapped until nothing references them.
+func (s *Store) Close() error {
+	err := s.readerPool.Drain()
+	if s.shared != nil {
+		if sharedErr := s.shared.Close(); err == nil {
+			err = sharedErr
+		}
+	}
+	if indexErr := s.StoreIndex.Close(); err == nil {
+		err = indexErr
+	}
+	if bloomErr := s.bloom.Close(); err == nil {
+		err = bloomErr
+	}
+	return err
 }
 
 type Config struct {
@@ -124,6 +337,43 @@ type Config struct {
 	DefaultTimeout      time.Duration
 	DrainTimeout        time.Duration
 	KeysDontNeedSorting bool
+	// MinConnections readers of the pool are opened on load and kept open,
+	// the others up to MaxConnections are opened when they are needed
+	MinConnections int
+	// IdleTimeout closes pool readers above MinConnections that weren't
+	// used for that long, 0 keeps them open
+	IdleTimeout time.Duration
+	// IndexBuildWorkers index records without an index file in parallel,
+	// as in BuildIndexParallel: 0 is one per core, 1 builds sequentially
+	IndexBuildWorkers int
+	// VerifyRecordsHash hashes the records file on load, to check it's the
+	// one its index was built from. Otherwise it's only hashed when its
+	// modification time changed, and remote records are never hashed.
+	VerifyRecordsHash bool
+	// RebuildMismatchedIndex builds the index from records when the index
+	// file was built from another records file, instead of failing
+	RebuildMismatchedIndex bool
+	// RecordCacheSize is how much memory decoded records can be cached in,
+	// 0 disables the cache
+	RecordCacheSize int64
+	// Validate makes loading fail with ErrInvalidStore when Store.Validate
+	// finds problems. It reads the whole records file.
+	Validate bool
+	// ReadMode is how records are read, the pool settings only apply to
+	// ReadModePool
+	ReadMode ReadMode
+	// BloomFalsePositiveRate enables a bloom filter of index keys, which
+	// answers lookups of most missing keys without searching the index. A
+	// bloom filter file is used if it was built from the index at this rate
+	// or a lower one. 0 disables it, otherwise it must be below 1.
+	BloomFalsePositiveRate float64
+	// BuildBloomFilter builds the filter from the index on load when there
+	// is no bloom filter file to use. It reads every key of the index, and
+	// the filter is held in memory instead of being mapped.
+	BuildBloomFilter bool
+	// HTTPSource is how records files given as URLs are fetched by their
+	// loaders
+	HTTPSource HTTPSourceConfig
 }
 
 // Opens a store w/o an index
@@ -136,18 +386,32 @@ func NewStoreFromRecordsWithConfig(openReaderSeekCloser OpenReaderSeekCloser, co
 	}
 	defer recordReader.Close()
 
-	storeIndex, err := BuildIndex(recordReader)
+	store.compression, err = sniffCompression(recordReader)
+	store.compressionKnown = true
+	if err != nil {
+		return nil, fmt.Errorf("error detecting records compression %w", err)
+	}
+
+	storeIndex, err := buildIndex(recordReader, config.IndexBuildWorkers)
 	if err != nil {
 		return nil, fmt.Errorf("error building index %w", err)
 	}
 	store.StoreIndex = storeIndex
+	store.bloom, err = loadBloomFilter(nil, storeIndex, config)
+	if err != nil {
+		storeIndex.Close()
+		return nil, err
+	}
 
-	store.readerPool, err = NewReaderPoolAdvanced(openReaderSeekCloser, config.MaxConnections, config.DefaultTimeout, config.DrainTimeout)
+	store.readerPool, store.shared, err = openReaders(openReaderSeekCloser, config)
 	if err != nil {
+		storeIndex.Close()
+		store.bloom.Close()
 		return nil, err
 	}
+	store.cache = newRecordCache(config.RecordCacheSize)
 
-	return store, nil
+	return store.validateIf(config.Validate)
 }
 
 func NewEmptyStore() *Store {
@@ -160,12 +424,19 @@ func NewEmptyStore() *Store {
 	}
 }
 
-func NewStoreFromRecords(openReaderSeekCloser OpenReaderSeekCloser) (store *Store, err error) {
-	return NewStoreFromRecordsWithConfig(openReaderSeekCloser, Config{
+// DefaultConfig is the config of stores opened without one
+func DefaultConfig() Config {
+	return Config{
 		MaxConnections: 100,
 		DefaultTimeout: 100 * time.Millisecond,
 		DrainTimeout:   1 * time.Second,
-	})
+		IdleTimeout:    1 * time.Minute,
+		HTTPSource:     DefaultHTTPSourceConfig(),
+	}
+}
+
+func NewStoreFromRecords(openReaderSeekCloser OpenReaderSeekCloser) (store *Store, err error) {
+	return NewStoreFromRecordsWithConfig(openReaderSeekCloser, DefaultConfig())
 }
 
 type ErrReadingIndex struct {
@@ -181,29 +452,147 @@ type ErrCreatingPool struct {
 }
 
 func (e *ErrCreatingPool) Error() string {
-	return fmt.Sprintf("error reading index %s", e.Err)
+	return fmt.Sprintf("error creating pool %s", e.Err)
 }
 
+// NewStoreFromRecordsWithIndexAndConfig opens a store with an index. An
+// index with a header must have been built from these records, otherwise
+// ErrIndexMismatch is returned, or the index is rebuilt from records if
+// config.RebuildMismatchedIndex is set.
 func NewStoreFromRecordsWithIndexAndConfig(openReaderSeekCloser OpenReaderSeekCloser, index io.Reader, config Config) (store *Store, err error) {
+	return NewStoreFromRecordsWithIndexAndBloomFilter(openReaderSeekCloser, index, nil, config)
+}
+
+// NewStoreFromRecordsWithIndexAndBloomFilter opens a store with an index
+// and the bloom filter file built with it, which is nil if there is none.
+// The filter is only used if it matches the index and
+// config.BloomFalsePositiveRate, otherwise one is built with
+// config.BuildBloomFilter.
+func NewStoreFromRecordsWithIndexAndBloomFilter(openReaderSeekCloser OpenReaderSeekCloser, index io.Reader, bloomFilter io.Reader, config Config) (store *Store, err error) {
 	store = &Store{}
 
-	storeIndex, err := ReadJsonlIndex(index, config.KeysDontNeedSorting)
+	storeIndex, err := ReadIndex(index, config.KeysDontNeedSorting)
 	if err != nil {
 		return nil, &ErrReadingIndex{err}
 	}
-	store.StoreIndex = storeIndex
-	store.readerPool, err = NewReaderPoolAdvanced(openReaderSeekCloser, config.MaxConnections, config.DefaultTimeout, config.DrainTimeout)
+	store.StoreIndex, err = checkRecords(openReaderSeekCloser, storeIndex, config)
+	if err != nil {
+		storeIndex.Close()
+		return nil, err
+	}
+	var filter *BloomFilter
+	if bloomFilter != nil && config.BloomFalsePositiveRate > 0 {
+		filter, err = ReadBloomFilter(bloomFilter)
+		if err != nil {
+			store.StoreIndex.Close()
+			return nil, fmt.Errorf("error reading bloom filter %w", err)
+		}
+	}
+	store.bloom, err = loadBloomFilter(filter, store.StoreIndex, config)
 	if err != nil {
+		store.StoreIndex.Close()
+		return nil, err
+	}
+
+	store.readerPool, store.shared, err = openReaders(openReaderSeekCloser, config)
+	if err != nil {
+		store.StoreIndex.Close()
+		store.bloom.Close()
 		return nil, &ErrCreatingPool{err}
 	}
-	return store, nil
+	store.cache = newRecordCache(config.RecordCacheSize)
+	return store.validateIf(config.Validate)
 }
 
-func NewStoreFromRecordsWithIndex(openReaderSeekCloser OpenReaderSeekCloser, index io.Reader) (store *Store, err error) {
-	return NewStoreFromRecordsWithIndexAndConfig(openReaderSeekCloser, index, Config{
-		MaxConnections:      100,
-		DefaultTimeout:      100 * time.Millisecond,
-		DrainTimeout:        1 * time.Second,
-		KeysDontNeedSorting: true,
+// openReaders opens the readers of records for config.ReadMode: a shared
+// reader with an empty pool, or a pool when the mode is ReadModePool or
+// the records source can't be read otherwise
+func openReaders(openReaderSeekCloser OpenReaderSeekCloser, config Config) (*ReaderPool, *sharedReader, error) {
+	shared, err := openSharedReader(openReaderSeekCloser, config.ReadMode, config.DrainTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	if shared != nil {
+		return NewEmptyReaderPool(), shared, nil
+	}
+	readerPool, err := NewLazyReaderPool(openReaderSeekCloser, ReaderPoolConfig{
+		MinSize:        config.MinConnections,
+		MaxSize:        config.MaxConnections,
+		DefaultTimeout: config.DefaultTimeout,
+		DrainTimeout:   config.DrainTimeout,
+		IdleTimeout:    config.IdleTimeout,
 	})
+	return readerPool, nil, err
+}
+
+// validateIf validates a newly loaded store if validate is set, and
+// closes it if it's invalid
+func (s *Store) validateIf(validate bool) (*Store, error) {
+	if !validate {
+		return s, nil
+	}
+	report, err := s.Validate()
+	if err == nil && !report.OK() {
+		err = &ErrInvalidStore{report}
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkRecords verifies that the index was built from records. It returns
+// the index to serve the records with, which is rebuilt if it doesn't
+// match and config allows that.
+func checkRecords(openReaderSeekCloser OpenReaderSeekCloser, index Index, config Config) (Index, error) {
+	headerIndex, ok := index.(headerIndex)
+	if !ok || headerIndex.GetHeader() == nil {
+		return index, nil
+	}
+	recordReader, err := openReaderSeekCloser()
+	if err != nil {
+		return nil, err
+	}
+	defer recordReader.Close()
+
+	err = verifyRecords(recordReader, headerIndex.GetHeader(), config.VerifyRecordsHash)
+	var mismatch *ErrIndexMismatch
+	if err == nil || !errors.As(err, &mismatch) || !config.RebuildMismatchedIndex {
+		return index, err
+	}
+
+	if _, err := recordReader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	rebuilt, err := buildIndex(recordReader, config.IndexBuildWorkers)
+	if err != nil {
+		return nil, fmt.Errorf("error rebuilding index %w", err)
+	}
+	index.Close()
+	return rebuilt, nil
+}
+
+// recordsCompression returns the compression of records, detecting it
+// from their first bytes the first time. It isn't detected on load, so
+// that loading remote records doesn't fetch them.
+func (s *Store) recordsCompression(reader io.ReaderAt) (Compression, error) {
+	s.compressionMutex.Lock()
+	defer s.compressionMutex.Unlock()
+	if s.compressionKnown {
+		return s.compression, nil
+	}
+	head := make([]byte, len(zstdMagic))
+	n, err := reader.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return NoCompression, fmt.Errorf("error detecting records compression %w", err)
+	}
+	s.compression, s.compressionKnown = detectCompression(head[:n]), true
+	return s.compression, nil
+}
+
+func NewStoreFromRecordsWithIndex(openReaderSeekCloser OpenReaderSeekCloser, index io.Reader) (store *Store, err error) {
+	config := DefaultConfig()
+	config.KeysDontNeedSorting = true
+	return NewStoreFromRecordsWithIndexAndConfig(openReaderSeekCloser, index, config)
 }
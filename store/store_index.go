@@ -1,7 +1,7 @@
 package store
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
@@ -18,17 +18,109 @@ type IndexRecord struct {
 	Offset int64  `json:"offset"`
 	Len    int    `json:"len"`
 	Type   string `json:"type"`
+
+	// Where the block holding the record is, in compressed records files.
+	// Offset is relative to the decompressed block then.
+	BlockOffset int64 `json:"block_offset,omitempty"`
+	BlockLen    int   `json:"block_len,omitempty"`
+
+	// Where a string value is, relative to Offset. Only set for string
+	// records whose value is stored unescaped, 0 otherwise.
+	ValueOffset int `json:"value_offset,omitempty"`
+	ValueLen    int `json:"value_len,omitempty"`
+
+	// CRC of the record line, 0 when the index doesn't have one
+	CRC uint32 `json:"crc,omitempty"`
+}
+
+// Index is a read only index of a records file, ordered by key
+type Index interface {
+	Len() int
+	// Get returns the index record of a key
+	Get(key string) (IndexRecord, bool)
+	// At returns the i-th index record in key order
+	At(i int) IndexRecord
+	// KeyAt returns the i-th key
+	KeyAt(i int) string
+	// Search returns the position of the first key >= key
+	Search(key string) int
+	// Close releases resources held by the index
+	Close() error
 }
 
+// StoreIndex is an in-memory Index
 type StoreIndex struct {
 	SortedKeys []string
 	Index      map[string]IndexRecord
+	// Header is written with the index, and read back from index files
+	// that have one
+	Header *IndexHeader
+}
+
+// IsCompressed is true for records of block compressed records files
+func (ir *IndexRecord) IsCompressed() bool {
+	return ir.BlockLen > 0
+}
+
+func newStoreIndex() *StoreIndex {
+	return &StoreIndex{Index: make(map[string]IndexRecord)}
+}
+
+func (s *StoreIndex) Len() int {
+	return len(s.SortedKeys)
+}
+
+func (s *StoreIndex) Get(key string) (IndexRecord, bool) {
+	indexRecord, ok := s.Index[key]
+	return indexRecord, ok
+}
+
+func (s *StoreIndex) At(i int) IndexRecord {
+	return s.Index[s.SortedKeys[i]]
+}
+
+func (s *StoreIndex) KeyAt(i int) string {
+	return s.SortedKeys[i]
+}
+
+func (s *StoreIndex) Search(key string) int {
+	return sort.SearchStrings(s.SortedKeys, key)
+}
+
+func (s *StoreIndex) Close() error {
+	return nil
+}
+
+func (s *StoreIndex) GetHeader() *IndexHeader {
+	return s.Header
+}
+
+// add indexes a record, a duplicate key is indexed at its last record
+func (s *StoreIndex) add(indexRecord IndexRecord) {
+	if _, ok := s.Index[indexRecord.Key]; !ok {
+		s.SortedKeys = append(s.SortedKeys, indexRecord.Key)
+	}
+	s.Index[indexRecord.Key] = indexRecord
+}
+
+func (s *StoreIndex) sortKeys() {
+	sort.Strings(s.SortedKeys)
 }
 
 var ErrIndexKeySerialization = errors.New("during index serialization key not found in index")
 
+// jsonlIndexHeader is the first line of a jsonl index with a header
+type jsonlIndexHeader struct {
+	Header *IndexHeader `json:"index_header"`
+}
+
 func (s *StoreIndex) WriteJsonl(out io.Writer) (err error) {
 	encoder := json.NewEncoder(out)
+	if s.Header != nil {
+		if err := encoder.Encode(jsonlIndexHeader{s.Header}); err != nil {
+			return err
+		}
+	}
 	for _, key := range s.SortedKeys {
 		indexRecord, ok := s.Index[key]
 		if !ok {
@@ -40,22 +132,40 @@ func (s *StoreIndex) WriteJsonl(out io.Writer) (err error) {
 }
 
 func ReadJsonlIndex(in io.Reader, keysDontNeedSorting bool) (store *StoreIndex, err error) {
-	scanner := bufio.NewScanner(in)
+	lines := newLineReader(in)
 	store = &StoreIndex{}
 	store.Index = make(map[string]IndexRecord)
-	for scanner.Scan() {
-		var indexRecord IndexRecord
-		err = json.Unmarshal(scanner.Bytes(), &indexRecord)
+	for {
+		line, offset, err := lines.next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
 			return store, err
 		}
-		store.Index[indexRecord.Key] = indexRecord
-		store.SortedKeys = append(store.SortedKeys, indexRecord.Key)
+		if len(line) == 0 {
+			continue
+		}
+		// only the first line can be a header
+		if offset == 0 && bytes.HasPrefix(line, []byte(`{"index_header"`)) {
+			var header jsonlIndexHeader
+			if err := json.Unmarshal(line, &header); err != nil {
+				return store, lines.wrap(err, offset)
+			}
+			store.Header = header.Header
+			continue
+		}
+		var indexRecord IndexRecord
+		err = json.Unmarshal(line, &indexRecord)
+		if err != nil {
+			return store, lines.wrap(err, offset)
+		}
+		store.add(indexRecord)
 	}
 
 	if !keysDontNeedSorting {
 		sort.Strings(store.SortedKeys)
 	}
 
-	return store, err
+	return store, nil
 }
@@ -2,8 +2,12 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -98,3 +102,147 @@ func TestReadingIndex(t *testing.T) {
 		assert.Equal(t, record.Type, idx.Type)
 	}
 }
+
+func TestGetRecords(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+
+	opened := 0
+	store, err := NewStoreFromRecordsWithConfig(func() (io.ReadSeekCloser, error) {
+		opened++
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes)), nil
+	}, Config{MaxConnections: 1, DefaultTimeout: time.Millisecond, DrainTimeout: time.Millisecond})
+	assert.NoError(t, err)
+
+	// a single reader is enough for the whole batch
+	got, err := store.GetRecords([]string{records[0].Key, "nosuchkey", records[3].Key})
+	assert.NoError(t, err)
+	assert.Len(t, got, 3)
+	assert.JSONEq(t, records[0].String(), got[0].String())
+	assert.Nil(t, got[1])
+	assert.JSONEq(t, records[3].String(), got[2].String())
+	assert.Equal(t, 2, opened) // one to build the index, one in the pool
+}
+
+func TestClose(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+
+	store, err := NewStoreFromRecordsWithConfig(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes)), nil
+	}, Config{MaxConnections: 2, DefaultTimeout: time.Millisecond, DrainTimeout: time.Millisecond})
+	assert.NoError(t, err)
+
+	_, err = store.GetRecord(records[0].Key)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Close())
+	_, err = store.GetRecord(records[0].Key)
+	assert.Equal(t, ErrSecuringReaderPoolDrained, err)
+}
+
+// stallingReader blocks reads while stalled is set, until unstall is closed
+type stallingReader struct {
+	io.ReadSeekCloser
+	stalled *int32
+	unstall chan struct{}
+}
+
+func (s stallingReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(s.stalled) == 1 {
+		<-s.unstall
+	}
+	return s.ReadSeekCloser.Read(p)
+}
+
+func TestGetRecordContext(t *testing.T) {
+	records := MockRecords()
+	recordsBytes := MockJsonlBytes(records)
+	stalled := int32(0)
+	unstall := make(chan struct{})
+	store, err := NewStoreFromRecordsWithConfig(func() (io.ReadSeekCloser, error) {
+		return stallingReader{NewReadSeekCloser(bytes.NewReader(recordsBytes)), &stalled, unstall}, nil
+	}, Config{MaxConnections: 1, DefaultTimeout: time.Second, DrainTimeout: time.Second})
+	assert.NoError(t, err)
+
+	record, err := store.GetRecordContext(context.Background(), records[0].Key)
+	assert.NoError(t, err)
+	assert.JSONEq(t, records[0].String(), record.String())
+
+	// a stalled read is abandoned at the deadline
+	atomic.StoreInt32(&stalled, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = store.GetRecordContext(ctx, records[1].Key)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, err = store.GetRecordsContext(ctx, []string{records[1].Key})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// its reader is still in use, waiting for it ends with the context
+	assert.Equal(t, int64(1), store.ReaderPoolStats().InUse)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = store.GetRecordContext(ctx, records[2].Key)
+	assert.Equal(t, context.Canceled, err)
+
+	// and returned to the pool once the read is over
+	atomic.StoreInt32(&stalled, 0)
+	close(unstall)
+	assert.Eventually(t, func() bool {
+		return store.ReaderPoolStats().InUse == 0
+	}, time.Second, time.Millisecond)
+	got, err := store.GetRecordsContext(context.Background(), []string{records[1].Key, "nosuchkey", records[2].Key})
+	assert.NoError(t, err)
+	assert.JSONEq(t, records[1].String(), got[0].String())
+	assert.Nil(t, got[1])
+	assert.JSONEq(t, records[2].String(), got[2].String())
+}
+
+func TestReadContextWithoutDeadline(t *testing.T) {
+	// without a deadline the read is run right away, and not abandoned
+	ctx, cancel := context.WithCancel(context.Background())
+	released := false
+	err := readContext(ctx, func() { released = true }, func() error {
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, released)
+
+	err = readContext(ctx, func() {}, func() error { return nil })
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestScanContext(t *testing.T) {
+	store, err := NewStoreFromRecords(openBytes(MockJsonlBytes(MockRecords())))
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, err = store.ScanFieldsContext(ctx, 0, 10, "*")
+	assert.Equal(t, context.Canceled, err)
+	_, err = store.KeysContext(ctx, "*")
+	assert.Equal(t, context.Canceled, err)
+	_, err = store.GetStringSliceContext(ctx, "key0:string", 1, 4)
+	assert.Equal(t, context.Canceled, err)
+
+	keys, err := store.KeysContext(context.Background(), "*")
+	assert.NoError(t, err)
+	assert.Equal(t, store.Keys("*"), keys)
+}
+
+func TestCreatingPoolError(t *testing.T) {
+	index, err := BuildIndex(bytes.NewReader(MockJsonlBytes(MockRecords())))
+	assert.NoError(t, err)
+
+	// readers opened on load can't be opened
+	config := DefaultConfig()
+	config.MinConnections = 1
+	_, err = NewStoreFromRecordsWithIndexAndConfig(func() (io.ReadSeekCloser, error) {
+		return nil, errors.New("can't open records")
+	}, jsonlIndex(t, index), config)
+	var creatingPool *ErrCreatingPool
+	assert.True(t, errors.As(err, &creatingPool))
+	assert.Equal(t, "error creating pool error opening reader can't open records", err.Error())
+}
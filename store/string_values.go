@@ -0,0 +1,230 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrWrongType = errors.New("wrong type")
+
+// stringValueSpan locates the raw bytes of string_record.value in a records
+// line. ok is false when the value can't be served raw: when it's escaped in
+// json, or isn't valid utf-8, its bytes on disk differ from the decoded value.
+// Like json.Unmarshal, it takes the last value of duplicate fields, leaves
+// the value as it is on a null, and forgets it on a null string_record. A
+// field name that json.Unmarshal only matches case insensitively, or a value
+// that isn't a string, isn't served raw either.
+func stringValueSpan(line []byte) (offset int, length int, ok bool) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	if !enterObject(decoder) {
+		return 0, 0, false
+	}
+	found := false
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return 0, 0, false
+		}
+		name, _ := key.(string)
+		if !strings.EqualFold(name, "string_record") {
+			if !skipValue(decoder) {
+				return 0, 0, false
+			}
+			continue
+		}
+		if name != "string_record" {
+			return 0, 0, false
+		}
+
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, 0, false
+		}
+		if token == nil {
+			found = false
+			continue
+		}
+		if token != json.Delim('{') {
+			return 0, 0, false
+		}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return 0, 0, false
+			}
+			name, _ := key.(string)
+			if !strings.EqualFold(name, "value") {
+				if !skipValue(decoder) {
+					return 0, 0, false
+				}
+				continue
+			}
+			if name != "value" {
+				return 0, 0, false
+			}
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return 0, 0, false
+			}
+			if string(raw) == "null" {
+				continue
+			}
+			if raw[0] != '"' {
+				return 0, 0, false
+			}
+			end := int(decoder.InputOffset())
+			offset, length, ok = rawStringSpan(line, end-len(raw), end)
+			found = true
+		}
+		// the end of string_record
+		if _, err := decoder.Token(); err != nil {
+			return 0, 0, false
+		}
+	}
+	if !found || !ok {
+		return 0, 0, false
+	}
+	return offset, length, true
+}
+
+func enterObject(decoder *json.Decoder) bool {
+	token, err := decoder.Token()
+	return err == nil && token == json.Delim('{')
+}
+
+func skipValue(decoder *json.Decoder) bool {
+	var skipped json.RawMessage
+	return decoder.Decode(&skipped) == nil
+}
+
+// rawStringSpan returns the span of the contents of the json string
+// between start and end, ok is false if they differ from the decoded string
+func rawStringSpan(line []byte, start int, end int) (offset int, length int, ok bool) {
+	offset, length = start+1, end-start-2
+	raw := line[offset : offset+length]
+	return offset, length, bytes.IndexByte(raw, '\\') < 0 && utf8.Valid(raw)
+}
+
+// GetStringLen returns the length of a string value in bytes. When the index
+// knows where the value is, the records file is not touched at all.
+func (s *Store) GetStringLen(key string) (length int, err error) {
+	return s.GetStringLenContext(context.Background(), key)
+}
+
+// GetStringLenContext is GetStringLen, abandoning the read once ctx is
+// done, with the error of ctx
+func (s *Store) GetStringLenContext(ctx context.Context, key string) (length int, err error) {
+	indexRecord, err := s.GetRecordIndex(key)
+	if err != nil {
+		return 0, err
+	}
+	if indexRecord.Type != StringType {
+		return 0, ErrWrongType
+	}
+	if indexRecord.ValueOffset > 0 {
+		return indexRecord.ValueLen, nil
+	}
+
+	record, err := s.GetRecordContext(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if record.StringRecord == nil {
+		return 0, nil
+	}
+	return len(record.StringRecord.Value), nil
+}
+
+// GetStringSlice returns bytes [from, to) of a string value, bounds past
+// the value are cut to it. When the index knows where the value is, and the
+// records file is not compressed, only those bytes are read, or the line
+// of the record without decoding it when the index has its CRC.
+func (s *Store) GetStringSlice(key string, from int, to int) (value string, err error) {
+	return s.GetStringSliceContext(context.Background(), key, from, to)
+}
+
+// GetStringSliceContext is GetStringSlice, abandoning the read once ctx is
+// done, with the error of ctx
+func (s *Store) GetStringSliceContext(ctx context.Context, key string, from int, to int) (value string, err error) {
+	indexRecord, err := s.GetRecordIndex(key)
+	if err != nil {
+		return "", err
+	}
+	if indexRecord.Type != StringType {
+		return "", ErrWrongType
+	}
+
+	if indexRecord.ValueOffset == 0 || indexRecord.IsCompressed() {
+		record, err := s.GetRecordContext(ctx, key)
+		if err != nil {
+			return "", err
+		}
+		if record.StringRecord == nil {
+			return "", nil
+		}
+		return sliceString(record.StringRecord.Value, from, to), nil
+	}
+	from, to = clampRange(from, to, indexRecord.ValueLen)
+	if from == to {
+		return "", nil
+	}
+
+	reader, release, err := s.getReader(ctx)
+	if err != nil {
+		return "", err
+	}
+	// like in GetRecordContext, an abandoned read mustn't write the results
+	var read string
+	err = readContext(ctx, release, func() (err error) {
+		read, err = readStringSlice(s, reader, *indexRecord, from, to)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return read, nil
+}
+
+// clampRange cuts [from, to) to a value of length bytes
+func clampRange(from int, to int, length int) (int, int) {
+	if to > length {
+		to = length
+	}
+	if from > to {
+		from = to
+	}
+	return from, to
+}
+
+// sliceString returns bytes [from, to) of value, cut to it
+func sliceString(value string, from int, to int) string {
+	from, to = clampRange(from, to, len(value))
+	return value[from:to]
+}
+
+// readStringSlice reads bytes [from, to) of the value of a string record,
+// from the line of the record if the index has its CRC, to check it
+func readStringSlice(s *Store, reader io.ReaderAt, indexRecord IndexRecord, from int, to int) (string, error) {
+	start, end := indexRecord.ValueOffset+from, indexRecord.ValueOffset+to
+	if indexRecord.CRC != 0 {
+		recordBytes, err := s.readRecordBytes(reader, indexRecord)
+		if err != nil {
+			return "", err
+		}
+		if end > len(recordBytes) {
+			return "", ErrReadingRecordFromDisk{errors.New("value is out of its record")}
+		}
+		return string(recordBytes[start:end]), nil
+	}
+
+	valueBytes := make([]byte, to-from)
+	if bytesRead, err := reader.ReadAt(valueBytes, indexRecord.Offset+int64(start)); bytesRead != len(valueBytes) {
+		return "", ErrReadingRecordFromDisk{err}
+	}
+	return string(valueBytes), nil
+}
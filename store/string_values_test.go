@@ -0,0 +1,110 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringValueSpan(t *testing.T) {
+	line := []byte(`{"key":"value","type":"string","string_record":{"value":"héllo"}}`)
+	offset, length, ok := stringValueSpan(line)
+	assert.True(t, ok)
+	assert.Equal(t, "héllo", string(line[offset:offset+length]))
+
+	line = []byte(`{"string_record": {"other": 1, "value" : ""}, "key":"k"}`)
+	offset, length, ok = stringValueSpan(line)
+	assert.True(t, ok)
+	assert.Equal(t, 0, length)
+	assert.Equal(t, byte('"'), line[offset])
+
+	_, _, ok = stringValueSpan([]byte(`{"key":"k","string_record":{"value":"a\"b"}}`))
+	assert.False(t, ok)
+
+	_, _, ok = stringValueSpan([]byte(`{"key":"k","hash_record":{"fields":{"value":"x"}}}`))
+	assert.False(t, ok)
+}
+
+func TestStringValueSpanNotAString(t *testing.T) {
+	// a null value decodes as an empty string, the next string isn't it
+	_, _, ok := stringValueSpan([]byte(`{"string_record":{"value":null},"key":"k","type":"string"}`))
+	assert.False(t, ok)
+	_, _, ok = stringValueSpan([]byte(`{"string_record":{"value":5,"other":"x"},"key":"k","type":"string"}`))
+	assert.False(t, ok)
+	_, _, ok = stringValueSpan([]byte(`{"string_record":{"Value":"x"},"key":"k","type":"string"}`))
+	assert.False(t, ok)
+	_, _, ok = stringValueSpan([]byte(`{"string_record":{"value":"x"},"string_record":null,"key":"k","type":"string"}`))
+	assert.False(t, ok)
+
+	// like json.Unmarshal, the last value wins, and a null keeps the previous one
+	for _, line := range []string{
+		`{"string_record":{"value":"first","value":"last"},"key":"k","type":"string"}`,
+		`{"string_record":{"value":"first"},"string_record":{"value":"last"},"key":"k","type":"string"}`,
+		`{"string_record":{"value":"last"},"string_record":{"value":null,"other":"x"},"key":"k","type":"string"}`,
+	} {
+		offset, length, ok := stringValueSpan([]byte(line))
+		assert.True(t, ok, line)
+		assert.Equal(t, "last", line[offset:offset+length], line)
+
+		var record Record
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		assert.Equal(t, "last", record.StringRecord.Value, line)
+	}
+}
+
+func TestNullStringValue(t *testing.T) {
+	recordsBytes := []byte(`{"string_record":{"value":null},"key":"k","type":"string"}` + "\n")
+	store, err := NewStoreFromRecords(openBytes(recordsBytes))
+	assert.NoError(t, err)
+
+	length, err := store.GetStringLen("k")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, length)
+	value, err := store.GetStringSlice("k", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "", value)
+}
+
+func TestGetStringSlice(t *testing.T) {
+	records := []Record{
+		{Key: "raw", Type: StringType, StringRecord: &StringRecord{Value: "Hello, world"}},
+		{Key: "escaped", Type: StringType, StringRecord: &StringRecord{Value: "<Hello>\n"}},
+		{Key: "hash", Type: HashType, HashRecord: &HashRecord{Fields: map[string]string{}}},
+	}
+	recordsBytes := MockJsonlBytes(records)
+
+	store, err := NewStoreFromRecords(func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes)), nil
+	})
+	assert.NoError(t, err)
+
+	raw, _ := store.GetRecordIndex("raw")
+	assert.NotZero(t, raw.ValueOffset)
+	escaped, _ := store.GetRecordIndex("escaped")
+	assert.Zero(t, escaped.ValueOffset)
+
+	length, err := store.GetStringLen("raw")
+	assert.NoError(t, err)
+	assert.Equal(t, 12, length)
+
+	length, err = store.GetStringLen("escaped")
+	assert.NoError(t, err)
+	assert.Equal(t, 8, length)
+
+	value, err := store.GetStringSlice("raw", 7, 12)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", value)
+
+	value, err = store.GetStringSlice("escaped", 0, 7)
+	assert.NoError(t, err)
+	assert.Equal(t, "<Hello>", value)
+
+	_, err = store.GetStringLen("hash")
+	assert.Equal(t, ErrWrongType, err)
+
+	_, err = store.GetStringLen("nosuchkey")
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ValidationProblem is something wrong with a record, or with its index
+// record
+type ValidationProblem struct {
+	Key string
+	// Line and Offset are where the record is in the records file, in the
+	// decompressed records of a compressed one. Line is 0 for problems
+	// found in the index.
+	Line    int
+	Offset  int64
+	Problem string
+}
+
+func (p ValidationProblem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("index record of key %q at offset %d: %s", p.Key, p.Offset, p.Problem)
+	}
+	return fmt.Sprintf("line %d at offset %d, key %q: %s", p.Line, p.Offset, p.Key, p.Problem)
+}
+
+// ValidationReport lists the problems found by Store.Validate
+type ValidationReport struct {
+	Records  int
+	Problems []ValidationProblem
+}
+
+func (r *ValidationReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *ValidationReport) add(key string, line int, offset int64, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, ValidationProblem{
+		Key:     key,
+		Line:    line,
+		Offset:  offset,
+		Problem: fmt.Sprintf(format, args...),
+	})
+}
+
+// ErrInvalidStore is returned when loading a store that fails validation
+type ErrInvalidStore struct {
+	Report *ValidationReport
+}
+
+// maxReportedProblems is how many problems ErrInvalidStore lists
+const maxReportedProblems = 5
+
+func (e *ErrInvalidStore) Error() string {
+	problems := []string{}
+	for i, problem := range e.Report.Problems {
+		if i == maxReportedProblems {
+			problems = append(problems, "...")
+			break
+		}
+		problems = append(problems, problem.String())
+	}
+	return fmt.Sprintf("store failed validation with %d problem(s): %s", len(e.Report.Problems), strings.Join(problems, "; "))
+}
+
+// Validate checks the records file and the index of a store. It reports
+// records that don't decode, duplicate keys, records whose payload doesn't
+// match their type, and index records that don't point at a record with
+// the same key and type. It reads the whole records file, so it's meant
+// for offline checks and for loading stores that must be valid.
+func (s *Store) Validate() (*ValidationReport, error) {
+	reader, release, err := s.getReader(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	report := &ValidationReport{}
+	if err := s.validateRecords(reader, report); err != nil {
+		return nil, err
+	}
+	s.validateIndex(reader, report)
+	return report, nil
+}
+
+// validateRecords checks every line of the records file
+func (s *Store) validateRecords(reader io.ReaderAt, report *ValidationReport) error {
+	compression, err := s.recordsCompression(reader)
+	if err != nil {
+		return err
+	}
+	records, err := decompressingReader(io.NewSectionReader(reader, 0, math.MaxInt64), compression)
+	if err != nil {
+		return err
+	}
+	defer records.Close()
+
+	seen := map[string]int{}
+	lines := newLineReader(records)
+	for {
+		line, offset, err := lines.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		lineNumber := lines.line - 1
+		report.Records++
+
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			report.add("", lineNumber, offset, "record doesn't decode: %s", err)
+			continue
+		}
+		if first, ok := seen[record.Key]; ok {
+			report.add(record.Key, lineNumber, offset, "duplicate key, first seen on line %d", first)
+		} else {
+			seen[record.Key] = lineNumber
+		}
+		if problem := record.payloadProblem(); problem != "" {
+			report.add(record.Key, lineNumber, offset, "%s", problem)
+		}
+		if _, ok := s.StoreIndex.Get(record.Key); !ok {
+			report.add(record.Key, lineNumber, offset, "key is missing from the index")
+		}
+	}
+}
+
+// validateIndex checks that index keys are sorted, unique and in the bloom
+// filter, and that every index record points at its record
+func (s *Store) validateIndex(reader io.ReaderAt, report *ValidationReport) {
+	for i := 0; i < s.StoreIndex.Len(); i++ {
+		indexRecord := s.StoreIndex.At(i)
+		key := s.StoreIndex.KeyAt(i)
+		if i > 0 && s.StoreIndex.KeyAt(i-1) >= key {
+			report.add(key, 0, indexRecord.Offset, "key is out of order or duplicate in the index")
+		}
+		if indexRecord.Key != key {
+			report.add(key, 0, indexRecord.Offset, "index record has key %q", indexRecord.Key)
+		}
+		if !s.bloom.MayContain(key) {
+			report.add(key, 0, indexRecord.Offset, "key is missing from the bloom filter")
+		}
+
+		recordBytes, err := s.readRecordBytes(reader, indexRecord)
+		if err != nil {
+			report.add(key, 0, indexRecord.Offset, "record can't be read: %s", err)
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(recordBytes, &record); err != nil {
+			report.add(key, 0, indexRecord.Offset, "doesn't point at a record, len %d", indexRecord.Len)
+			continue
+		}
+		if record.Key != key {
+			report.add(key, 0, indexRecord.Offset, "points at the record of key %q", record.Key)
+			continue
+		}
+		if record.Type != indexRecord.Type {
+			report.add(key, 0, indexRecord.Offset, "index type %s differs from record type %s", indexRecord.Type, record.Type)
+		}
+	}
+}
+
+// payloadProblem describes how the payload of a record doesn't match its
+// type, it's empty for a valid record
+func (r *Record) payloadProblem() string {
+	payloads := map[string]bool{
+		"string_record":      r.StringRecord != nil,
+		"hash_record":        r.HashRecord != nil,
+		"list_record":        r.ListRecord != nil,
+		"ordered_set_record": r.OrdderSetRecord != nil,
+		"set_record":         r.SetRecord != nil,
+	}
+	expected := map[string]string{
+		StringType: "string_record",
+		HashType:   "hash_record",
+		ListType:   "list_record",
+		ZSetType:   "ordered_set_record",
+		SetType:    "set_record",
+	}
+
+	payload, ok := expected[r.Type]
+	if !ok && r.Type != TombstoneType {
+		return fmt.Sprintf("unknown type %q", r.Type)
+	}
+	if ok && !payloads[payload] {
+		return fmt.Sprintf("type %s has no %s", r.Type, payload)
+	}
+	for _, name := range []string{"string_record", "hash_record", "list_record", "ordered_set_record", "set_record"} {
+		if payloads[name] && name != payload {
+			return fmt.Sprintf("type %s has a %s", r.Type, name)
+		}
+	}
+	return ""
+}
+
+// decompressingReader streams the decompressed records of a records file
+func decompressingReader(in io.Reader, compression Compression) (io.ReadCloser, error) {
+	switch compression {
+	case NoCompression:
+		return io.NopCloser(in), nil
+	case GzipCompression:
+		return gzip.NewReader(in)
+	case ZstdCompression:
+		decoder, err := zstd.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, ErrUnknownCompression
+}
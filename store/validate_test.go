@@ -0,0 +1,153 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openBytes(recordsBytes []byte) OpenReaderSeekCloser {
+	return func() (io.ReadSeekCloser, error) {
+		return NewReadSeekCloser(bytes.NewReader(recordsBytes)), nil
+	}
+}
+
+func problems(report *ValidationReport) []string {
+	problems := []string{}
+	for _, problem := range report.Problems {
+		problems = append(problems, problem.String())
+	}
+	return problems
+}
+
+func TestBuildIndexDuplicateKeys(t *testing.T) {
+	recordsBytes := []byte(`{"key":"a","type":"string","string_record":{"value":"1"}}
+{"key":"b","type":"string","string_record":{"value":"2"}}
+{"key":"a","type":"string","string_record":{"value":"3"}}
+`)
+	index, err := BuildIndex(bytes.NewReader(recordsBytes))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, index.SortedKeys)
+	assert.Equal(t, int64(116), index.Index["a"].Offset)
+
+	parallel, err := buildIndexInChunks(bytes.NewReader(recordsBytes), int64(len(recordsBytes)), 2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, index, parallel)
+
+	var indexBytes bytes.Buffer
+	indexBytes.WriteString(`{"key":"a","offset":0,"len":58,"type":"string"}` + "\n")
+	assert.NoError(t, index.WriteJsonl(&indexBytes))
+	read, err := ReadJsonlIndex(&indexBytes, true)
+	assert.NoError(t, err)
+	assert.Equal(t, index, read)
+}
+
+func TestValidateRecords(t *testing.T) {
+	recordsBytes := []byte(`{"key":"a","type":"string","string_record":{"value":"1"}}
+{"key":"b","type":"hash"}
+{"key":"a","type":"string","string_record":{"value":"2"}}
+{"key":"c","type":"set","set_record":{"members":[]},"string_record":{"value":"x"}}
+{"key":"d","type":"tombstone"}
+{"key":"e","type":"graph"}
+`)
+	store, err := NewStoreFromRecords(openBytes(recordsBytes))
+	assert.NoError(t, err)
+
+	report, err := store.Validate()
+	assert.NoError(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 6, report.Records)
+	assert.Equal(t, []string{
+		`line 2 at offset 58, key "b": type hash has no hash_record`,
+		`line 3 at offset 84, key "a": duplicate key, first seen on line 1`,
+		`line 4 at offset 142, key "c": type set has a string_record`,
+		`line 6 at offset 256, key "e": unknown type "graph"`,
+	}, problems(report))
+}
+
+func TestValidateIndex(t *testing.T) {
+	recordsBytes := MockJsonlBytes(MockRecords())
+	index, err := BuildIndex(bytes.NewReader(recordsBytes))
+	assert.NoError(t, err)
+
+	store, err := NewStoreFromRecords(openBytes(recordsBytes))
+	assert.NoError(t, err)
+	report, err := store.Validate()
+	assert.NoError(t, err)
+	assert.True(t, report.OK(), problems(report))
+
+	// break an index: shift one record, point one at another, drop one
+	shifted := index.Index["key1:hash"]
+	shifted.Offset++
+	index.Index["key1:hash"] = shifted
+	misplaced := index.Index["key3:hash"]
+	misplaced.Key = "key2:hash"
+	index.Index["key2:hash"] = misplaced
+	delete(index.Index, "key4:set")
+	index.SortedKeys = keysWithout(index, "key4:set")
+
+	store, err = NewStoreFromRecordsWithIndexAndConfig(openBytes(recordsBytes), jsonlIndex(t, index), DefaultConfig())
+	assert.NoError(t, err)
+	report, err = store.Validate()
+	assert.NoError(t, err)
+	found := strings.Join(problems(report), "\n")
+	assert.Contains(t, found, `key "key4:set": key is missing from the index`)
+	assert.Contains(t, found, `index record of key "key1:hash" at offset`)
+	assert.Contains(t, found, `points at the record of key "key3:hash"`)
+}
+
+// keysWithout returns the sorted keys of index without key
+func keysWithout(index *StoreIndex, key string) []string {
+	keys := []string{}
+	for _, k := range index.SortedKeys {
+		if k != key {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func jsonlIndex(t *testing.T, index *StoreIndex) io.Reader {
+	var indexBytes bytes.Buffer
+	assert.NoError(t, index.WriteJsonl(&indexBytes))
+	return &indexBytes
+}
+
+func TestValidateCompressedRecords(t *testing.T) {
+	recordsBytes := MockJsonlBytes(MockRecords())
+	for _, compression := range []Compression{GzipCompression, ZstdCompression} {
+		var compressed bytes.Buffer
+		_, err := WriteCompressedRecords(bytes.NewReader(recordsBytes), &compressed, compression, 256)
+		assert.NoError(t, err)
+
+		store, err := NewStoreFromRecords(openBytes(compressed.Bytes()))
+		assert.NoError(t, err)
+		report, err := store.Validate()
+		assert.NoError(t, err)
+		assert.True(t, report.OK(), problems(report))
+		assert.Equal(t, len(MockRecords()), report.Records)
+	}
+}
+
+func TestLoadingRefusesInvalidStore(t *testing.T) {
+	recordsBytes := []byte(`{"key":"a","type":"string","string_record":{"value":"1"}}
+{"key":"a","type":"string","string_record":{"value":"2"}}
+`)
+	config := DefaultConfig()
+	config.Validate = true
+	_, err := NewStoreFromRecordsWithConfig(openBytes(recordsBytes), config)
+	var invalid *ErrInvalidStore
+	assert.True(t, errors.As(err, &invalid))
+	assert.Contains(t, err.Error(), "duplicate key")
+
+	// without validation, the last record of a key is served
+	store, err := NewStoreFromRecords(openBytes(recordsBytes))
+	assert.NoError(t, err)
+	record, err := store.GetRecord("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", record.StringRecord.Value)
+}